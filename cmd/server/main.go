@@ -1,29 +1,71 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
-	"io"
+	"log/slog"
 	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/appyzdl/Netrunner/pkg/http"
-	"github.com/appyzdl/Netrunner/pkg/http/status"
+	"github.com/appyzdl/Netrunner/pkg/http/admin"
+	"github.com/appyzdl/Netrunner/pkg/http/http2"
+	"github.com/appyzdl/Netrunner/pkg/metrics"
+	"github.com/appyzdl/Netrunner/pkg/proxyproto"
+	"github.com/appyzdl/Netrunner/pkg/trace"
+	"github.com/appyzdl/Netrunner/pkg/trace/otlp"
 )
 
-var connPool *http.ConnPool
-
 func main() {
+	// LOG_LEVEL=debug also logs every request with its (redacted) headers.
+	var level slog.Level
+	level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL")))
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level})))
+
 	router := http.NewRouter()
 
-	// Add middleware
+	// Add middleware. REQUEST_ID_TRUSTED=10.0.0.0/8 keeps the X-Request-Id
+	// set by load balancers in that range.
+	var trustedIDSources []string
+	if trusted := os.Getenv("REQUEST_ID_TRUSTED"); trusted != "" {
+		trustedIDSources = strings.Split(trusted, ",")
+	}
+	requestIDs, err := http.NewRequestIDAssigner(trustedIDSources...)
+	if err != nil {
+		fmt.Printf("Invalid REQUEST_ID_TRUSTED: %v\n", err)
+		requestIDs = &http.RequestIDAssigner{}
+	}
 	router.Use(http.LoggingMiddleware)
+	router.Use(http.RecoveryMiddleware)
+
+	// SERVER_TIMING=on reports handler timings to every client;
+	// SERVER_TIMING=https://app.example.com only to pages from that origin.
+	switch serverTiming := os.Getenv("SERVER_TIMING"); serverTiming {
+	case "":
+	case "on", "true", "1":
+		router.Use(http.NewServerTimingMiddleware(http.ServerTimingOptions{Enabled: true}))
+	default:
+		router.Use(http.NewServerTimingMiddleware(http.ServerTimingOptions{AllowedOrigins: strings.Split(serverTiming, ",")}))
+	}
 
-	connPool = http.NewConnPool(100) // Create a pool with 100 max connections
+	// OTEL_EXPORTER_OTLP_ENDPOINT=http://localhost:4318 exports request
+	// spans to an OpenTelemetry collector, sampled as OTEL_TRACES_SAMPLER
+	// and OTEL_TRACES_SAMPLER_ARG say.
+	if tracer := newTracer(); tracer != nil {
+		router.Tracer = tracer
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			tracer.Shutdown(ctx)
+		}()
+	}
 
 	// Add routes
 	router.AddRoute("GET", "/", handleRoot)
@@ -37,110 +79,223 @@ func main() {
 	staticHandler := http.StaticFileHandler(publicPath)
 	router.AddRoute("GET", "/static/", staticHandler)
 
-	// fmt.Printf("Serving static files from: %s\n", publicPath) // Debug log
-
-	// Start HTTP server
-	go startServer("http", ":8080", router)
-
-	// Start HTTPS server
-	go startServer("https", ":8000", router)
-
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGABRT)
-	<-quit
+	// Error pages named after their status, such as public/404.html
+	errorPages := http.NewErrorPages()
+	if err := errorPages.LoadDir("", publicPath); err != nil {
+		fmt.Printf("Failed to load error pages: %v\n", err)
+	} else {
+		router.ErrorPages = errorPages
+	}
 
-	fmt.Println("Server is shutting down...🪦")
-	connPool.CloseIdleConnections()
-	fmt.Println("Server stopped")
-}
+	// fmt.Printf("Serving static files from: %s\n", publicPath) // Debug log
 
-func startServer(protocol string, address string, router *http.Router) {
-	var listener net.Listener
-	var err error
+	// Wrapping the router rather than adding route middleware also counts
+	// 404s and HTTPS redirects.
+	serverMetrics := http.NewMetrics(metrics.DefaultRegistry)
+	handler := serverMetrics.Middleware(router.HandleRequest)
 
-	if protocol == "https" {
-		cert, err := tls.LoadX509KeyPair("cert.pem", "key.pem")
+	// ACCESS_LOG=/var/log/netrunner/access.log writes a Combined log that
+	// logrotate can rotate with a SIGHUP. Like the metrics, it wraps the
+	// router so 404s and HTTPS redirects are logged too.
+	if path := os.Getenv("ACCESS_LOG"); path != "" {
+		accessFile, err := http.NewRotatingFile(path)
 		if err != nil {
-			fmt.Printf("Failed to load TLS certificate: %v\n", err)
-			return
+			fmt.Printf("Failed to open access log: %v\n", err)
+		} else {
+			defer accessFile.Close()
+			defer accessFile.ReopenOnSignal()()
+			handler = http.NewAccessLog(accessFile, http.CombinedLogFormat).Middleware(handler)
 		}
-
-		tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
-		listener, err = tls.Listen("tcp", address, tlsConfig)
-	} else {
-		listener, err = net.Listen("tcp", address)
 	}
 
-	if err != nil {
-		fmt.Printf("Failed to start server: %v 😭\n", err)
-		return
+	// Request IDs are assigned outermost so every response, logged or not,
+	// carries one.
+	handler = requestIDs.Middleware(handler)
+
+	httpServer := http.NewServer(":8080", handler)
+	httpServer.Metrics = serverMetrics
+	http2.ConfigureH2C(httpServer, nil)
+	httpsServer := http.NewServer(":8000", handler)
+	httpsServer.Metrics = serverMetrics
+	httpsServer.TLSConfig = &tls.Config{}
+	http2.ConfigureServer(httpsServer, nil)
+
+	// ADMIN_ADDR=127.0.0.1:9090 or ADMIN_ADDR=unix:/run/netrunner/admin.sock
+	// serves health checks, metrics, profiles and the configuration. Only
+	// loopback addresses are accepted unless ADMIN_ALLOW_REMOTE=1.
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	adminEndpoints := admin.New()
+	adminEndpoints.Registry = metrics.DefaultRegistry
+	adminEndpoints.Config = effectiveConfig(adminAddr)
+	if adminAddr != "" {
+		listen := admin.Listen
+		if allow, _ := strconv.ParseBool(os.Getenv("ADMIN_ALLOW_REMOTE")); allow {
+			listen = admin.ListenPublic
+		}
+		adminListener, err := listen(adminAddr)
+		if err != nil {
+			fmt.Printf("Failed to start admin server: %v\n", err)
+		} else {
+			adminServer := http.NewServer(adminAddr, adminEndpoints.Router().HandleRequest)
+			go func() {
+				if err := adminServer.Serve(adminListener); err != nil && err != http.ErrServerClosed {
+					fmt.Printf("Admin server error: %v\n", err)
+				}
+			}()
+			defer adminServer.Close()
+		}
 	}
-	defer listener.Close()
 
-	fmt.Printf("Server listening on %s 🙋‍♀️\n", address)
+	// METRICS_ADDR=127.0.0.1:9091 serves Prometheus metrics on /metrics.
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		metricsRouter := http.NewRouter()
+		metricsRouter.AllowInsecure = true
+		metricsRouter.AddRoute("GET", "/metrics", http.MetricsHandler(metrics.DefaultRegistry))
+		metricsServer := http.NewServer(addr, metricsRouter.HandleRequest)
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fmt.Printf("Metrics server error: %v\n", err)
+			}
+		}()
+		defer metricsServer.Close()
+	}
 
-	for {
-		conn, err := listener.Accept()
+	// Start the HTTP and HTTPS servers. Readiness is only reported once
+	// both are listening.
+	ready := true
+	for _, s := range []struct {
+		protocol string
+		server   *http.Server
+	}{{"http", httpServer}, {"https", httpsServer}} {
+		listener, err := listen(s.protocol, s.server)
 		if err != nil {
-			fmt.Printf("Failed to accept connection: %v 😔\n", err)
+			fmt.Printf("Failed to start server: %v 😭\n", err)
+			ready = false
 			continue
 		}
-		go handleConnection(conn, router)
+		go serve(s.server, listener)
 	}
-}
-
-func handleConnection(conn net.Conn, router *http.Router) {
-	defer connPool.Put(conn) // Return the connection to the pool
+	adminEndpoints.SetReady(ready)
 
-	// Set a timeout for the entire request handling
-	conn.SetDeadline(time.Now().Add(30 * time.Second))
-
-	buffer := make([]byte, 1024)
-	n, err := conn.Read(buffer)
-	if err != nil && err != io.EOF {
-		handleConnectionError(conn, err)
-		return
+	// SHUTDOWN_DRAIN_DELAY=5s is how long to keep serving after readiness
+	// starts failing, so load balancers notice before connections close.
+	drainDelay := 5 * time.Second
+	if adminAddr == "" {
+		drainDelay = 0
+	}
+	if value := os.Getenv("SHUTDOWN_DRAIN_DELAY"); value != "" {
+		if delay, err := time.ParseDuration(value); err != nil {
+			fmt.Printf("Invalid SHUTDOWN_DRAIN_DELAY: %v\n", err)
+		} else {
+			drainDelay = delay
+		}
 	}
 
-	fmt.Printf("Received data: %s\n", string(buffer[:n])) // Debug print
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGABRT)
+	<-quit
 
-	var tlsConn *tls.ConnectionState
-	if tlsConnection, ok := conn.(*tls.Conn); ok {
-		state := tlsConnection.ConnectionState()
-		tlsConn = &state
+	fmt.Println("Server is shutting down...🪦")
+	// Fail readiness checks first so load balancers stop sending traffic.
+	adminEndpoints.SetReady(false)
+	time.Sleep(drainDelay)
+	// Let in-flight requests finish; HTTP/2 clients are sent GOAWAY.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, server := range []*http.Server{httpServer, httpsServer} {
+		if err := server.Shutdown(ctx); err != nil {
+			server.Close()
+		}
 	}
+	http.DefaultTransport.CloseIdleConnections()
+	fmt.Println("Server stopped")
+}
 
-	request, err := http.ParseRequest(buffer[:n], tlsConn)
+// listen opens the server's listener, with TLS for "https", so startup
+// errors are known before the server is reported ready.
+func listen(protocol string, server *http.Server) (net.Listener, error) {
+	listener, err := net.Listen("tcp", server.Addr)
 	if err != nil {
-		fmt.Printf("Error parsing request: %v\n", err)
-		handleHTTPError(conn, http.NewHTTPError(status.BadRequest, "Invalid request"))
-		return
+		return nil, err
 	}
 
-	response := router.HandleRequest(request)
-	_, err = conn.Write(http.FormatResponse(response))
-	if err != nil {
-		fmt.Printf("Error writing response: %v\n", err)
+	// PROXY_PROTOCOL_TRUSTED=10.0.0.0/8,192.168.1.5 accepts PROXY protocol
+	// headers from those load balancers.
+	if trusted := os.Getenv("PROXY_PROTOCOL_TRUSTED"); trusted != "" {
+		proxyListener, proxyErr := proxyproto.NewListener(listener, strings.Split(trusted, ",")...)
+		if proxyErr != nil {
+			listener.Close()
+			return nil, fmt.Errorf("invalid PROXY_PROTOCOL_TRUSTED: %w", proxyErr)
+		}
+		listener = proxyListener
+	}
+
+	if protocol == "https" {
+		cert, certErr := tls.LoadX509KeyPair("cert.pem", "key.pem")
+		if certErr != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", certErr)
+		}
+
+		tlsConfig := server.TLSConfig.Clone()
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		listener = tls.NewListener(listener, tlsConfig)
 	}
+	return listener, nil
 }
 
-func handleConnectionError(conn net.Conn, err error) {
-	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-		handleHTTPError(conn, http.NewHTTPError(status.StatusRequestTimeout, "Request timeout"))
-	} else {
-		fmt.Printf("Error reading from connection: %v\n", err)
+func serve(server *http.Server, listener net.Listener) {
+	fmt.Printf("Server listening on %s 🙋‍♀️\n", server.Addr)
+
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		fmt.Printf("Server error: %v 😔\n", err)
 	}
 }
 
-func handleHTTPError(conn net.Conn, err *http.HTTPError) {
-	response := http.NewResponse()
-	response.StatusCode = err.Code
-	response.StatusText = http.StatusText(err.Code)
-	response.SetBody([]byte(err.Message))
+func newTracer() *trace.Tracer {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint == "" && base != "" {
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	if endpoint == "" {
+		return nil
+	}
+	sampler, err := trace.ParseSampler(os.Getenv("OTEL_TRACES_SAMPLER"), os.Getenv("OTEL_TRACES_SAMPLER_ARG"))
+	if err != nil {
+		fmt.Printf("Tracing disabled: %v\n", err)
+		return nil
+	}
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "netrunner"
+	}
 
-	_, writeErr := conn.Write(http.FormatResponse(response))
-	if writeErr != nil {
-		fmt.Printf("Error writing error response: %v\n", writeErr)
+	tracer := trace.NewTracer(otlp.NewExporter(endpoint, serviceName))
+	tracer.Sampler = sampler
+	tracer.ErrorLog = func(err error) { slog.Warn("Span export failed", "error", err) }
+	return tracer
+}
+
+// effectiveConfig lists the settings the server is running with, for the
+// admin /config endpoint.
+func effectiveConfig(adminAddr string) map[string]any {
+	env := map[string]string{}
+	for _, name := range []string{
+		"LOG_LEVEL", "REQUEST_ID_TRUSTED", "SERVER_TIMING", "ACCESS_LOG",
+		"PROXY_PROTOCOL_TRUSTED", "METRICS_ADDR", "ADMIN_ALLOW_REMOTE",
+		"SHUTDOWN_DRAIN_DELAY", "OTEL_EXPORTER_OTLP_ENDPOINT",
+		"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_SERVICE_NAME",
+		"OTEL_TRACES_SAMPLER", "OTEL_TRACES_SAMPLER_ARG",
+	} {
+		if value, ok := os.LookupEnv(name); ok {
+			env[name] = value
+		}
+	}
+	return map[string]any{
+		"http_addr":  ":8080",
+		"https_addr": ":8000",
+		"admin_addr": adminAddr,
+		"env":        env,
 	}
 }
 
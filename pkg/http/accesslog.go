@@ -0,0 +1,288 @@
+package http
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access log formats. Custom formats use the same Apache mod_log_config
+// directives: %h %l %u %t %r %s %>s %b %B %D %T %m %U %q %H %v %a %A, plus
+// %{Name}i and %{Name}o for request and response headers.
+const (
+	CommonLogFormat   = `%h %l %u %t "%r" %>s %b`
+	CombinedLogFormat = `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-Agent}i"`
+
+	// JSONLogFormat writes one JSON object per request.
+	JSONLogFormat = "json"
+)
+
+// AccessLog writes a line per request to Out. Streamed responses are
+// logged once their body has been sent, so the byte count is accurate.
+type AccessLog struct {
+	Out    io.Writer
+	Format string // CommonLogFormat if empty
+
+	mu sync.Mutex
+}
+
+func NewAccessLog(out io.Writer, format string) *AccessLog {
+	return &AccessLog{Out: out, Format: format}
+}
+
+// accessEntry is what is known about a request once it has been served.
+type accessEntry struct {
+	req      *Request
+	resp     *Response
+	start    time.Time
+	duration time.Duration
+	bytes    int64
+}
+
+func (l *AccessLog) Middleware(next HandlerFunc) HandlerFunc {
+	return func(req *Request) *Response {
+		start := time.Now()
+		resp := next(req)
+		if resp == nil {
+			return resp
+		}
+
+		entry := &accessEntry{req: req, resp: resp, start: start}
+		if resp.BodyReader != nil && req.Method != "HEAD" {
+			resp.BodyReader = &accessLogBody{reader: resp.BodyReader, log: l, entry: entry}
+			return resp
+		}
+		if req.Method != "HEAD" {
+			entry.bytes = int64(len(resp.Body))
+		}
+		entry.duration = time.Since(start)
+		l.write(entry)
+		return resp
+	}
+}
+
+func (l *AccessLog) write(e *accessEntry) {
+	var line []byte
+	if l.Format == JSONLogFormat {
+		line = appendJSONEntry(nil, e)
+	} else {
+		format := l.Format
+		if format == "" {
+			format = CommonLogFormat
+		}
+		line = appendFormattedEntry(nil, format, e)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.Out.Write(line); err != nil {
+		e.req.Logger().Error("Access log write failed", "error", err)
+	}
+}
+
+// accessLogBody counts a streamed body and logs the request when the
+// server closes it.
+type accessLogBody struct {
+	reader io.Reader
+	log    *AccessLog
+	entry  *accessEntry
+	once   sync.Once
+}
+
+func (b *accessLogBody) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	b.entry.bytes += int64(n)
+	return n, err
+}
+
+func (b *accessLogBody) Close() error {
+	var err error
+	if closer, ok := b.reader.(io.Closer); ok {
+		err = closer.Close()
+	}
+	b.once.Do(func() {
+		b.entry.duration = time.Since(b.entry.start)
+		b.log.write(b.entry)
+	})
+	return err
+}
+
+func appendFormattedEntry(dst []byte, format string, e *accessEntry) []byte {
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' || i+1 == len(format) {
+			dst = append(dst, c)
+			continue
+		}
+		i++
+		var arg string
+		if format[i] == '{' {
+			end := strings.IndexByte(format[i:], '}')
+			if end < 0 || i+end+1 >= len(format) {
+				return append(dst, format[i-1:]...)
+			}
+			arg = format[i+1 : i+end]
+			i += end + 1
+		}
+		if format[i] == '>' && i+1 < len(format) {
+			i++ // %>s: the final status, which is the only one we have
+		}
+		dst = append(dst, e.directive(format[i], arg)...)
+	}
+	return dst
+}
+
+func (e *accessEntry) directive(verb byte, arg string) string {
+	req, resp := e.req, e.resp
+	switch verb {
+	case '%':
+		return "%"
+	case 'h', 'a':
+		return orDash(clientHost(req))
+	case 'A':
+		return orDash(remoteHost(req.LocalAddr))
+	case 'l':
+		return "-"
+	case 'u':
+		return orDash(escapeLogValue(basicAuthUser(req)))
+	case 't':
+		return e.start.Format("[02/Jan/2006:15:04:05 -0700]")
+	case 'r':
+		return escapeLogValue(fmt.Sprintf("%s %s %s", req.Method, req.Path, req.Version))
+	case 's':
+		return strconv.Itoa(resp.StatusCode)
+	case 'b':
+		if e.bytes == 0 {
+			return "-"
+		}
+		return strconv.FormatInt(e.bytes, 10)
+	case 'B':
+		return strconv.FormatInt(e.bytes, 10)
+	case 'D':
+		return strconv.FormatInt(e.duration.Microseconds(), 10)
+	case 'T':
+		return strconv.FormatInt(int64(e.duration/time.Second), 10)
+	case 'm':
+		return req.Method
+	case 'U':
+		return escapeLogValue(requestPath(req))
+	case 'q':
+		if _, query, ok := strings.Cut(req.Path, "?"); ok {
+			return escapeLogValue("?" + query)
+		}
+		return ""
+	case 'H':
+		return req.Version
+	case 'v':
+		return orDash(escapeLogValue(req.Headers["Host"]))
+	case 'i':
+		return orDash(escapeLogValue(req.Headers[arg]))
+	case 'o':
+		return orDash(escapeLogValue(resp.Headers[arg]))
+	}
+	return "%" + string(verb)
+}
+
+func appendJSONEntry(dst []byte, e *accessEntry) []byte {
+	req, resp := e.req, e.resp
+	record := struct {
+		Time       string  `json:"time"`
+		RemoteAddr string  `json:"remote_addr"`
+		ClientIP   string  `json:"client_ip"`
+		User       string  `json:"user,omitempty"`
+		Method     string  `json:"method"`
+		Path       string  `json:"path"`
+		Proto      string  `json:"proto"`
+		Host       string  `json:"host,omitempty"`
+		Status     int     `json:"status"`
+		Bytes      int64   `json:"bytes"`
+		DurationMS float64 `json:"duration_ms"`
+		UserAgent  string  `json:"user_agent,omitempty"`
+		Referer    string  `json:"referer,omitempty"`
+		TLSVersion string  `json:"tls_version,omitempty"`
+		RequestID  string  `json:"request_id,omitempty"`
+	}{
+		Time:       e.start.Format(time.RFC3339Nano),
+		RemoteAddr: req.RemoteAddr,
+		ClientIP:   clientHost(req),
+		User:       basicAuthUser(req),
+		Method:     req.Method,
+		Path:       req.Path,
+		Proto:      req.Version,
+		Host:       req.Headers["Host"],
+		Status:     resp.StatusCode,
+		Bytes:      e.bytes,
+		DurationMS: float64(e.duration.Microseconds()) / 1000,
+		UserAgent:  req.Headers["User-Agent"],
+		Referer:    req.Headers["Referer"],
+		RequestID:  req.RequestID(),
+	}
+	if req.TLS != nil {
+		record.TLSVersion = tls.VersionName(req.TLS.Version)
+	}
+	line, _ := json.Marshal(record)
+	return append(dst, line...)
+}
+
+// basicAuthUser returns the user name from Basic credentials, if any.
+func basicAuthUser(req *Request) string {
+	scheme, encoded, ok := strings.Cut(req.Headers["Authorization"], " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return ""
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return ""
+	}
+	user, _, _ := strings.Cut(string(decoded), ":")
+	return user
+}
+
+func remoteHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// clientHost is the client's IP address, falling back to the peer's.
+func clientHost(req *Request) string {
+	if req.ClientIP != "" {
+		return req.ClientIP
+	}
+	return remoteHost(req.RemoteAddr)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// escapeLogValue escapes quotes, backslashes and control characters the
+// way Apache does, so a client can't forge log lines.
+func escapeLogValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,237 @@
+// Package admin serves operational endpoints (health, readiness, metrics,
+// runtime stats, profiles, build info and configuration) on a listener
+// separate from the public one, such as a localhost port or a Unix socket.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+	"github.com/appyzdl/Netrunner/pkg/http/status"
+	"github.com/appyzdl/Netrunner/pkg/metrics"
+)
+
+// Admin holds what the admin endpoints report. It is not ready until
+// SetReady(true) is called.
+type Admin struct {
+	// Registry is served on /metrics if set.
+	Registry *metrics.Registry
+
+	// Config is the effective configuration, served as JSON on /config.
+	// It must not contain secrets.
+	Config any
+
+	ready   atomic.Bool
+	started time.Time
+}
+
+func New() *Admin {
+	return &Admin{started: time.Now()}
+}
+
+// SetReady sets what /readyz reports. Set it to false when a graceful
+// shutdown starts so load balancers stop sending traffic while in-flight
+// requests drain.
+func (a *Admin) SetReady(ready bool) {
+	a.ready.Store(ready)
+}
+
+func (a *Admin) Ready() bool {
+	return a.ready.Load()
+}
+
+// endpoints lists the routes for the index page.
+var endpoints = []struct{ path, description string }{
+	{"/healthz", "liveness: 200 while the process is up"},
+	{"/readyz", "readiness: 503 before startup and while draining"},
+	{"/metrics", "Prometheus metrics"},
+	{"/debug/runtime", "runtime and memory statistics"},
+	{"/debug/goroutines", "stacks of all goroutines"},
+	{"/debug/pprof/", "profiles for go tool pprof"},
+	{"/buildinfo", "Go version, module and VCS information"},
+	{"/config", "effective configuration"},
+}
+
+// Router returns a router serving the admin endpoints over plain HTTP.
+func (a *Admin) Router() *http.Router {
+	router := http.NewRouter()
+	router.AllowInsecure = true
+	router.AddRoute("GET", "/", a.index)
+	router.AddRoute("GET", "/healthz", a.healthz)
+	router.AddRoute("GET", "/readyz", a.readyz)
+	router.AddRoute("GET", "/debug/runtime", a.runtimeStats)
+	router.AddRoute("GET", "/debug/goroutines", goroutines)
+	router.AddRoute("GET", "/debug/pprof/*", pprofHandler)
+	router.AddRoute("POST", "/debug/pprof/symbol", symbol)
+	router.AddRoute("GET", "/buildinfo", buildInfo)
+	router.AddRoute("GET", "/config", a.config)
+	if a.Registry != nil {
+		router.AddRoute("GET", "/metrics", http.MetricsHandler(a.Registry))
+	}
+	return router
+}
+
+func textResponse(code int, body string) *http.Response {
+	resp := http.NewResponse()
+	resp.SetStatus(code)
+	resp.SetHeader("Content-Type", "text/plain; charset=utf-8")
+	resp.SetHeader("Cache-Control", "no-store")
+	resp.SetBody([]byte(body))
+	return resp
+}
+
+func jsonResponse(req *http.Request, v any) *http.Response {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return http.InternalServerErrorResponseFor(req)
+	}
+	resp := http.NewResponse()
+	resp.SetStatus(status.OK)
+	resp.SetHeader("Content-Type", "application/json")
+	resp.SetHeader("Cache-Control", "no-store")
+	resp.SetBody(append(body, '\n'))
+	return resp
+}
+
+// query parses the request's query string.
+func query(req *http.Request) url.Values {
+	_, raw, _ := strings.Cut(req.Path, "?")
+	values, _ := url.ParseQuery(raw)
+	return values
+}
+
+func (a *Admin) index(req *http.Request) *http.Response {
+	var b strings.Builder
+	for _, e := range endpoints {
+		if e.path == "/metrics" && a.Registry == nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%-20s %s\n", e.path, e.description)
+	}
+	return textResponse(status.OK, b.String())
+}
+
+func (a *Admin) healthz(req *http.Request) *http.Response {
+	return textResponse(status.OK, "ok\n")
+}
+
+func (a *Admin) readyz(req *http.Request) *http.Response {
+	if !a.Ready() {
+		return textResponse(status.ServiceUnavailable, "not ready\n")
+	}
+	return textResponse(status.OK, "ready\n")
+}
+
+func (a *Admin) runtimeStats(req *http.Request) *http.Response {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	var lastGC string
+	if mem.LastGC != 0 {
+		lastGC = time.Unix(0, int64(mem.LastGC)).UTC().Format(time.RFC3339Nano)
+	}
+	return jsonResponse(req, map[string]any{
+		"go_version":     runtime.Version(),
+		"goos":           runtime.GOOS,
+		"goarch":         runtime.GOARCH,
+		"num_cpu":        runtime.NumCPU(),
+		"gomaxprocs":     runtime.GOMAXPROCS(0),
+		"goroutines":     runtime.NumGoroutine(),
+		"cgo_calls":      runtime.NumCgoCall(),
+		"uptime_seconds": time.Since(a.started).Seconds(),
+		"memory": map[string]any{
+			"alloc_bytes":       mem.Alloc,
+			"total_alloc_bytes": mem.TotalAlloc,
+			"sys_bytes":         mem.Sys,
+			"heap_alloc_bytes":  mem.HeapAlloc,
+			"heap_inuse_bytes":  mem.HeapInuse,
+			"heap_idle_bytes":   mem.HeapIdle,
+			"heap_objects":      mem.HeapObjects,
+			"stack_inuse_bytes": mem.StackInuse,
+			"mallocs":           mem.Mallocs,
+			"frees":             mem.Frees,
+		},
+		"gc": map[string]any{
+			"num_gc":         mem.NumGC,
+			"pause_total_ns": mem.PauseTotalNs,
+			"last_gc":        lastGC,
+			"next_gc_bytes":  mem.NextGC,
+			"cpu_fraction":   mem.GCCPUFraction,
+		},
+	})
+}
+
+func buildInfo(req *http.Request) *http.Response {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return textResponse(status.NotFound, "build info unavailable\n")
+	}
+	settings := map[string]string{}
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+	deps := map[string]string{}
+	for _, dep := range info.Deps {
+		deps[dep.Path] = dep.Version
+	}
+	return jsonResponse(req, map[string]any{
+		"go_version": info.GoVersion,
+		"path":       info.Path,
+		"module":     info.Main.Path,
+		"version":    info.Main.Version,
+		"settings":   settings,
+		"deps":       deps,
+	})
+}
+
+func (a *Admin) config(req *http.Request) *http.Response {
+	if a.Config == nil {
+		return textResponse(status.NotFound, "no configuration registered\n")
+	}
+	return jsonResponse(req, a.Config)
+}
+
+// ErrNotLoopback is returned by Listen for a TCP address that isn't a
+// loopback address.
+var ErrNotLoopback = errors.New("admin: refusing to listen on a non-loopback address")
+
+// Listen listens on addr, a TCP address such as "127.0.0.1:9090" or a Unix
+// socket path prefixed with "unix:". A socket file left behind by an
+// earlier run is removed first. The admin endpoints expose profiles and
+// configuration, so TCP addresses must be loopback ones; use ListenPublic
+// to serve them on other interfaces deliberately.
+func Listen(addr string) (net.Listener, error) {
+	listener, err := ListenPublic(addr)
+	if err != nil {
+		return nil, err
+	}
+	// Check the bound address, which a host name or an empty host only
+	// reveals once resolved.
+	if tcpAddr, ok := listener.Addr().(*net.TCPAddr); ok && !tcpAddr.IP.IsLoopback() {
+		listener.Close()
+		return nil, fmt.Errorf("%w: %s", ErrNotLoopback, addr)
+	}
+	return listener, nil
+}
+
+// ListenPublic is Listen without the loopback check, for admin listeners
+// protected some other way, such as by a firewall.
+func ListenPublic(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
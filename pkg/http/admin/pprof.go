@@ -0,0 +1,169 @@
+package admin
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+	"github.com/appyzdl/Netrunner/pkg/http/status"
+)
+
+// maxProfileSeconds bounds CPU profiles and execution traces.
+const maxProfileSeconds = 300
+
+// pprofHandler serves the same endpoints as net/http/pprof under
+// /debug/pprof/, so "go tool pprof http://host/debug/pprof/heap" works.
+func pprofHandler(req *http.Request) *http.Response {
+	path, _, _ := strings.Cut(req.Path, "?")
+	name := strings.TrimPrefix(path, "/debug/pprof/")
+	switch name {
+	case "", "/debug/pprof":
+		return pprofIndex(req)
+	case "cmdline":
+		return textResponse(status.OK, strings.Join(os.Args, "\x00"))
+	case "profile":
+		return cpuProfile(req)
+	case "trace":
+		return executionTrace(req)
+	case "symbol":
+		return symbol(req)
+	}
+	return namedProfile(req, name)
+}
+
+func pprofIndex(req *http.Request) *http.Response {
+	profiles := pprof.Profiles()
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name() < profiles[j].Name() })
+
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head><title>/debug/pprof/</title></head>\n<body>\n<h1>/debug/pprof/</h1>\n<table>\n")
+	for _, p := range profiles {
+		name := html.EscapeString(p.Name())
+		fmt.Fprintf(&b, "<tr><td>%d</td><td><a href=\"%s?debug=1\">%s</a></td></tr>\n", p.Count(), name, name)
+	}
+	b.WriteString("<tr><td></td><td><a href=\"profile?seconds=30\">profile</a> (CPU, 30s)</td></tr>\n")
+	b.WriteString("<tr><td></td><td><a href=\"trace?seconds=5\">trace</a> (execution trace, 5s)</td></tr>\n")
+	b.WriteString("<tr><td></td><td><a href=\"cmdline\">cmdline</a></td></tr>\n")
+	b.WriteString("</table>\n</body>\n</html>\n")
+
+	resp := textResponse(status.OK, b.String())
+	resp.SetHeader("Content-Type", "text/html; charset=utf-8")
+	return resp
+}
+
+// namedProfile writes a runtime/pprof profile such as heap or goroutine,
+// in the binary format or, with ?debug=1 or 2, as text. ?gc=1 runs a
+// garbage collection before a heap profile.
+func namedProfile(req *http.Request, name string) *http.Response {
+	profile := pprof.Lookup(name)
+	if profile == nil {
+		return textResponse(status.NotFound, "unknown profile "+strconv.Quote(name)+"\n")
+	}
+	q := query(req)
+	debugLevel, _ := strconv.Atoi(q.Get("debug"))
+	if name == "heap" && q.Get("gc") != "" {
+		runtime.GC()
+	}
+
+	var buf bytes.Buffer
+	if err := profile.WriteTo(&buf, debugLevel); err != nil {
+		return textResponse(status.InternalServerError, err.Error()+"\n")
+	}
+	if debugLevel > 0 {
+		return textResponse(status.OK, buf.String())
+	}
+	return binaryResponse(name, buf.Bytes())
+}
+
+// goroutines dumps every goroutine's stack, like a crash or SIGQUIT does.
+// ?debug=1 groups identical stacks instead.
+func goroutines(req *http.Request) *http.Response {
+	debugLevel := 2
+	if query(req).Get("debug") == "1" {
+		debugLevel = 1
+	}
+	var buf bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&buf, debugLevel)
+	return textResponse(status.OK, buf.String())
+}
+
+func binaryResponse(name string, body []byte) *http.Response {
+	resp := http.NewResponse()
+	resp.SetStatus(status.OK)
+	resp.SetHeader("Content-Type", "application/octet-stream")
+	resp.SetHeader("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	resp.SetHeader("Cache-Control", "no-store")
+	resp.SetBody(body)
+	return resp
+}
+
+// profileSeconds reads ?seconds=, defaulting to def.
+func profileSeconds(req *http.Request, def int) (time.Duration, bool) {
+	seconds := def
+	if s := query(req).Get("seconds"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxProfileSeconds {
+			return 0, false
+		}
+		seconds = n
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+func cpuProfile(req *http.Request) *http.Response {
+	duration, ok := profileSeconds(req, 30)
+	if !ok {
+		return textResponse(status.BadRequest, fmt.Sprintf("seconds must be between 1 and %d\n", maxProfileSeconds))
+	}
+	var buf bytes.Buffer
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		// Only one CPU profile can run at a time.
+		return textResponse(status.Conflict, "could not start CPU profile: "+err.Error()+"\n")
+	}
+	time.Sleep(duration)
+	pprof.StopCPUProfile()
+	return binaryResponse("profile", buf.Bytes())
+}
+
+func executionTrace(req *http.Request) *http.Response {
+	duration, ok := profileSeconds(req, 1)
+	if !ok {
+		return textResponse(status.BadRequest, fmt.Sprintf("seconds must be between 1 and %d\n", maxProfileSeconds))
+	}
+	var buf bytes.Buffer
+	if err := trace.Start(&buf); err != nil {
+		return textResponse(status.Conflict, "could not start trace: "+err.Error()+"\n")
+	}
+	time.Sleep(duration)
+	trace.Stop()
+	return binaryResponse("trace", buf.Bytes())
+}
+
+// symbol looks up the functions at the program counters in a POST body
+// such as "0x4a2b3c+0x4a2b40", as go tool pprof does for some profiles.
+// A GET only reports that symbols are available.
+func symbol(req *http.Request) *http.Response {
+	var b strings.Builder
+	b.WriteString("num_symbols: 1\n")
+	if req.Method == "POST" {
+		for _, word := range strings.FieldsFunc(string(req.Body), func(r rune) bool { return r == '+' || r == ' ' || r == '\n' }) {
+			pc, err := strconv.ParseUint(word, 0, 64)
+			if err != nil {
+				continue
+			}
+			if fn := runtime.FuncForPC(uintptr(pc)); fn != nil {
+				fmt.Fprintf(&b, "%#x %s\n", pc, fn.Name())
+			}
+		}
+	}
+	return textResponse(status.OK, b.String())
+}
@@ -0,0 +1,128 @@
+package http
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var errInvalidChunk = errors.New("invalid chunked encoding")
+
+// chunkedReader decodes a body sent with "Transfer-Encoding: chunked"
+// (RFC 9112 section 7.1). Chunk extensions and trailers are discarded.
+type chunkedReader struct {
+	r         *bufio.Reader
+	remaining int64
+	done      bool
+	err       error
+}
+
+func newChunkedReader(r *bufio.Reader) io.Reader {
+	return &chunkedReader{r: r}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.done {
+		return 0, io.EOF
+	}
+	if c.remaining == 0 {
+		if c.err = c.nextChunk(); c.err != nil {
+			return 0, c.err
+		}
+		if c.done {
+			return 0, io.EOF
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if c.remaining == 0 && err == nil {
+		err = c.readCRLF()
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	c.err = err
+	return n, err
+}
+
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	size, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+	n, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+	if err != nil || n < 0 {
+		return errInvalidChunk
+	}
+	if n > 0 {
+		c.remaining = n
+		return nil
+	}
+
+	// Last chunk: skip trailer fields up to the terminating empty line.
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		if strings.TrimSpace(line) == "" {
+			c.done = true
+			return nil
+		}
+	}
+}
+
+func (c *chunkedReader) readCRLF() error {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(line) != "" {
+		return errInvalidChunk
+	}
+	return nil
+}
+
+// chunkedWriter encodes each Write as a single chunk. Close writes the
+// terminating zero-length chunk but does not close the underlying writer.
+type chunkedWriter struct {
+	w io.Writer
+}
+
+func newChunkedWriter(w io.Writer) io.WriteCloser {
+	return &chunkedWriter{w: w}
+}
+
+func (c *chunkedWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(c.w, "%x\r\n", len(p)); err != nil {
+		return 0, err
+	}
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(c.w, "\r\n")
+	return n, err
+}
+
+func (c *chunkedWriter) Close() error {
+	_, err := io.WriteString(c.w, "0\r\n\r\n")
+	return err
+}
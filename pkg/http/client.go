@@ -0,0 +1,295 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http/status"
+)
+
+// Transport sends HTTP/1.1 requests to upstream servers, keeping idle
+// connections in a ConnPool per host so they can be reused.
+type Transport struct {
+	DialTimeout           time.Duration
+	ResponseHeaderTimeout time.Duration
+	TLSConfig             *tls.Config
+	MaxIdleConnsPerHost   int
+
+	// Dial, if set, opens the TCP connections instead of a plain dial;
+	// TLS is layered on top for https.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	mu    sync.Mutex
+	pools map[string]*ConnPool
+}
+
+// DefaultTransport is used by clients and proxies that don't set their own.
+var DefaultTransport = &Transport{
+	DialTimeout:           10 * time.Second,
+	ResponseHeaderTimeout: 30 * time.Second,
+	MaxIdleConnsPerHost:   8,
+}
+
+// RoundTrip sends req to the server named by req.URL and reads the response
+// head. The caller must read or close the response's BodyReader so the
+// connection can be reused.
+func (t *Transport) RoundTrip(req *Request) (*Response, error) {
+	if req.URL == nil || req.URL.Host == "" {
+		return nil, errors.New("request URL has no host")
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported protocol scheme %q", req.URL.Scheme)
+	}
+	if req.Headers == nil {
+		req.Headers = make(map[string]string)
+	}
+
+	pool := t.pool(req.URL.Scheme + "://" + canonicalAddr(req.URL))
+	if conn, ok := pool.Idle(); ok {
+		resp, err := t.send(conn, pool, req)
+		// An idle connection may have been closed by the server while it sat
+		// in the pool. Retry on a fresh one only if sending the request
+		// twice is harmless: the body can be replayed and either the method
+		// is idempotent or nothing reached the server.
+		if err == nil || req.BodyReader != nil || !(isIdempotent(req.Method) || errors.Is(err, errNothingWritten)) {
+			return resp, err
+		}
+	}
+
+	conn, err := t.dial(req.Context(), req.URL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errNothingWritten, err)
+	}
+	return t.send(conn, pool, req)
+}
+
+// CloseIdleConnections closes every pooled connection.
+func (t *Transport) CloseIdleConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, pool := range t.pools {
+		pool.CloseIdleConnections()
+	}
+}
+
+func (t *Transport) pool(key string) *ConnPool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.pools == nil {
+		t.pools = make(map[string]*ConnPool)
+	}
+	pool, ok := t.pools[key]
+	if !ok {
+		maxIdle := t.MaxIdleConnsPerHost
+		if maxIdle <= 0 {
+			maxIdle = 2
+		}
+		pool = NewConnPool(maxIdle)
+		t.pools[key] = pool
+	}
+	return pool
+}
+
+func (t *Transport) dial(ctx context.Context, u *url.URL) (net.Conn, error) {
+	if t.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.DialTimeout)
+		defer cancel()
+	}
+	dial := t.Dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, "tcp", canonicalAddr(u))
+	if err != nil || u.Scheme != "https" {
+		return conn, err
+	}
+
+	config := &tls.Config{}
+	if t.TLSConfig != nil {
+		config = t.TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = u.Hostname()
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func (t *Transport) send(conn net.Conn, pool *ConnPool, req *Request) (*Response, error) {
+	written := &writeCounter{w: conn}
+	if err := req.Write(written); err != nil {
+		conn.Close()
+		if written.n == 0 {
+			return nil, fmt.Errorf("%w: %w", errNothingWritten, err)
+		}
+		return nil, err
+	}
+	// The timeout starts once the request is sent, so it doesn't cut off
+	// a slow upload.
+	if t.ResponseHeaderTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(t.ResponseHeaderTimeout))
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := ReadResponse(reader, req.Method)
+	// Skip interim responses such as 100 Continue; the body has already
+	// been sent, so only the final response matters.
+	for err == nil && status.IsInformational(resp.StatusCode) && resp.StatusCode != status.SwitchingProtocols {
+		resp, err = ReadResponse(reader, req.Method)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	reusable := resp.Version == "HTTP/1.1" &&
+		!strings.EqualFold(resp.Headers["Connection"], "close") &&
+		!strings.EqualFold(req.Headers["Connection"], "close") &&
+		resp.BodyReader != reader
+
+	release := func() {
+		if reusable {
+			pool.Put(conn)
+		} else {
+			conn.Close()
+		}
+	}
+	if resp.BodyReader == nil {
+		release()
+		return resp, nil
+	}
+	resp.BodyReader = &transportBody{reader: resp.BodyReader, conn: conn, release: release}
+	return resp, nil
+}
+
+// errNothingWritten marks a failed request none of which was written to
+// the connection, so it is safe to send again.
+var errNothingWritten = errors.New("request not sent")
+
+type writeCounter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *writeCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// isIdempotent reports whether sending a request with method twice has the
+// same effect as sending it once (RFC 9110, section 9.2.2).
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// transportBody hands the connection back to the pool once the body has
+// been read to the end, or closes it if the body is abandoned.
+type transportBody struct {
+	reader  io.Reader
+	conn    net.Conn
+	release func()
+	once    sync.Once
+}
+
+func (b *transportBody) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.release)
+	}
+	return n, err
+}
+
+func (b *transportBody) Close() error {
+	b.once.Do(func() { b.conn.Close() })
+	return nil
+}
+
+func canonicalAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// Client is a small HTTP/1.1 client on top of Transport. When Jar is set,
+// cookies are attached to outgoing requests and stored from responses.
+type Client struct {
+	Transport *Transport
+	Jar       *CookieJar
+}
+
+// Do sends the request. The caller must read or close the response body.
+// A request ID in the request's context is sent as X-Request-Id, and a
+// span in it gets a child span whose traceparent is sent, so requests
+// made while handling another one can be correlated with it.
+func (c *Client) Do(req *Request) (*Response, error) {
+	transport := c.Transport
+	if transport == nil {
+		transport = DefaultTransport
+	}
+	if req.Headers == nil {
+		req.Headers = make(map[string]string)
+	}
+	if id := RequestIDFromContext(req.Context()); id != "" {
+		if _, ok := req.Headers[RequestIDHeader]; !ok {
+			req.Headers[RequestIDHeader] = id
+		}
+	}
+
+	if c.Jar != nil {
+		for _, cookie := range c.Jar.Cookies(req.URL) {
+			req.AddCookie(cookie)
+		}
+	}
+
+	span := startClientSpan(req)
+	resp, err := transport.RoundTrip(req)
+	endClientSpan(span, resp, err)
+	if err != nil {
+		return nil, err
+	}
+	if c.Jar != nil {
+		if cookies := resp.ReadCookies(); len(cookies) > 0 {
+			c.Jar.SetCookies(req.URL, cookies)
+		}
+	}
+	return resp, nil
+}
+
+// Get issues a GET request for rawURL.
+func (c *Client) Get(rawURL string) (*Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	req := NewRequest()
+	req.Method = "GET"
+	req.URL = u
+	req.Path = u.RequestURI()
+	return c.Do(req)
+}
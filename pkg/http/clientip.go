@@ -0,0 +1,176 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// ClientIPResolver finds the real client address of requests that arrive
+// through reverse proxies or load balancers. Forwarding headers are only
+// believed when the immediate peer is a trusted proxy, and their address
+// chains are walked right to left, stopping at the first address that isn't
+// trusted, so a client can't spoof its address by sending the headers
+// itself.
+type ClientIPResolver struct {
+	TrustedProxies []netip.Prefix
+
+	// Headers are consulted in order and the first one present is used.
+	// Supported are "Forwarded" (RFC 7239), "X-Forwarded-For" and
+	// "X-Real-Ip".
+	Headers []string
+}
+
+// NewClientIPResolver trusts the given CIDRs or single addresses, such as
+// "10.0.0.0/8" or "::1".
+func NewClientIPResolver(trusted ...string) (*ClientIPResolver, error) {
+	prefixes, err := parsePrefixes(trusted)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientIPResolver{
+		TrustedProxies: prefixes,
+		Headers:        []string{"Forwarded", "X-Forwarded-For", "X-Real-Ip"},
+	}, nil
+}
+
+// parsePrefixes parses CIDRs or single addresses, such as "10.0.0.0/8" or
+// "::1".
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range list {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(s)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted address %q", s)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Middleware sets Request.ClientIP with Resolve and adds it to the
+// request's Logger.
+func (r *ClientIPResolver) Middleware(next HandlerFunc) HandlerFunc {
+	return func(req *Request) *Response {
+		req.ClientIP = r.Resolve(req)
+		req.SetLogger(req.Logger().With("client_ip", req.ClientIP))
+		return next(req)
+	}
+}
+
+// Resolve returns the client address for req, which is the peer's address
+// unless the peer is trusted and forwarded the request for someone else.
+func (r *ClientIPResolver) Resolve(req *Request) string {
+	peer, ok := parseForwardedAddr(req.RemoteAddr)
+	if !ok {
+		return remoteHost(req.RemoteAddr)
+	}
+	if !r.trusted(peer) {
+		return peer.String()
+	}
+
+	for _, name := range r.Headers {
+		value, present := req.Headers[name]
+		if !present {
+			continue
+		}
+		var chain []string
+		switch strings.ToLower(name) {
+		case "forwarded":
+			chain = forwardedFor(value)
+		case "x-real-ip":
+			chain = []string{strings.TrimSpace(value)}
+		default:
+			chain = strings.Split(value, ",")
+		}
+		return r.walk(peer, chain).String()
+	}
+	return peer.String()
+}
+
+// walk returns the rightmost address in chain that isn't a trusted proxy.
+// An address that can't be parsed, such as "unknown", ends the walk at the
+// proxy that reported it.
+func (r *ClientIPResolver) walk(peer netip.Addr, chain []string) netip.Addr {
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, ok := parseForwardedAddr(strings.TrimSpace(chain[i]))
+		if !ok {
+			return client
+		}
+		client = addr
+		if !r.trusted(addr) {
+			return addr
+		}
+	}
+	return client
+}
+
+func (r *ClientIPResolver) trusted(addr netip.Addr) bool {
+	return prefixesContain(r.TrustedProxies, addr)
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwardedAddr parses an address with or without a port, as found in
+// RemoteAddr and forwarding headers: "192.0.2.1", "192.0.2.1:4711",
+// "2001:db8::1" or "[2001:db8::1]:4711".
+func parseForwardedAddr(s string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// forwardedFor returns the for= parameter of every element of a Forwarded
+// header, in order. Elements without one are returned as "" so the chain
+// keeps its length.
+func forwardedFor(header string) []string {
+	var chain []string
+	for _, element := range splitQuoted(header, ',') {
+		var forValue string
+		for _, pair := range splitQuoted(element, ';') {
+			name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			if strings.EqualFold(name, "for") {
+				forValue = strings.Trim(value, `"`)
+			}
+		}
+		chain = append(chain, forValue)
+	}
+	return chain
+}
+
+// splitQuoted splits s at sep, except inside quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
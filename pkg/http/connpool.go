@@ -30,6 +30,19 @@ func (p *ConnPool) Get(network, address string) (net.Conn, error) {
 	}
 }
 
+// Idle returns a pooled connection without dialing a new one.
+func (p *ConnPool) Idle() (net.Conn, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case conn := <-p.conns:
+		return conn, true
+	default:
+		return nil, false
+	}
+}
+
 func (p *ConnPool) Put(conn net.Conn) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
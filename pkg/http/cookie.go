@@ -0,0 +1,319 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SameSite controls whether a cookie is sent with cross-site requests.
+type SameSite int
+
+const (
+	SameSiteDefaultMode SameSite = iota
+	SameSiteLaxMode
+	SameSiteStrictMode
+	SameSiteNoneMode
+)
+
+// ErrNoCookie is returned by Request.Cookie when the named cookie is absent.
+var ErrNoCookie = errors.New("named cookie not present")
+
+// Cookie represents an HTTP cookie as described in RFC 6265, plus the
+// SameSite and Partitioned (CHIPS) attributes.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Domain  string
+	Path    string
+	Expires time.Time
+
+	// MaxAge == 0 means no Max-Age attribute, MaxAge < 0 means "delete now"
+	// (serialized as Max-Age=0), MaxAge > 0 is the lifetime in seconds.
+	MaxAge int
+
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// String serializes the cookie for use in a Set-Cookie header. It returns
+// an empty string if the cookie name is invalid.
+func (c *Cookie) String() string {
+	if c == nil || !isCookieName(c.Name) {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	b.WriteString(sanitizeCookieValue(c.Value))
+
+	if c.Path != "" {
+		b.WriteString("; Path=")
+		b.WriteString(sanitizeCookiePath(c.Path))
+	}
+	if domain := strings.TrimPrefix(c.Domain, "."); domain != "" && isCookieDomain(domain) {
+		b.WriteString("; Domain=")
+		b.WriteString(domain)
+	}
+	if !c.Expires.IsZero() && c.Expires.Year() >= 1601 {
+		b.WriteString("; Expires=")
+		b.WriteString(c.Expires.UTC().Format(TimeFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=")
+		b.WriteString(strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	switch c.SameSite {
+	case SameSiteLaxMode:
+		b.WriteString("; SameSite=Lax")
+	case SameSiteStrictMode:
+		b.WriteString("; SameSite=Strict")
+	case SameSiteNoneMode:
+		b.WriteString("; SameSite=None")
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// TimeFormat is the date format used in HTTP headers such as Expires.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// ParseCookie parses the value of a Cookie request header into its
+// name/value pairs. Malformed pairs are skipped.
+func ParseCookie(line string) []*Cookie {
+	var cookies []*Cookie
+	for _, part := range strings.Split(line, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		if !isCookieName(name) {
+			continue
+		}
+		value, ok := parseCookieValue(strings.TrimSpace(value))
+		if !ok {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// ParseSetCookie parses the value of a single Set-Cookie response header.
+// Unknown attributes are ignored as required by RFC 6265 section 5.2.
+func ParseSetCookie(line string) (*Cookie, error) {
+	parts := strings.Split(line, ";")
+	name, value, ok := strings.Cut(parts[0], "=")
+	if !ok {
+		return nil, fmt.Errorf("invalid set-cookie: missing '=' in %q", parts[0])
+	}
+	name = strings.TrimSpace(name)
+	if !isCookieName(name) {
+		return nil, fmt.Errorf("invalid set-cookie name: %q", name)
+	}
+	value, ok = parseCookieValue(strings.TrimSpace(value))
+	if !ok {
+		return nil, fmt.Errorf("invalid set-cookie value for %q", name)
+	}
+
+	c := &Cookie{Name: name, Value: value}
+	for _, attr := range parts[1:] {
+		key, val, _ := strings.Cut(strings.TrimSpace(attr), "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+
+		switch key {
+		case "domain":
+			c.Domain = strings.ToLower(strings.TrimPrefix(val, "."))
+		case "path":
+			c.Path = val
+		case "expires":
+			if t, err := parseCookieExpires(val); err == nil {
+				c.Expires = t
+			}
+		case "max-age":
+			secs, err := strconv.Atoi(val)
+			if err != nil || (secs != 0 && val[0] == '0') {
+				break
+			}
+			if secs <= 0 {
+				c.MaxAge = -1
+			} else {
+				c.MaxAge = secs
+			}
+		case "secure":
+			c.Secure = true
+		case "httponly":
+			c.HttpOnly = true
+		case "samesite":
+			switch strings.ToLower(val) {
+			case "lax":
+				c.SameSite = SameSiteLaxMode
+			case "strict":
+				c.SameSite = SameSiteStrictMode
+			case "none":
+				c.SameSite = SameSiteNoneMode
+			default:
+				c.SameSite = SameSiteDefaultMode
+			}
+		case "partitioned":
+			c.Partitioned = true
+		}
+	}
+	return c, nil
+}
+
+// Cookies returns the cookies sent with the request.
+func (r *Request) Cookies() []*Cookie {
+	return ParseCookie(r.Headers["Cookie"])
+}
+
+// Cookie returns the named cookie sent with the request, or ErrNoCookie.
+func (r *Request) Cookie(name string) (*Cookie, error) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, ErrNoCookie
+}
+
+// AddCookie appends a cookie to the request's Cookie header.
+func (r *Request) AddCookie(c *Cookie) {
+	pair := c.Name + "=" + sanitizeCookieValue(c.Value)
+	if existing := r.Headers["Cookie"]; existing != "" {
+		r.Headers["Cookie"] = existing + "; " + pair
+	} else {
+		r.Headers["Cookie"] = pair
+	}
+}
+
+// SetCookie adds a Set-Cookie header to the response. Unlike SetHeader,
+// several cookies can be set on the same response.
+func (r *Response) SetCookie(c *Cookie) {
+	if c.String() != "" {
+		r.Cookies = append(r.Cookies, c)
+	}
+}
+
+// ReadCookies returns Cookies followed by the SetCookies lines that
+// ParseSetCookie accepts.
+func (r *Response) ReadCookies() []*Cookie {
+	cookies := append([]*Cookie(nil), r.Cookies...)
+	for _, line := range r.SetCookies {
+		if c, err := ParseSetCookie(line); err == nil {
+			cookies = append(cookies, c)
+		}
+	}
+	return cookies
+}
+
+func parseCookieExpires(val string) (time.Time, error) {
+	layouts := []string{
+		TimeFormat,
+		"Mon, 02-Jan-2006 15:04:05 MST",
+		"Monday, 02-Jan-06 15:04:05 MST",
+		"Mon Jan _2 15:04:05 2006",
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, val); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid cookie expiry: %q", val)
+}
+
+func isCookieName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isTokenChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTokenChar(c byte) bool {
+	if c <= ' ' || c >= 0x7f {
+		return false
+	}
+	return !strings.ContainsRune("()<>@,;:\\\"/[]?={}", rune(c))
+}
+
+func isCookieValueChar(c byte) bool {
+	return c >= 0x20 && c < 0x7f && c != '"' && c != ';' && c != '\\'
+}
+
+func parseCookieValue(raw string) (string, bool) {
+	if len(raw) > 1 && raw[0] == '"' && raw[len(raw)-1] == '"' {
+		raw = raw[1 : len(raw)-1]
+	}
+	for i := 0; i < len(raw); i++ {
+		if !isCookieValueChar(raw[i]) {
+			return "", false
+		}
+	}
+	return raw, true
+}
+
+// sanitizeCookieValue drops bytes that may not appear in a cookie value and
+// quotes values containing a space or comma.
+func sanitizeCookieValue(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if isCookieValueChar(v[i]) {
+			b.WriteByte(v[i])
+		}
+	}
+	v = b.String()
+	if strings.ContainsAny(v, " ,") {
+		return `"` + v + `"`
+	}
+	return v
+}
+
+func sanitizeCookiePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		if p[i] >= 0x20 && p[i] < 0x7f && p[i] != ';' {
+			b.WriteByte(p[i])
+		}
+	}
+	return b.String()
+}
+
+func isCookieDomain(domain string) bool {
+	if len(domain) > 255 {
+		return false
+	}
+	for i := 0; i < len(domain); i++ {
+		c := domain[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-' || c == '.' || c == '_' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
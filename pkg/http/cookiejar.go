@@ -0,0 +1,212 @@
+package http
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CookieJar stores cookies received from servers and returns the ones that
+// should be sent back on subsequent requests, following the domain and path
+// matching rules of RFC 6265 section 5. It is safe for concurrent use.
+//
+// The jar has no public suffix list, so it only refuses Domain attributes
+// that are single-label names (like "com") or that don't match the host.
+type CookieJar struct {
+	mu      sync.Mutex
+	entries map[string]*jarEntry
+	seq     uint64
+	now     func() time.Time
+}
+
+type jarEntry struct {
+	cookie   Cookie
+	hostOnly bool
+	persist  bool
+	expires  time.Time
+	created  time.Time
+	seq      uint64
+}
+
+func NewCookieJar() *CookieJar {
+	return &CookieJar{
+		entries: make(map[string]*jarEntry),
+		now:     time.Now,
+	}
+}
+
+// SetCookies stores cookies received in a response to a request for u.
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*Cookie) {
+	host, ok := jarHost(u)
+	if !ok {
+		return
+	}
+	secure := u.Scheme == "https" || u.Scheme == "wss"
+	defPath := defaultCookiePath(u.Path)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.now()
+	for _, c := range cookies {
+		if c.Secure && !secure {
+			// Insecure origins may not set Secure cookies (RFC 6265bis 5.4).
+			continue
+		}
+		if c.Partitioned && !c.Secure {
+			continue
+		}
+
+		e := &jarEntry{cookie: *c, created: now}
+		e.cookie.Domain, e.hostOnly, ok = cookieDomain(host, c.Domain)
+		if !ok {
+			continue
+		}
+		if e.cookie.Path == "" || e.cookie.Path[0] != '/' {
+			e.cookie.Path = defPath
+		}
+
+		switch {
+		case c.MaxAge < 0:
+			e.expires, e.persist = now, true
+		case c.MaxAge > 0:
+			e.expires, e.persist = now.Add(time.Duration(c.MaxAge)*time.Second), true
+		case !c.Expires.IsZero():
+			e.expires, e.persist = c.Expires, true
+		}
+
+		key := e.key()
+		if e.persist && !e.expires.After(now) {
+			delete(j.entries, key)
+			continue
+		}
+		if old, ok := j.entries[key]; ok {
+			e.created = old.created
+			e.seq = old.seq
+		} else {
+			j.seq++
+			e.seq = j.seq
+		}
+		j.entries[key] = e
+	}
+}
+
+// Cookies returns the cookies to send in a request for u, ordered by
+// longest path first and then by creation time as RFC 6265 recommends.
+func (j *CookieJar) Cookies(u *url.URL) []*Cookie {
+	host, ok := jarHost(u)
+	if !ok {
+		return nil
+	}
+	secure := u.Scheme == "https" || u.Scheme == "wss"
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.now()
+	var matched []*jarEntry
+	for key, e := range j.entries {
+		if e.persist && !e.expires.After(now) {
+			delete(j.entries, key)
+			continue
+		}
+		if e.cookie.Secure && !secure {
+			continue
+		}
+		if e.hostOnly {
+			if host != e.cookie.Domain {
+				continue
+			}
+		} else if !domainMatch(host, e.cookie.Domain) {
+			continue
+		}
+		if !pathMatch(path, e.cookie.Path) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+
+	sort.Slice(matched, func(a, b int) bool {
+		if len(matched[a].cookie.Path) != len(matched[b].cookie.Path) {
+			return len(matched[a].cookie.Path) > len(matched[b].cookie.Path)
+		}
+		return matched[a].seq < matched[b].seq
+	})
+
+	cookies := make([]*Cookie, 0, len(matched))
+	for _, e := range matched {
+		cookies = append(cookies, &Cookie{Name: e.cookie.Name, Value: e.cookie.Value})
+	}
+	return cookies
+}
+
+func (e *jarEntry) key() string {
+	return e.cookie.Domain + ";" + e.cookie.Path + ";" + e.cookie.Name
+}
+
+func jarHost(u *url.URL) (string, bool) {
+	if u == nil {
+		return "", false
+	}
+	host := strings.ToLower(u.Hostname())
+	return strings.TrimSuffix(host, "."), host != ""
+}
+
+// cookieDomain works out the domain a cookie is stored under and whether it
+// is host-only, rejecting Domain attributes the host may not set.
+func cookieDomain(host, domain string) (string, bool, bool) {
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	if domain == "" {
+		return host, true, true
+	}
+	if net.ParseIP(host) != nil {
+		// IP addresses may only set host-only cookies.
+		return host, true, domain == host
+	}
+	if domain != host && !strings.Contains(domain, ".") {
+		return "", false, false
+	}
+	if !domainMatch(host, domain) {
+		return "", false, false
+	}
+	return domain, false, true
+}
+
+// domainMatch implements RFC 6265 section 5.1.3.
+func domainMatch(host, domain string) bool {
+	if host == domain {
+		return true
+	}
+	return strings.HasSuffix(host, "."+domain) && net.ParseIP(host) == nil
+}
+
+// pathMatch implements RFC 6265 section 5.1.4.
+func pathMatch(reqPath, cookiePath string) bool {
+	if reqPath == cookiePath {
+		return true
+	}
+	if !strings.HasPrefix(reqPath, cookiePath) {
+		return false
+	}
+	return strings.HasSuffix(cookiePath, "/") || reqPath[len(cookiePath)] == '/'
+}
+
+// defaultCookiePath implements the default-path algorithm of RFC 6265
+// section 5.1.4.
+func defaultCookiePath(path string) string {
+	if path == "" || path[0] != '/' {
+		return "/"
+	}
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
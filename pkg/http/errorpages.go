@@ -0,0 +1,183 @@
+package http
+
+import (
+	"bytes"
+	"html/template"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrorPages holds custom HTML error pages per status code, optionally per
+// virtual host. Statuses without a page fall back to RenderError.
+type ErrorPages struct {
+	mu sync.RWMutex
+	// pages maps a lower-case host without port ("" for any host) to the
+	// page for each status code.
+	pages map[string]map[int]*errorPage
+}
+
+// errorPage is either a static body or a template.
+type errorPage struct {
+	body []byte
+	tmpl *template.Template
+}
+
+// ErrorPageData is what error page templates are executed with.
+type ErrorPageData struct {
+	Status     int
+	StatusText string
+	Title      string
+	Detail     string
+	Type       string
+	Instance   string
+	Method     string
+	Path       string
+	Host       string
+}
+
+func NewErrorPages() *ErrorPages {
+	return &ErrorPages{pages: make(map[string]map[int]*errorPage)}
+}
+
+// SetFile serves the contents of the file at path for code on host. An
+// empty host applies to every host without a page of its own.
+func (p *ErrorPages) SetFile(host string, code int, path string) error {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	p.set(host, code, &errorPage{body: body})
+	return nil
+}
+
+// SetTemplate renders tmpl with ErrorPageData for code on host.
+func (p *ErrorPages) SetTemplate(host string, code int, tmpl *template.Template) {
+	p.set(host, code, &errorPage{tmpl: tmpl})
+}
+
+// LoadDir adds a page for every file in dir named after a status code, such
+// as 404.html. Files ending in .tmpl, such as 500.tmpl, are parsed as
+// templates.
+func (p *ErrorPages) LoadDir(host, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		code, err := strconv.Atoi(strings.TrimSuffix(name, ext))
+		if entry.IsDir() || err != nil || code < 400 || code > 599 {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		switch ext {
+		case ".html":
+			err = p.SetFile(host, code, path)
+		case ".tmpl":
+			var tmpl *template.Template
+			if tmpl, err = template.ParseFiles(path); err == nil {
+				p.SetTemplate(host, code, tmpl)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *ErrorPages) set(host string, code int, page *errorPage) {
+	host = strings.ToLower(host)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pages[host] == nil {
+		p.pages[host] = make(map[int]*errorPage)
+	}
+	p.pages[host][code] = page
+}
+
+func (p *ErrorPages) lookup(req *Request, code int) *errorPage {
+	host := ""
+	if req != nil {
+		host = strings.ToLower(req.Headers["Host"])
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if page, ok := p.pages[host][code]; ok {
+		return page
+	}
+	return p.pages[""][code]
+}
+
+// Render returns the custom page for the error if there is one and the
+// client accepts HTML, and RenderError's response otherwise.
+func (p *ErrorPages) Render(req *Request, e *HTTPError) *Response {
+	page := p.lookup(req, e.Code)
+	if page == nil {
+		return RenderError(req, e)
+	}
+	var accept string
+	if req != nil {
+		accept = req.Headers["Accept"]
+	}
+	if negotiateErrorType(accept, htmlType) != htmlType {
+		return RenderError(req, e)
+	}
+
+	body := page.body
+	if page.tmpl != nil {
+		var buf bytes.Buffer
+		if err := page.tmpl.Execute(&buf, newErrorPageData(req, e)); err != nil {
+			logger := slog.Default()
+			if req != nil {
+				logger = req.Logger()
+			}
+			logger.Error("Error page template failed", "status", e.Code, "error", err)
+			return RenderError(req, e)
+		}
+		body = buf.Bytes()
+	}
+
+	resp := NewResponse()
+	resp.StatusCode = e.Code
+	resp.StatusText = StatusText(e.Code)
+	resp.SetHeader("Content-Type", htmlType)
+	resp.SetHeader("Vary", "Accept")
+	resp.SetBody(body)
+	return resp
+}
+
+func newErrorPageData(req *Request, e *HTTPError) ErrorPageData {
+	data := ErrorPageData{
+		Status:     e.Code,
+		StatusText: StatusText(e.Code),
+		Title:      e.title(),
+		Detail:     e.Message,
+		Type:       e.problemType(),
+		Instance:   e.Instance,
+	}
+	if req != nil {
+		data.Method = req.Method
+		data.Path = req.Path
+		data.Host = req.Headers["Host"]
+	}
+	return data
+}
+
+// renderError renders e with the error pages of the Router or Server that
+// handled req, if it has any.
+func renderError(req *Request, e *HTTPError) *Response {
+	if req != nil && req.errorPages != nil {
+		return req.errorPages.Render(req, e)
+	}
+	return RenderError(req, e)
+}
@@ -1,16 +1,86 @@
 package http
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
 
+	"github.com/appyzdl/Netrunner/pkg/http/status"
+)
+
+// HTTPError is an error with an HTTP status. Besides Code and Message it
+// carries the RFC 9457 problem details members used when the client asks
+// for application/problem+json.
 type HTTPError struct {
-	Code    int
+	Code int
+	// Message is the problem's "detail": a human-readable explanation of
+	// this occurrence.
 	Message string
+
+	Type       string         // URI identifying the problem type
+	Title      string         // summary of the problem type
+	Instance   string         // URI identifying this occurrence
+	Extensions map[string]any // extra members, such as "balance"
+
+	// Err is the underlying cause, if any. It is logged but never sent to
+	// the client.
+	Err error
 }
 
 func (e *HTTPError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("HTTP error %d: %s: %v", e.Code, e.Message, e.Err)
+	}
 	return fmt.Sprintf("HTTP error %d: %s", e.Code, e.Message)
 }
 
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
 func NewHTTPError(code int, message string) *HTTPError {
 	return &HTTPError{Code: code, Message: message}
 }
+
+// WrapHTTPError is like NewHTTPError but records err as the cause.
+func WrapHTTPError(code int, message string, err error) *HTTPError {
+	return &HTTPError{Code: code, Message: message, Err: err}
+}
+
+type (
+	// HandlerFuncE is a handler that can fail by returning an error instead
+	// of building the error response itself.
+	HandlerFuncE func(*Request) (*Response, error)
+
+	// ErrorHandler turns an error returned by a handler into a response.
+	ErrorHandler func(*Request, error) *Response
+)
+
+// DefaultErrorHandler renders an *HTTPError anywhere in err's chain, with
+// the router's or server's custom page if there is one. Any other error is
+// logged and becomes a bare 500, so internal details don't leak to the
+// client.
+func DefaultErrorHandler(req *Request, err error) *Response {
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		httpErr = NewHTTPError(status.InternalServerError, "")
+	}
+	if status.IsServerError(httpErr.Code) {
+		req.Logger().Error("Handler error", "error", err)
+	}
+	return renderError(req, httpErr)
+}
+
+// HandleErrors adapts h to a HandlerFunc, rendering any error it returns
+// with onError, or DefaultErrorHandler if onError is nil.
+func HandleErrors(h HandlerFuncE, onError ErrorHandler) HandlerFunc {
+	if onError == nil {
+		onError = DefaultErrorHandler
+	}
+	return func(req *Request) *Response {
+		resp, err := h(req)
+		if err != nil {
+			return onError(req, err)
+		}
+		return resp
+	}
+}
@@ -0,0 +1,431 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http/status"
+)
+
+// ForwardProxy is an explicit (client-configured) proxy. It forwards
+// absolute-form requests such as "GET http://example.com/ HTTP/1.1" and
+// opens CONNECT tunnels, subject to allow/deny lists of destinations and
+// optional Basic authentication via Proxy-Authorization.
+type ForwardProxy struct {
+	// Transport forwards absolute-form requests. Its Dial, if set, is
+	// only called with addresses that passed the access lists.
+	Transport   *Transport
+	DialTimeout time.Duration
+
+	// TunnelIdleTimeout closes a CONNECT tunnel once no data has flowed
+	// either way for this long; 0 means never.
+	TunnelIdleTimeout time.Duration
+
+	// AllowHosts and DenyHosts hold host names, "*.suffix" wildcards, IP
+	// addresses or CIDR ranges. Addresses and ranges are checked against
+	// every address a host name resolves to, and only permitted addresses
+	// are dialed, so a name can't be used to reach a denied network. An
+	// empty AllowHosts allows every host that isn't denied; deny entries
+	// always win.
+	AllowHosts []string
+	DenyHosts  []string
+
+	// AllowPorts and DenyPorts restrict destination ports the same way.
+	AllowPorts []int
+	DenyPorts  []int
+
+	// Credentials maps user names to passwords. When non-empty, clients must
+	// authenticate with Proxy-Authorization: Basic.
+	Credentials map[string]string
+	Realm       string
+
+	transportOnce sync.Once
+	transport     *Transport
+}
+
+func NewForwardProxy() *ForwardProxy {
+	return &ForwardProxy{
+		DialTimeout:       10 * time.Second,
+		TunnelIdleTimeout: 5 * time.Minute,
+		Realm:             "Netrunner",
+	}
+}
+
+func (p *ForwardProxy) HandleRequest(req *Request) *Response {
+	if !p.authenticate(req) {
+		resp := proxyStatusResponse(status.ProxyAuthRequired)
+		resp.SetHeader("Proxy-Authenticate", fmt.Sprintf("Basic realm=%q", p.Realm))
+		return resp
+	}
+
+	if req.Method == "CONNECT" {
+		return p.connect(req)
+	}
+
+	if req.URL == nil || req.URL.Host == "" || (req.URL.Scheme != "http" && req.URL.Scheme != "https") {
+		return proxyStatusResponse(status.BadRequest)
+	}
+	if !p.allowed(req.URL.Hostname(), portOf(req.URL.Port(), req.URL.Scheme)) {
+		req.Logger().Info("Proxy denied", "target", req.URL.Host)
+		return proxyStatusResponse(status.Forbidden)
+	}
+
+	out := NewRequest()
+	out.Method = req.Method
+	out.Version = "HTTP/1.1"
+	out.URL = req.URL
+	out.Path = req.URL.RequestURI()
+	out.Body = req.Body
+	out.BodyReader = req.BodyReader
+	for key, value := range req.Headers {
+		out.Headers[key] = value
+	}
+	removeHopByHopHeaders(out.Headers)
+	out.Headers["Host"] = req.URL.Host
+	appendHeader(out.Headers, "Via", strings.TrimPrefix(req.Version, "HTTP/")+" netrunner")
+
+	out.SetContext(req.Context())
+
+	resp, err := p.checkedTransport().RoundTrip(out)
+	if errors.Is(err, errDestinationDenied) {
+		req.Logger().Info("Proxy denied", "target", req.URL.Host, "error", err)
+		return proxyStatusResponse(status.Forbidden)
+	}
+	if err != nil {
+		req.Logger().Warn("Proxy error", "target", req.URL.Host, "error", err)
+		return proxyErrorResponse(err)
+	}
+	removeHopByHopHeaders(resp.Headers)
+	resp.Version = "HTTP/1.1"
+	return resp
+}
+
+// connect checks the CONNECT target and, once the client has been told
+// 200, dials it and copies bytes in both directions until either side
+// closes. Dialing only once the connection is handed over means nothing
+// leaks when the response is never written or can't take over the
+// connection, as over HTTP/2; a failed dial then closes the client's
+// connection instead of answering 502.
+func (p *ForwardProxy) connect(req *Request) *Response {
+	host, port, err := net.SplitHostPort(req.Path)
+	if err != nil {
+		return proxyStatusResponse(status.BadRequest)
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil || !p.allowed(host, portNum) {
+		req.Logger().Info("Proxy denied", "target", req.Path)
+		return proxyStatusResponse(status.Forbidden)
+	}
+
+	ctx, cancel := p.dialContext(req.Context())
+	ips, err := p.resolveAllowed(ctx, host)
+	cancel()
+	if errors.Is(err, errDestinationDenied) {
+		req.Logger().Info("Proxy denied", "target", req.Path, "error", err)
+		return proxyStatusResponse(status.Forbidden)
+	}
+	if err != nil {
+		req.Logger().Warn("Proxy error", "target", req.Path, "error", err)
+		return proxyErrorResponse(err)
+	}
+
+	logger := req.Logger()
+	resp := NewResponse()
+	resp.StatusCode = status.OK
+	resp.StatusText = "Connection Established"
+	resp.Hijack = func(conn net.Conn, reader *bufio.Reader) {
+		ctx, cancel := p.dialContext(context.Background())
+		upstream, err := dialFirst(ctx, (&net.Dialer{}).DialContext, ips, port)
+		cancel()
+		if err != nil {
+			logger.Warn("Proxy error", "target", req.Path, "error", err)
+			return
+		}
+		tunnel(conn, reader, upstream, p.TunnelIdleTimeout)
+	}
+	return resp
+}
+
+// dialContext bounds ctx by DialTimeout, if set.
+func (p *ForwardProxy) dialContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.DialTimeout > 0 {
+		return context.WithTimeout(ctx, p.DialTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// errDestinationDenied is returned when none of the addresses a
+// destination resolves to is permitted.
+var errDestinationDenied = errors.New("destination denied by proxy access lists")
+
+// checkedTransport returns a transport like Transport whose connections
+// only go to addresses permitted by the access lists.
+func (p *ForwardProxy) checkedTransport() *Transport {
+	p.transportOnce.Do(func() {
+		base := p.Transport
+		if base == nil {
+			base = DefaultTransport
+		}
+		p.transport = &Transport{
+			DialTimeout:           base.DialTimeout,
+			ResponseHeaderTimeout: base.ResponseHeaderTimeout,
+			TLSConfig:             base.TLSConfig,
+			MaxIdleConnsPerHost:   base.MaxIdleConnsPerHost,
+			Dial:                  p.dialAllowed(base.Dial),
+		}
+	})
+	return p.transport
+}
+
+// dialAllowed returns a dial function that resolves the host, drops every
+// address the access lists don't permit and connects to the first of the
+// remaining ones that answers, with dial or else a plain TCP dial. Dialing
+// the checked address rather than the name means DNS can't point a
+// permitted name at a denied network between the check and the dial.
+func (p *ForwardProxy) dialAllowed(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := p.resolveAllowed(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		return dialFirst(ctx, func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		}, ips, port)
+	}
+}
+
+// resolveAllowed resolves host and returns the addresses the access lists
+// permit, or errDestinationDenied if there are none.
+func (p *ForwardProxy) resolveAllowed(ctx context.Context, host string) ([]net.IP, error) {
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		var err error
+		if ips, err = net.DefaultResolver.LookupIP(ctx, "ip", host); err != nil {
+			return nil, err
+		}
+	}
+	allowed := ips[:0]
+	for _, ip := range ips {
+		if p.allowedIP(host, ip) {
+			allowed = append(allowed, ip)
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("%w: %s", errDestinationDenied, host)
+	}
+	return allowed, nil
+}
+
+// dialFirst connects to the first of ips that answers on port.
+func dialFirst(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), ips []net.IP, port string) (net.Conn, error) {
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := dial(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// tunnel copies bytes between client and upstream. With an idle timeout,
+// every read extends the deadlines of both connections, so a tunnel that
+// carries no data either way is closed rather than held forever by a
+// half-open peer.
+func tunnel(client net.Conn, clientReader *bufio.Reader, upstream net.Conn, idle time.Duration) {
+	defer upstream.Close()
+
+	touch := func() {}
+	if idle > 0 {
+		touch = func() {
+			deadline := time.Now().Add(idle)
+			client.SetDeadline(deadline)
+			upstream.SetDeadline(deadline)
+		}
+		touch()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		// The reader may already hold bytes the client sent after the
+		// CONNECT request, such as a TLS ClientHello.
+		io.Copy(upstream, &activityReader{r: clientReader, touch: touch})
+		closeWrite(upstream)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(client, &activityReader{r: upstream, touch: touch})
+		closeWrite(client)
+	}()
+	wg.Wait()
+}
+
+// activityReader calls touch whenever data is read.
+type activityReader struct {
+	r     io.Reader
+	touch func()
+}
+
+func (a *activityReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if n > 0 {
+		a.touch()
+	}
+	return n, err
+}
+
+// closeWrite half-closes a TCP connection so the peer sees EOF while data
+// can still flow the other way.
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(interface{ CloseWrite() error }); ok {
+		tcp.CloseWrite()
+	} else {
+		conn.Close()
+	}
+}
+
+func (p *ForwardProxy) authenticate(req *Request) bool {
+	if len(p.Credentials) == 0 {
+		return true
+	}
+	scheme, encoded, ok := strings.Cut(req.Headers["Proxy-Authorization"], " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return false
+	}
+	user, pass, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return false
+	}
+	expected, known := p.Credentials[user]
+	match := subtle.ConstantTimeCompare([]byte(pass), []byte(expected)) == 1
+	return known && match
+}
+
+// allowed checks a destination's host name and port against the lists.
+// The addresses it resolves to are checked by allowedIP when dialing.
+func (p *ForwardProxy) allowed(host string, port int) bool {
+	host = normalizeHost(host)
+	for _, pattern := range p.DenyHosts {
+		if matchHost(pattern, host) {
+			return false
+		}
+	}
+	for _, denied := range p.DenyPorts {
+		if port == denied {
+			return false
+		}
+	}
+
+	hostOK := len(p.AllowHosts) == 0
+	for _, pattern := range p.AllowHosts {
+		if matchHost(pattern, host) || isIPPattern(pattern) {
+			// Addresses are checked once the host has been resolved.
+			hostOK = true
+			break
+		}
+	}
+	portOK := len(p.AllowPorts) == 0
+	for _, allowed := range p.AllowPorts {
+		if port == allowed {
+			portOK = true
+			break
+		}
+	}
+	return hostOK && portOK
+}
+
+// allowedIP checks an address that host resolved to. It is permitted if
+// no address or range denies it and either the host name itself was
+// allowed or an allowed address or range covers it.
+func (p *ForwardProxy) allowedIP(host string, ip net.IP) bool {
+	for _, pattern := range p.DenyHosts {
+		if matchIP(pattern, ip) {
+			return false
+		}
+	}
+	if len(p.AllowHosts) == 0 {
+		return true
+	}
+	host = normalizeHost(host)
+	for _, pattern := range p.AllowHosts {
+		if matchIP(pattern, ip) || (!isIPPattern(pattern) && matchHost(pattern, host)) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func isIPPattern(pattern string) bool {
+	_, _, err := net.ParseCIDR(pattern)
+	return err == nil || net.ParseIP(pattern) != nil
+}
+
+// matchIP reports whether pattern is an IP address equal to ip or a CIDR
+// range containing it.
+func matchIP(pattern string, ip net.IP) bool {
+	if _, network, err := net.ParseCIDR(pattern); err == nil {
+		return network.Contains(ip)
+	}
+	if patternIP := net.ParseIP(pattern); patternIP != nil {
+		return patternIP.Equal(ip)
+	}
+	return false
+}
+
+// matchHost matches a host name against a name or "*.suffix" pattern.
+func matchHost(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return pattern == host
+}
+
+func portOf(port, scheme string) int {
+	if n, err := strconv.Atoi(port); err == nil {
+		return n
+	}
+	if scheme == "https" {
+		return 443
+	}
+	return 80
+}
+
+func proxyStatusResponse(code int) *Response {
+	resp := NewResponse()
+	resp.StatusCode = code
+	resp.StatusText = StatusText(code)
+	resp.SetHeader("Content-Type", "text/plain")
+	resp.SetBody([]byte(fmt.Sprintf("%d - %s", code, StatusText(code))))
+	return resp
+}
@@ -63,35 +63,33 @@ func StaticFileHandler(basePath string) HandlerFunc {
 
 		// Ensure the path doesn't try to access parent directories
 		if strings.Contains(filePath, "..") {
-			return NotFoundResponse()
+			return NotFoundResponseFor(req)
 		}
 
 		fullPath := filepath.Join(basePath, filePath)
 
-		fmt.Printf("Attempting to serve file: %s\n", fullPath) // Debug log
-
 		file, err := os.Open(fullPath)
 		if err != nil {
-			fmt.Printf("Error opening file: %v\n", err) // Debug log
-			return NotFoundResponse()
+			req.Logger().Debug("Static file not found", "file", fullPath, "error", err)
+			return NotFoundResponseFor(req)
 		}
 		defer file.Close()
 
 		stat, err := file.Stat()
 		if err != nil {
-			fmt.Printf("Error getting file stats: %v\n", err) // Debug log
-			return InternalServerErrorResponse()
+			req.Logger().Error("Static file stat failed", "file", fullPath, "error", err)
+			return InternalServerErrorResponseFor(req)
 		}
 
 		if stat.IsDir() {
-			fmt.Println("Requested path is a directory") // Debug log
-			return NotFoundResponse()
+			req.Logger().Debug("Static file is a directory", "file", fullPath)
+			return NotFoundResponseFor(req)
 		}
 
 		content, err := os.ReadFile(fullPath)
 		if err != nil {
-			fmt.Printf("Error reading file: %v\n", err) // Debug log
-			return InternalServerErrorResponse()
+			req.Logger().Error("Static file read failed", "file", fullPath, "error", err)
+			return InternalServerErrorResponseFor(req)
 		}
 
 		resp := NewResponse()
@@ -0,0 +1,31 @@
+package http2
+
+import (
+	"bufio"
+	"net"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+)
+
+// ConfigureH2C enables cleartext HTTP/2 on a Netrunner server's plain TCP
+// connections, for clients that know the server speaks it (RFC 9113
+// section 3.3) or ask to switch with "Upgrade: h2c" (RFC 7540 section
+// 3.2). Streams go to s.Handler, the same as HTTP/1.1 and TLS HTTP/2
+// requests. If conf is nil, NewServer's defaults are used.
+//
+// h2c has no encryption; only enable it where the network is trusted.
+func ConfigureH2C(s *http.Server, conf *Server) *Server {
+	if conf == nil {
+		conf = NewServer()
+	}
+	s.H2C = func(hs *http.Server, conn net.Conn, reader *bufio.Reader, upgrade *http.Request) {
+		conf.ServeConn(conn, &ServeConnOpts{
+			Handler:        hs.Handler,
+			Reader:         reader,
+			UpgradeRequest: upgrade,
+			Logger:         hs.Logger,
+		})
+	}
+	s.RegisterOnShutdown(conf.Shutdown)
+	return conf
+}
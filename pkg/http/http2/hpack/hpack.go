@@ -0,0 +1,378 @@
+// Package hpack implements HPACK header compression for HTTP/2 (RFC 7541).
+package hpack
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultTableSize is the initial dynamic table size of both peers.
+const DefaultTableSize = 4096
+
+// HeaderField is a name-value pair. Sensitive fields are never added to a
+// compression table, so they can't be recovered by compression oracles.
+type HeaderField struct {
+	Name, Value string
+	Sensitive   bool
+}
+
+// Size is the field's size for table accounting (RFC 7541 section 4.1).
+func (f HeaderField) Size() int {
+	return len(f.Name) + len(f.Value) + 32
+}
+
+// DecodingError is a malformed header block. HTTP/2 treats it as a
+// connection error of type COMPRESSION_ERROR.
+type DecodingError struct {
+	Err error
+}
+
+func (e *DecodingError) Error() string {
+	return fmt.Sprintf("hpack: decoding error: %v", e.Err)
+}
+
+func (e *DecodingError) Unwrap() error {
+	return e.Err
+}
+
+// ErrHeaderListTooLarge is returned by Decode when the fields of a block
+// add up to more than MaxHeaderListSize. The block has still been decoded
+// to the end, so the dynamic table is intact and the connection usable.
+var ErrHeaderListTooLarge = errors.New("hpack: header list too large")
+
+var (
+	errNeedMore      = errors.New("truncated header block")
+	errIntOverflow   = errors.New("integer overflow")
+	errStringTooLong = errors.New("string literal too long")
+)
+
+// dynamicTable holds recently used fields, newest last.
+type dynamicTable struct {
+	fields  []HeaderField
+	size    int
+	maxSize int
+}
+
+func (t *dynamicTable) add(f HeaderField) {
+	t.fields = append(t.fields, f)
+	t.size += f.Size()
+	t.evict()
+}
+
+func (t *dynamicTable) setMaxSize(n int) {
+	t.maxSize = n
+	t.evict()
+}
+
+func (t *dynamicTable) evict() {
+	drop := 0
+	for t.size > t.maxSize && drop < len(t.fields) {
+		t.size -= t.fields[drop].Size()
+		drop++
+	}
+	if drop > 0 {
+		t.fields = append(t.fields[:0], t.fields[drop:]...)
+	}
+}
+
+// at returns the field at a combined static+dynamic index (1-based).
+func (t *dynamicTable) at(i int) (HeaderField, bool) {
+	if i < 1 {
+		return HeaderField{}, false
+	}
+	if i <= len(staticTable) {
+		return staticTable[i-1], true
+	}
+	i -= len(staticTable) + 1
+	if i >= len(t.fields) {
+		return HeaderField{}, false
+	}
+	return t.fields[len(t.fields)-1-i], true
+}
+
+// search returns the index of an entry matching the field exactly, or
+// failing that one with the same name, and whether the match was exact.
+func (t *dynamicTable) search(f HeaderField) (index int, exact bool) {
+	if i, ok := staticIndex[f.Name+"\x00"+f.Value]; ok {
+		return i, true
+	}
+	nameIndex, ok := staticIndex[f.Name]
+	if ok && f.Value == "" && staticTable[nameIndex-1].Value == "" {
+		return nameIndex, true
+	}
+	for i := len(t.fields) - 1; i >= 0; i-- {
+		if t.fields[i].Name != f.Name {
+			continue
+		}
+		index := len(staticTable) + len(t.fields) - i
+		if t.fields[i].Value == f.Value {
+			return index, true
+		}
+		if nameIndex == 0 {
+			nameIndex = index
+		}
+	}
+	return nameIndex, false
+}
+
+// Decoder decodes header blocks. Blocks must be decoded in the order they
+// were received, since each one can change the dynamic table.
+type Decoder struct {
+	table dynamicTable
+
+	// allowedMaxSize is the table size we advertised; the encoder may
+	// choose anything up to it.
+	allowedMaxSize int
+
+	// MaxStringLength bounds a single name or value; 0 means no limit.
+	MaxStringLength int
+
+	// MaxHeaderListSize bounds the total Size of a block's fields, counted
+	// as they are decoded so a block of repeated indexed fields can't
+	// expand without limit; 0 means no limit.
+	MaxHeaderListSize int
+}
+
+func NewDecoder(maxTableSize int) *Decoder {
+	return &Decoder{
+		table:          dynamicTable{maxSize: maxTableSize},
+		allowedMaxSize: maxTableSize,
+	}
+}
+
+// SetAllowedMaxDynamicTableSize changes the table size limit we advertise
+// in SETTINGS_HEADER_TABLE_SIZE.
+func (d *Decoder) SetAllowedMaxDynamicTableSize(n int) {
+	d.allowedMaxSize = n
+}
+
+// Decode decodes a complete header block.
+func (d *Decoder) Decode(block []byte) ([]HeaderField, error) {
+	var fields []HeaderField
+	sawField := false
+	size, tooLarge := 0, false
+	emit := func(f HeaderField) {
+		sawField = true
+		size += f.Size()
+		if d.MaxHeaderListSize > 0 && size > d.MaxHeaderListSize {
+			// Keep decoding for the table's sake but stop keeping fields.
+			tooLarge, fields = true, nil
+		}
+		if !tooLarge {
+			fields = append(fields, f)
+		}
+	}
+	for len(block) > 0 {
+		b := block[0]
+		var err error
+		switch {
+		case b&0x80 != 0: // indexed field
+			var index uint64
+			if index, block, err = readInt(block, 7); err != nil {
+				return nil, &DecodingError{err}
+			}
+			f, ok := d.table.at(int(index))
+			if !ok {
+				return nil, &DecodingError{fmt.Errorf("invalid index %d", index)}
+			}
+			emit(HeaderField{Name: f.Name, Value: f.Value})
+
+		case b&0xc0 == 0x40: // literal with incremental indexing
+			var f HeaderField
+			if f, block, err = d.readLiteral(block, 6); err != nil {
+				return nil, err
+			}
+			d.table.add(f)
+			emit(f)
+
+		case b&0xe0 == 0x20: // dynamic table size update
+			if sawField {
+				return nil, &DecodingError{errors.New("table size update after header field")}
+			}
+			var size uint64
+			if size, block, err = readInt(block, 5); err != nil {
+				return nil, &DecodingError{err}
+			}
+			if size > uint64(d.allowedMaxSize) {
+				return nil, &DecodingError{fmt.Errorf("table size %d exceeds limit", size)}
+			}
+			d.table.setMaxSize(int(size))
+
+		default: // literal without indexing (0000) or never indexed (0001)
+			var f HeaderField
+			if f, block, err = d.readLiteral(block, 4); err != nil {
+				return nil, err
+			}
+			f.Sensitive = b&0x10 != 0
+			emit(f)
+		}
+	}
+	if tooLarge {
+		return nil, ErrHeaderListTooLarge
+	}
+	return fields, nil
+}
+
+func (d *Decoder) readLiteral(block []byte, prefix uint8) (HeaderField, []byte, error) {
+	var f HeaderField
+	index, block, err := readInt(block, prefix)
+	if err != nil {
+		return f, nil, &DecodingError{err}
+	}
+	if index > 0 {
+		named, ok := d.table.at(int(index))
+		if !ok {
+			return f, nil, &DecodingError{fmt.Errorf("invalid index %d", index)}
+		}
+		f.Name = named.Name
+	} else if f.Name, block, err = d.readString(block); err != nil {
+		return f, nil, err
+	}
+	if f.Value, block, err = d.readString(block); err != nil {
+		return f, nil, err
+	}
+	return f, block, nil
+}
+
+func (d *Decoder) readString(block []byte) (string, []byte, error) {
+	if len(block) == 0 {
+		return "", nil, &DecodingError{errNeedMore}
+	}
+	huffman := block[0]&0x80 != 0
+	length, block, err := readInt(block, 7)
+	if err != nil {
+		return "", nil, &DecodingError{err}
+	}
+	if uint64(len(block)) < length {
+		return "", nil, &DecodingError{errNeedMore}
+	}
+	if d.MaxStringLength > 0 && length > uint64(d.MaxStringLength) {
+		return "", nil, &DecodingError{errStringTooLong}
+	}
+	data, rest := block[:length], block[length:]
+	if !huffman {
+		return string(data), rest, nil
+	}
+	decoded, err := HuffmanDecode(data)
+	if err != nil {
+		return "", nil, &DecodingError{err}
+	}
+	if d.MaxStringLength > 0 && len(decoded) > d.MaxStringLength {
+		return "", nil, &DecodingError{errStringTooLong}
+	}
+	return string(decoded), rest, nil
+}
+
+// readInt decodes an integer with an N-bit prefix (RFC 7541 section 5.1).
+func readInt(block []byte, prefix uint8) (uint64, []byte, error) {
+	if len(block) == 0 {
+		return 0, nil, errNeedMore
+	}
+	mask := uint64(1)<<prefix - 1
+	n := uint64(block[0]) & mask
+	block = block[1:]
+	if n < mask {
+		return n, block, nil
+	}
+	var shift uint
+	for len(block) > 0 {
+		b := block[0]
+		block = block[1:]
+		n += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return n, block, nil
+		}
+		shift += 7
+		if shift >= 63 {
+			return 0, nil, errIntOverflow
+		}
+	}
+	return 0, nil, errNeedMore
+}
+
+func appendInt(dst []byte, first byte, prefix uint8, n uint64) []byte {
+	mask := uint64(1)<<prefix - 1
+	if n < mask {
+		return append(dst, first|byte(n))
+	}
+	dst = append(dst, first|byte(mask))
+	n -= mask
+	for n >= 0x80 {
+		dst = append(dst, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(dst, byte(n))
+}
+
+func appendString(dst []byte, s string) []byte {
+	if n := HuffmanEncodedLen(s); n < len(s) {
+		dst = appendInt(dst, 0x80, 7, uint64(n))
+		return AppendHuffman(dst, s)
+	}
+	dst = appendInt(dst, 0, 7, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// Encoder encodes header blocks, indexing repeated fields in its dynamic
+// table.
+type Encoder struct {
+	table dynamicTable
+
+	// pendingSize is a table size change not yet signalled to the peer.
+	pendingSize int
+	sizeChanged bool
+}
+
+func NewEncoder() *Encoder {
+	return &Encoder{table: dynamicTable{maxSize: DefaultTableSize}}
+}
+
+// SetMaxDynamicTableSize applies the peer's SETTINGS_HEADER_TABLE_SIZE. The
+// encoder never uses more than DefaultTableSize.
+func (e *Encoder) SetMaxDynamicTableSize(n int) {
+	if n > DefaultTableSize {
+		n = DefaultTableSize
+	}
+	if n == e.table.maxSize && !e.sizeChanged {
+		return
+	}
+	e.table.setMaxSize(n)
+	e.pendingSize = n
+	e.sizeChanged = true
+}
+
+// AppendFields appends the encoding of a header block to dst.
+func (e *Encoder) AppendFields(dst []byte, fields []HeaderField) []byte {
+	if e.sizeChanged {
+		dst = appendInt(dst, 0x20, 5, uint64(e.pendingSize))
+		e.sizeChanged = false
+	}
+	for _, f := range fields {
+		index, exact := e.table.search(f)
+		switch {
+		case exact && !f.Sensitive:
+			dst = appendInt(dst, 0x80, 7, uint64(index))
+		case f.Sensitive:
+			dst = appendInt(dst, 0x10, 4, uint64(index))
+			if index == 0 {
+				dst = appendString(dst, f.Name)
+			}
+			dst = appendString(dst, f.Value)
+		case f.Size() > e.table.maxSize/2:
+			// Large values would flush the table for little gain.
+			dst = appendInt(dst, 0x00, 4, uint64(index))
+			if index == 0 {
+				dst = appendString(dst, f.Name)
+			}
+			dst = appendString(dst, f.Value)
+		default:
+			dst = appendInt(dst, 0x40, 6, uint64(index))
+			if index == 0 {
+				dst = appendString(dst, f.Name)
+			}
+			dst = appendString(dst, f.Value)
+			e.table.add(HeaderField{Name: f.Name, Value: f.Value})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,95 @@
+package hpack
+
+import (
+	"errors"
+	"sync"
+)
+
+var errInvalidHuffman = errors.New("hpack: invalid Huffman-encoded data")
+
+// huffmanNode is a node of the decoding tree. Leaves have no children and
+// hold the decoded byte.
+type huffmanNode struct {
+	children [2]*huffmanNode
+	sym      byte
+}
+
+var (
+	huffmanRoot     *huffmanNode
+	huffmanRootOnce sync.Once
+)
+
+func buildHuffmanTree() {
+	huffmanRoot = &huffmanNode{}
+	for sym, code := range huffmanCodes {
+		n := huffmanRoot
+		for i := int(huffmanCodeLen[sym]) - 1; i >= 0; i-- {
+			bit := (code >> uint(i)) & 1
+			if n.children[bit] == nil {
+				n.children[bit] = &huffmanNode{}
+			}
+			n = n.children[bit]
+		}
+		n.sym = byte(sym)
+	}
+}
+
+// HuffmanDecode decodes a Huffman-encoded string literal.
+func HuffmanDecode(data []byte) ([]byte, error) {
+	huffmanRootOnce.Do(buildHuffmanTree)
+
+	out := make([]byte, 0, len(data)*8/5)
+	n := huffmanRoot
+	// depth and ones track the bits read since the last symbol, to check
+	// that the padding is a short run of 1s (a prefix of EOS).
+	depth, ones := 0, true
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> uint(i)) & 1
+			n = n.children[bit]
+			if n == nil {
+				// Only EOS (30 ones) leads off the tree; it must not appear.
+				return nil, errInvalidHuffman
+			}
+			depth++
+			ones = ones && bit == 1
+			if n.children[0] == nil && n.children[1] == nil {
+				out = append(out, n.sym)
+				n = huffmanRoot
+				depth, ones = 0, true
+			}
+		}
+	}
+	if depth > 7 || !ones {
+		return nil, errInvalidHuffman
+	}
+	return out, nil
+}
+
+// HuffmanEncodedLen returns the length of s once Huffman-encoded.
+func HuffmanEncodedLen(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodeLen[s[i]])
+	}
+	return (bits + 7) / 8
+}
+
+// AppendHuffman appends the Huffman encoding of s to dst, padded with 1s.
+func AppendHuffman(dst []byte, s string) []byte {
+	var acc uint64
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		acc = acc<<huffmanCodeLen[s[i]] | uint64(huffmanCodes[s[i]])
+		bits += int(huffmanCodeLen[s[i]])
+		for bits >= 8 {
+			bits -= 8
+			dst = append(dst, byte(acc>>uint(bits)))
+		}
+	}
+	if bits > 0 {
+		acc = acc<<uint(8-bits) | (1<<uint(8-bits) - 1)
+		dst = append(dst, byte(acc))
+	}
+	return dst
+}
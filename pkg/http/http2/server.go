@@ -0,0 +1,1401 @@
+// Package http2 implements the server side of HTTP/2 (RFC 9113) for
+// Netrunner: framing, HPACK, stream multiplexing and flow control, with
+// every stream handed to the same HandlerFunc as HTTP/1.1 requests.
+package http2
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+	"github.com/appyzdl/Netrunner/pkg/http/http2/hpack"
+	"github.com/appyzdl/Netrunner/pkg/http/status"
+)
+
+// NextProtoTLS is the ALPN protocol name for HTTP/2 over TLS.
+const NextProtoTLS = "h2"
+
+var (
+	errStreamReset = errors.New("http2: stream reset")
+	errConnClosed  = errors.New("http2: connection closed")
+)
+
+// Server holds the HTTP/2 settings shared by all connections.
+type Server struct {
+	// MaxConcurrentStreams limits streams a client may have open at once.
+	MaxConcurrentStreams uint32
+
+	// MaxReadFrameSize is the largest frame payload we accept.
+	MaxReadFrameSize uint32
+
+	// InitialWindowSize and InitialConnWindowSize are the receive windows
+	// for each stream and for the connection as a whole.
+	InitialWindowSize     int32
+	InitialConnWindowSize int32
+
+	// MaxHeaderListSize bounds the decoded request headers.
+	MaxHeaderListSize uint32
+
+	// IdleTimeout closes a connection that has had no open streams for
+	// this long.
+	IdleTimeout time.Duration
+
+	mu    sync.Mutex
+	conns map[*serverConn]struct{}
+}
+
+func NewServer() *Server {
+	return &Server{
+		MaxConcurrentStreams:  250,
+		MaxReadFrameSize:      1 << 20,
+		InitialWindowSize:     1 << 20,
+		InitialConnWindowSize: 1 << 20,
+		MaxHeaderListSize:     1 << 20,
+		IdleTimeout:           60 * time.Second,
+	}
+}
+
+// ConfigureServer enables HTTP/2 on a Netrunner server's TLS connections:
+// it advertises "h2" via ALPN in s.TLSConfig, serves connections that
+// negotiate it, and sends GOAWAY to them when s.Shutdown is called. If conf
+// is nil, NewServer's defaults are used.
+func ConfigureServer(s *http.Server, conf *Server) *Server {
+	if conf == nil {
+		conf = NewServer()
+	}
+	if s.TLSConfig == nil {
+		s.TLSConfig = &tls.Config{}
+	}
+	// RFC 9113 section 9.2: HTTP/2 over TLS requires TLS 1.2 or later.
+	if s.TLSConfig.MinVersion < tls.VersionTLS12 {
+		s.TLSConfig.MinVersion = tls.VersionTLS12
+	}
+	s.TLSConfig.NextProtos = addProto(s.TLSConfig.NextProtos, NextProtoTLS, true)
+	s.TLSConfig.NextProtos = addProto(s.TLSConfig.NextProtos, "http/1.1", false)
+
+	if s.TLSNextProto == nil {
+		s.TLSNextProto = make(map[string]func(*http.Server, net.Conn, *tls.ConnectionState, time.Duration))
+	}
+	s.TLSNextProto[NextProtoTLS] = func(hs *http.Server, conn net.Conn, state *tls.ConnectionState, handshake time.Duration) {
+		conf.ServeConn(conn, &ServeConnOpts{Handler: hs.Handler, Logger: hs.Logger, TLSHandshake: handshake})
+	}
+	s.RegisterOnShutdown(conf.Shutdown)
+	return conf
+}
+
+func addProto(protos []string, proto string, first bool) []string {
+	for _, p := range protos {
+		if p == proto {
+			return protos
+		}
+	}
+	if first {
+		return append([]string{proto}, protos...)
+	}
+	return append(protos, proto)
+}
+
+// ServeConnOpts are the per-connection options of ServeConn.
+type ServeConnOpts struct {
+	Handler http.HandlerFunc
+
+	// Reader, if set, is read from instead of the connection, for bytes
+	// that were buffered before the connection was handed over.
+	Reader *bufio.Reader
+
+	// UpgradeRequest is the HTTP/1.1 request that switched the connection
+	// to h2c, with its body already read. It is answered on stream 1.
+	UpgradeRequest *http.Request
+
+	// Logger receives the connection's log records and is the base of
+	// every request's Logger. Nil means slog.Default().
+	Logger *slog.Logger
+
+	// TLSHandshake is how long the connection's TLS handshake took. It is
+	// set as Request.TLSHandshake on the first stream, the one that waited
+	// for it.
+	TLSHandshake time.Duration
+}
+
+// Shutdown sends GOAWAY to every connection; each closes once its open
+// streams have finished.
+func (srv *Server) Shutdown() {
+	srv.mu.Lock()
+	conns := make([]*serverConn, 0, len(srv.conns))
+	for sc := range srv.conns {
+		conns = append(conns, sc)
+	}
+	srv.mu.Unlock()
+
+	for _, sc := range conns {
+		sc.startGracefulShutdown()
+	}
+}
+
+func (srv *Server) track(sc *serverConn, add bool) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if add {
+		if srv.conns == nil {
+			srv.conns = make(map[*serverConn]struct{})
+		}
+		srv.conns[sc] = struct{}{}
+	} else {
+		delete(srv.conns, sc)
+	}
+}
+
+// ServeConn serves HTTP/2 on a connection whose client is about to send the
+// connection preface. It returns when the connection is done.
+func (srv *Server) ServeConn(conn net.Conn, opts *ServeConnOpts) {
+	reader := opts.Reader
+	if reader == nil {
+		reader = bufio.NewReader(conn)
+	}
+	sc := &serverConn{
+		srv:              srv,
+		conn:             conn,
+		handler:          opts.Handler,
+		reader:           reader,
+		writer:           bufio.NewWriterSize(conn, 32<<10),
+		encoder:          hpack.NewEncoder(),
+		decoder:          hpack.NewDecoder(hpack.DefaultTableSize),
+		streams:          make(map[uint32]*stream),
+		peerMaxFrameSize: defaultMaxFrameSize,
+		peerWindowSize:   defaultWindowSize,
+		connSendWindow:   defaultWindowSize,
+		connRecvWindow:   srv.InitialConnWindowSize,
+		remoteAddr:       conn.RemoteAddr().String(),
+		localAddr:        conn.LocalAddr().String(),
+		logger:           opts.Logger,
+		tlsHandshake:     opts.TLSHandshake,
+	}
+	if sc.logger == nil {
+		sc.logger = slog.Default()
+	}
+	sc.cond = sync.NewCond(&sc.mu)
+	if up := opts.UpgradeRequest; up != nil && up.ConnID != 0 {
+		// Keep numbering the connection the HTTP/1.1 server started.
+		sc.connID = up.ConnID
+		sc.requests.Store(up.ConnSeq)
+	} else {
+		sc.connID = http.NextConnID()
+	}
+	sc.decoder.MaxStringLength = int(srv.MaxHeaderListSize)
+	sc.decoder.MaxHeaderListSize = int(srv.MaxHeaderListSize)
+	// Also matches connections wrapped by the HTTP/1 server for metrics.
+	if tlsConn, ok := conn.(interface{ ConnectionState() tls.ConnectionState }); ok {
+		state := tlsConn.ConnectionState()
+		sc.tlsState = &state
+	}
+
+	srv.track(sc, true)
+	defer srv.track(sc, false)
+	sc.serve(opts.UpgradeRequest)
+}
+
+type serverConn struct {
+	srv          *Server
+	conn         net.Conn
+	handler      http.HandlerFunc
+	reader       *bufio.Reader
+	tlsState     *tls.ConnectionState
+	tlsHandshake time.Duration
+	remoteAddr   string
+	localAddr    string
+	connID       uint64
+	requests     atomic.Uint64 // requests started, for Request.ConnSeq
+	logger       *slog.Logger
+
+	// The decoder and the pending header block belong to the read loop.
+	decoder         *hpack.Decoder
+	headerBlock     []byte
+	headerStreamID  uint32
+	headerEndStream bool
+	headerSelfDep   bool
+
+	// writeMu serializes frames and keeps the encoder's table in step
+	// with the order header blocks go out.
+	writeMu sync.Mutex
+	writer  *bufio.Writer
+	encoder *hpack.Encoder
+
+	// mu guards everything below; cond signals flow-control windows
+	// opening up and streams or the connection closing.
+	mu               sync.Mutex
+	cond             *sync.Cond
+	streams          map[uint32]*stream
+	maxStreamID      uint32
+	peerMaxFrameSize uint32
+	peerWindowSize   int32
+	connSendWindow   int64
+	connRecvWindow   int32
+	connRecvPending  int32
+	goAwaySent       bool
+	closed           bool
+	idleTimer        *time.Timer
+
+	// handlers counts running handlers, including those of streams the
+	// client has reset, against MaxConcurrentStreams. Otherwise opening
+	// and resetting streams in a loop (CVE-2023-44487) would start
+	// handlers without limit.
+	handlers int
+}
+
+type stream struct {
+	id   uint32
+	body *pipe
+
+	// cancel cancels the handler's request context once the stream is
+	// reset or the connection closes.
+	cancel context.CancelFunc
+
+	// finalSent is set once the final response HEADERS have been written;
+	// guarded by serverConn.writeMu.
+	finalSent bool
+
+	// Guarded by serverConn.mu.
+	sendWindow     int64
+	recvWindow     int32
+	recvPending    int32
+	remoteClosed   bool
+	reset          bool
+	declaredLength int64
+	received       int64
+}
+
+func (sc *serverConn) serve(upgrade *http.Request) {
+	defer sc.cleanup()
+
+	if upgrade != nil {
+		// RFC 7540 section 3.2.1: HTTP2-Settings is applied as if it were
+		// the client's first SETTINGS frame, acknowledged by the 101.
+		payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(upgrade.Headers["Http2-Settings"], "="))
+		if err == nil {
+			var settings []Setting
+			if settings, err = parseSettings(payload); err == nil {
+				err = sc.applySettings(settings)
+			}
+		}
+		if err != nil {
+			sc.logger.Debug("HTTP/2: invalid HTTP2-Settings", "remote", sc.remoteAddr, "error", err)
+			return
+		}
+	}
+
+	// Our preface is a SETTINGS frame, sent without waiting for the client's.
+	settings := []Setting{
+		{SettingMaxConcurrentStreams, sc.srv.MaxConcurrentStreams},
+		{SettingMaxFrameSize, sc.srv.MaxReadFrameSize},
+		{SettingInitialWindowSize, uint32(sc.srv.InitialWindowSize)},
+		{SettingMaxHeaderListSize, sc.srv.MaxHeaderListSize},
+		{SettingEnablePush, 0},
+	}
+	sc.writeFrame(FrameSettings, 0, 0, settingsPayload(settings))
+	if extra := sc.srv.InitialConnWindowSize - defaultWindowSize; extra > 0 {
+		sc.writeWindowUpdate(0, extra)
+	}
+
+	if upgrade != nil {
+		sc.startUpgradeStream(upgrade)
+	}
+
+	sc.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	preface := make([]byte, len(Preface))
+	if _, err := io.ReadFull(sc.reader, preface); err != nil || string(preface) != Preface {
+		sc.logger.Debug("HTTP/2: invalid preface", "remote", sc.remoteAddr)
+		return
+	}
+
+	first := true
+	for {
+		f, err := ReadFrame(sc.reader, sc.srv.MaxReadFrameSize)
+		if err != nil {
+			var connErr *ConnectionError
+			if errors.As(err, &connErr) {
+				sc.goAway(connErr.Code, connErr.Reason)
+			}
+			return
+		}
+		if first {
+			if f.Type != FrameSettings || f.Has(FlagAck) {
+				sc.goAway(ErrCodeProtocol, "expected SETTINGS as first frame")
+				return
+			}
+			first = false
+			sc.conn.SetReadDeadline(time.Time{})
+			sc.resetIdleTimer()
+		}
+
+		err = sc.processFrame(f)
+		var streamErr *StreamError
+		var connErr *ConnectionError
+		switch {
+		case errors.As(err, &streamErr):
+			sc.resetStreamID(streamErr.StreamID, streamErr.Code)
+		case errors.As(err, &connErr):
+			sc.goAway(connErr.Code, connErr.Reason)
+			return
+		case err != nil:
+			return
+		}
+	}
+}
+
+// cleanup runs when the read loop ends, failing every remaining stream.
+func (sc *serverConn) cleanup() {
+	sc.mu.Lock()
+	sc.closed = true
+	for id, st := range sc.streams {
+		st.reset = true
+		st.cancel()
+		if st.body != nil {
+			st.body.closeWithError(errConnClosed)
+		}
+		delete(sc.streams, id)
+	}
+	if sc.idleTimer != nil {
+		sc.idleTimer.Stop()
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+	sc.conn.Close()
+}
+
+func (sc *serverConn) processFrame(f *Frame) error {
+	if sc.headerBlock != nil && f.Type != FrameContinuation {
+		return &ConnectionError{ErrCodeProtocol, "expected CONTINUATION frame"}
+	}
+
+	switch f.Type {
+	case FrameSettings:
+		return sc.processSettings(f)
+	case FramePing:
+		if f.StreamID != 0 {
+			return &ConnectionError{ErrCodeProtocol, "PING on a stream"}
+		}
+		if len(f.Payload) != 8 {
+			return &ConnectionError{ErrCodeFrameSize, "PING payload must be 8 bytes"}
+		}
+		if !f.Has(FlagAck) {
+			return sc.writeFrame(FramePing, FlagAck, 0, f.Payload)
+		}
+		return nil
+	case FrameHeaders:
+		return sc.processHeaders(f)
+	case FrameContinuation:
+		return sc.processContinuation(f)
+	case FrameData:
+		return sc.processData(f)
+	case FrameWindowUpdate:
+		return sc.processWindowUpdate(f)
+	case FrameRSTStream:
+		return sc.processRSTStream(f)
+	case FramePriority:
+		if f.StreamID == 0 {
+			return &ConnectionError{ErrCodeProtocol, "PRIORITY on stream 0"}
+		}
+		if len(f.Payload) != 5 {
+			return &StreamError{f.StreamID, ErrCodeFrameSize, "PRIORITY payload must be 5 bytes"}
+		}
+		if binary.BigEndian.Uint32(f.Payload)&maxWindowSize == f.StreamID {
+			return &StreamError{f.StreamID, ErrCodeProtocol, "stream depends on itself"}
+		}
+		return nil // priorities are advisory; streams are served as they come
+	case FrameGoAway:
+		if f.StreamID != 0 {
+			return &ConnectionError{ErrCodeProtocol, "GOAWAY on a stream"}
+		}
+		sc.startGracefulShutdown()
+		return nil
+	case FramePushPromise:
+		return &ConnectionError{ErrCodeProtocol, "clients must not push"}
+	default:
+		return nil // unknown frame types are ignored
+	}
+}
+
+func (sc *serverConn) processSettings(f *Frame) error {
+	if f.StreamID != 0 {
+		return &ConnectionError{ErrCodeProtocol, "SETTINGS on a stream"}
+	}
+	if f.Has(FlagAck) {
+		if len(f.Payload) != 0 {
+			return &ConnectionError{ErrCodeFrameSize, "SETTINGS ACK with payload"}
+		}
+		return nil
+	}
+	settings, err := parseSettings(f.Payload)
+	if err != nil {
+		return err
+	}
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+	return sc.writeFrame(FrameSettings, FlagAck, 0, nil)
+}
+
+func (sc *serverConn) applySettings(settings []Setting) error {
+	for _, s := range settings {
+		switch s.ID {
+		case SettingHeaderTableSize:
+			sc.writeMu.Lock()
+			sc.encoder.SetMaxDynamicTableSize(int(s.Val))
+			sc.writeMu.Unlock()
+		case SettingEnablePush:
+			if s.Val > 1 {
+				return &ConnectionError{ErrCodeProtocol, "invalid ENABLE_PUSH"}
+			}
+		case SettingInitialWindowSize:
+			if s.Val > maxWindowSize {
+				return &ConnectionError{ErrCodeFlowControl, "INITIAL_WINDOW_SIZE too large"}
+			}
+			if err := sc.setPeerWindowSize(int32(s.Val)); err != nil {
+				return err
+			}
+		case SettingMaxFrameSize:
+			if s.Val < defaultMaxFrameSize || s.Val > maxAllowedFrameSize {
+				return &ConnectionError{ErrCodeProtocol, "invalid MAX_FRAME_SIZE"}
+			}
+			sc.mu.Lock()
+			sc.peerMaxFrameSize = s.Val
+			sc.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// setPeerWindowSize applies a new INITIAL_WINDOW_SIZE to every open
+// stream's send window (RFC 9113 section 6.9.2).
+func (sc *serverConn) setPeerWindowSize(size int32) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	delta := int64(size) - int64(sc.peerWindowSize)
+	sc.peerWindowSize = size
+	for _, st := range sc.streams {
+		st.sendWindow += delta
+		if st.sendWindow > maxWindowSize {
+			return &ConnectionError{ErrCodeFlowControl, "window size overflow"}
+		}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processHeaders(f *Frame) error {
+	if f.StreamID == 0 {
+		return &ConnectionError{ErrCodeProtocol, "HEADERS on stream 0"}
+	}
+	payload, err := unpad(f)
+	if err != nil {
+		return err
+	}
+	if f.Has(FlagPriority) {
+		if len(payload) < 5 {
+			return &ConnectionError{ErrCodeFrameSize, "HEADERS priority fields truncated"}
+		}
+		sc.headerSelfDep = binary.BigEndian.Uint32(payload)&maxWindowSize == f.StreamID
+		payload = payload[5:]
+	} else {
+		sc.headerSelfDep = false
+	}
+
+	sc.headerBlock = append([]byte{}, payload...)
+	sc.headerStreamID = f.StreamID
+	sc.headerEndStream = f.Has(FlagEndStream)
+	if f.Has(FlagEndHeaders) {
+		return sc.finishHeaders()
+	}
+	return nil
+}
+
+func (sc *serverConn) processContinuation(f *Frame) error {
+	if sc.headerBlock == nil || f.StreamID != sc.headerStreamID {
+		return &ConnectionError{ErrCodeProtocol, "unexpected CONTINUATION frame"}
+	}
+	sc.headerBlock = append(sc.headerBlock, f.Payload...)
+	if len(sc.headerBlock) > 2*int(sc.srv.MaxHeaderListSize) {
+		return &ConnectionError{ErrCodeEnhanceYourCalm, "header block too large"}
+	}
+	if f.Has(FlagEndHeaders) {
+		return sc.finishHeaders()
+	}
+	return nil
+}
+
+// finishHeaders decodes a complete header block and opens a stream for it,
+// or applies it as trailers to a stream that is already open.
+func (sc *serverConn) finishHeaders() error {
+	id, block, endStream := sc.headerStreamID, sc.headerBlock, sc.headerEndStream
+	sc.headerBlock = nil
+
+	fields, err := sc.decoder.Decode(block)
+	tooLarge := errors.Is(err, hpack.ErrHeaderListTooLarge)
+	if err != nil && !tooLarge {
+		return &ConnectionError{ErrCodeCompression, err.Error()}
+	}
+	if id%2 == 0 {
+		return &ConnectionError{ErrCodeProtocol, "client stream IDs must be odd"}
+	}
+
+	sc.mu.Lock()
+	st, open := sc.streams[id]
+	maxStreamID := sc.maxStreamID
+	sc.mu.Unlock()
+
+	if open {
+		if tooLarge {
+			return &StreamError{id, ErrCodeEnhanceYourCalm, "trailers too large"}
+		}
+		return sc.processTrailers(st, fields, endStream)
+	}
+	if id <= maxStreamID {
+		return &ConnectionError{ErrCodeStreamClosed, fmt.Sprintf("HEADERS on closed stream %d", id)}
+	}
+
+	sc.mu.Lock()
+	sc.maxStreamID = id
+	if sc.headerSelfDep {
+		sc.mu.Unlock()
+		return &StreamError{id, ErrCodeProtocol, "stream depends on itself"}
+	}
+	if sc.goAwaySent {
+		// Streams after the GOAWAY are not processed; the client retries them.
+		sc.mu.Unlock()
+		return nil
+	}
+	if uint32(sc.handlers) >= sc.srv.MaxConcurrentStreams {
+		sc.mu.Unlock()
+		return &StreamError{id, ErrCodeRefusedStream, "too many concurrent streams"}
+	}
+	sc.mu.Unlock()
+
+	var req *http.Request
+	if tooLarge {
+		// The decoder dropped the fields, so there is nothing to build the
+		// request from; it is answered with a 431 below.
+		req = sc.baseRequest()
+	} else if req, err = sc.newRequest(id, fields); err != nil {
+		return err
+	}
+
+	st = &stream{
+		id:             id,
+		sendWindow:     int64(sc.peerWindowSize),
+		recvWindow:     sc.srv.InitialWindowSize,
+		remoteClosed:   endStream,
+		declaredLength: -1,
+	}
+	if cl := req.Headers["Content-Length"]; cl != "" {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			return &StreamError{id, ErrCodeProtocol, "invalid content-length"}
+		}
+		st.declaredLength = n
+		if endStream && n != 0 {
+			return &StreamError{id, ErrCodeProtocol, "content-length mismatch"}
+		}
+	}
+	expect, hasExpect := req.Headers["Expect"]
+	if !endStream {
+		st.body = newPipe()
+		req.BodyReader = &requestBody{sc: sc, st: st, expectContinue: strings.EqualFold(expect, "100-continue")}
+	}
+
+	handler := sc.handler
+	switch {
+	case tooLarge:
+		handler = func(*http.Request) *http.Response {
+			return errorResponse(status.RequestHeaderFieldsTooLarge, "Request header too large")
+		}
+	case hasExpect && !strings.EqualFold(expect, "100-continue"):
+		handler = func(*http.Request) *http.Response {
+			return errorResponse(status.ExpectationFailed, "Unsupported expectation")
+		}
+	}
+
+	sc.startHandler(st, req, handler)
+	return nil
+}
+
+// startHandler opens st and serves req on it in a new goroutine.
+func (sc *serverConn) startHandler(st *stream, req *http.Request, handler http.HandlerFunc) {
+	ctx, cancel := context.WithCancel(req.Context())
+	req.SetContext(ctx)
+	st.cancel = cancel
+	req.SendInformational = sc.informationalSender(st)
+
+	sc.mu.Lock()
+	sc.streams[st.id] = st
+	sc.handlers++
+	if sc.idleTimer != nil {
+		sc.idleTimer.Stop()
+	}
+	sc.mu.Unlock()
+
+	go sc.runHandler(st, req, handler)
+}
+
+// informationalSender returns the Request.SendInformational of a stream,
+// which sends 1xx responses as extra HEADERS frames before the final ones.
+func (sc *serverConn) informationalSender(st *stream) func(int, map[string]string) error {
+	return func(code int, headers map[string]string) error {
+		fields := []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(code)}}
+		return sc.writeHeaders(st, appendHeaderFields(fields, headers), false)
+	}
+}
+
+// appendHeaderFields adds headers as lowercase HTTP/2 fields, leaving out
+// the connection-specific ones HTTP/2 forbids.
+func appendHeaderFields(fields []hpack.HeaderField, headers map[string]string) []hpack.HeaderField {
+	for key, value := range headers {
+		name := strings.ToLower(key)
+		switch name {
+		case "connection", "proxy-connection", "keep-alive", "transfer-encoding", "upgrade":
+			continue
+		}
+		fields = append(fields, hpack.HeaderField{Name: name, Value: value})
+	}
+	return fields
+}
+
+// startUpgradeStream serves the request that carried "Upgrade: h2c" as
+// stream 1, which starts out half-closed since the request is complete.
+func (sc *serverConn) startUpgradeStream(req *http.Request) {
+	for _, key := range []string{"Connection", "Upgrade", "Http2-Settings"} {
+		delete(req.Headers, key)
+	}
+	req.Version = "HTTP/2.0"
+	req.BodyReader = nil
+
+	st := &stream{
+		id:             1,
+		sendWindow:     int64(sc.peerWindowSize),
+		recvWindow:     sc.srv.InitialWindowSize,
+		remoteClosed:   true,
+		declaredLength: -1,
+	}
+	sc.mu.Lock()
+	sc.maxStreamID = 1
+	sc.mu.Unlock()
+
+	sc.startHandler(st, req, sc.handler)
+}
+
+func (sc *serverConn) processTrailers(st *stream, fields []hpack.HeaderField, endStream bool) error {
+	sc.mu.Lock()
+	remoteClosed := st.remoteClosed
+	sc.mu.Unlock()
+	if remoteClosed {
+		return &StreamError{st.id, ErrCodeStreamClosed, "HEADERS after END_STREAM"}
+	}
+	if !endStream {
+		return &StreamError{st.id, ErrCodeProtocol, "trailers without END_STREAM"}
+	}
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") {
+			return &StreamError{st.id, ErrCodeProtocol, "pseudo-header in trailers"}
+		}
+	}
+	return sc.endRemote(st)
+}
+
+// endRemote marks the client's side of a stream finished.
+func (sc *serverConn) endRemote(st *stream) error {
+	sc.mu.Lock()
+	st.remoteClosed = true
+	mismatch := st.declaredLength >= 0 && st.received != st.declaredLength
+	sc.mu.Unlock()
+	if mismatch {
+		return &StreamError{st.id, ErrCodeProtocol, "content-length mismatch"}
+	}
+	if st.body != nil {
+		st.body.closeWithError(io.EOF)
+	}
+	return nil
+}
+
+func (sc *serverConn) processData(f *Frame) error {
+	if f.StreamID == 0 {
+		return &ConnectionError{ErrCodeProtocol, "DATA on stream 0"}
+	}
+	length := int32(len(f.Payload))
+
+	sc.mu.Lock()
+	if length > sc.connRecvWindow {
+		sc.mu.Unlock()
+		return &ConnectionError{ErrCodeFlowControl, "connection window exceeded"}
+	}
+	sc.connRecvWindow -= length
+	st, open := sc.streams[f.StreamID]
+	if f.StreamID > sc.maxStreamID {
+		sc.mu.Unlock()
+		return &ConnectionError{ErrCodeProtocol, "DATA on idle stream"}
+	}
+	if !open || st.remoteClosed {
+		sc.mu.Unlock()
+		sc.returnConnWindow(length)
+		return &StreamError{f.StreamID, ErrCodeStreamClosed, "DATA on closed stream"}
+	}
+	if length > st.recvWindow {
+		sc.mu.Unlock()
+		sc.returnConnWindow(length)
+		return &StreamError{f.StreamID, ErrCodeFlowControl, "stream window exceeded"}
+	}
+	st.recvWindow -= length
+	sc.mu.Unlock()
+
+	data, err := unpad(f)
+	if err != nil {
+		return err
+	}
+	// Padding counts against flow control but is never read by the
+	// handler, so credit it back straight away.
+	if padding := length - int32(len(data)); padding > 0 {
+		sc.consumed(st, padding)
+	}
+
+	if len(data) > 0 {
+		sc.mu.Lock()
+		st.received += int64(len(data))
+		overflow := st.declaredLength >= 0 && st.received > st.declaredLength
+		sc.mu.Unlock()
+		if overflow {
+			return &StreamError{st.id, ErrCodeProtocol, "body exceeds content-length"}
+		}
+		st.body.write(data)
+	}
+	if f.Has(FlagEndStream) {
+		return sc.endRemote(st)
+	}
+	return nil
+}
+
+func (sc *serverConn) processWindowUpdate(f *Frame) error {
+	if len(f.Payload) != 4 {
+		return &ConnectionError{ErrCodeFrameSize, "WINDOW_UPDATE payload must be 4 bytes"}
+	}
+	incr := int64(binary.BigEndian.Uint32(f.Payload) & maxWindowSize)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if f.StreamID == 0 {
+		if incr == 0 {
+			return &ConnectionError{ErrCodeProtocol, "zero WINDOW_UPDATE increment"}
+		}
+		sc.connSendWindow += incr
+		if sc.connSendWindow > maxWindowSize {
+			return &ConnectionError{ErrCodeFlowControl, "connection window overflow"}
+		}
+		sc.cond.Broadcast()
+		return nil
+	}
+
+	if f.StreamID > sc.maxStreamID {
+		return &ConnectionError{ErrCodeProtocol, "WINDOW_UPDATE on idle stream"}
+	}
+	st, open := sc.streams[f.StreamID]
+	if !open {
+		return nil
+	}
+	if incr == 0 {
+		return &StreamError{f.StreamID, ErrCodeProtocol, "zero WINDOW_UPDATE increment"}
+	}
+	st.sendWindow += incr
+	if st.sendWindow > maxWindowSize {
+		return &StreamError{f.StreamID, ErrCodeFlowControl, "stream window overflow"}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processRSTStream(f *Frame) error {
+	if f.StreamID == 0 {
+		return &ConnectionError{ErrCodeProtocol, "RST_STREAM on stream 0"}
+	}
+	if len(f.Payload) != 4 {
+		return &ConnectionError{ErrCodeFrameSize, "RST_STREAM payload must be 4 bytes"}
+	}
+
+	sc.mu.Lock()
+	if f.StreamID > sc.maxStreamID {
+		sc.mu.Unlock()
+		return &ConnectionError{ErrCodeProtocol, "RST_STREAM on idle stream"}
+	}
+	st, open := sc.streams[f.StreamID]
+	sc.mu.Unlock()
+	if open {
+		sc.removeStream(st)
+	}
+	return nil
+}
+
+// newRequest validates a request header list (RFC 9113 section 8.3) and
+// turns it into a Request.
+func (sc *serverConn) newRequest(id uint32, fields []hpack.HeaderField) (*http.Request, error) {
+	malformed := func(reason string) error {
+		return &StreamError{id, ErrCodeProtocol, reason}
+	}
+
+	pseudo := make(map[string]string)
+	req := sc.baseRequest()
+
+	regular := false
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") {
+			if regular {
+				return nil, malformed("pseudo-header after regular header")
+			}
+			switch f.Name {
+			case ":method", ":scheme", ":path", ":authority":
+			default:
+				return nil, malformed("unknown pseudo-header " + f.Name)
+			}
+			if _, dup := pseudo[f.Name]; dup {
+				return nil, malformed("duplicate pseudo-header " + f.Name)
+			}
+			pseudo[f.Name] = f.Value
+			continue
+		}
+		regular = true
+		if f.Name != strings.ToLower(f.Name) {
+			return nil, malformed("uppercase header name")
+		}
+		switch f.Name {
+		case "connection", "proxy-connection", "keep-alive", "transfer-encoding", "upgrade":
+			return nil, malformed("connection-specific header " + f.Name)
+		case "te":
+			if f.Value != "trailers" {
+				return nil, malformed("TE other than trailers")
+			}
+		}
+		key := textproto.CanonicalMIMEHeaderKey(f.Name)
+		if existing, ok := req.Headers[key]; ok {
+			sep := ", "
+			if key == "Cookie" {
+				sep = "; "
+			}
+			req.Headers[key] = existing + sep + f.Value
+		} else {
+			req.Headers[key] = f.Value
+		}
+	}
+
+	req.Method = pseudo[":method"]
+	authority := pseudo[":authority"]
+	if authority != "" {
+		req.Headers["Host"] = authority
+	}
+
+	if req.Method == "CONNECT" {
+		if _, ok := pseudo[":scheme"]; ok {
+			return nil, malformed("CONNECT with :scheme")
+		}
+		if _, ok := pseudo[":path"]; ok || authority == "" {
+			return nil, malformed("CONNECT must only have :authority")
+		}
+		req.Path = authority
+		req.URL = &url.URL{Host: authority}
+		return req, nil
+	}
+
+	req.Path = pseudo[":path"]
+	if req.Method == "" || pseudo[":scheme"] == "" || req.Path == "" {
+		return nil, malformed("missing required pseudo-header")
+	}
+	if req.Path != "*" {
+		u, err := url.ParseRequestURI(req.Path)
+		if err != nil {
+			return nil, malformed("invalid :path")
+		}
+		req.URL = u
+	}
+	return req, nil
+}
+
+// baseRequest returns a request carrying the connection's details.
+func (sc *serverConn) baseRequest() *http.Request {
+	req := http.NewRequest()
+	req.Version = "HTTP/2.0"
+	req.TLS = sc.tlsState
+	req.RemoteAddr = sc.remoteAddr
+	req.LocalAddr = sc.localAddr
+	if host, _, err := net.SplitHostPort(sc.remoteAddr); err == nil {
+		req.ClientIP = host
+	}
+	req.ConnID = sc.connID
+	req.ConnSeq = sc.requests.Add(1)
+	if req.ConnSeq == 1 {
+		req.TLSHandshake = sc.tlsHandshake
+	}
+	return req
+}
+
+func (sc *serverConn) runHandler(st *stream, req *http.Request, handler http.HandlerFunc) {
+	defer func() {
+		st.cancel()
+		sc.mu.Lock()
+		sc.handlers--
+		sc.mu.Unlock()
+	}()
+	req.SetLogger(sc.logger.With(http.RequestAttrs(req)...).With("stream", st.id))
+	req.Logger().Debug("Received request", http.HeadersAttr("headers", req.Headers))
+
+	defer func() {
+		if v := recover(); v != nil {
+			if v != http.ErrAbortHandler {
+				req.Logger().Error("HTTP/2: panic serving stream", "panic", v, "stack", string(debug.Stack()))
+			}
+			sc.resetStream(st, ErrCodeInternal)
+		}
+	}()
+	resp := handler(req)
+	if resp == nil {
+		resp = http.InternalServerErrorResponseFor(req)
+	}
+	if resp.Hijack != nil {
+		// Upgrades and tunnels take over an HTTP/1.1 connection; tell the
+		// client to retry over HTTP/1.1.
+		sc.resetStream(st, ErrCodeHTTP11Required)
+		return
+	}
+	if err := sc.writeResponse(st, req, resp); err != nil && err != errStreamReset && err != errConnClosed {
+		req.Logger().Debug("HTTP/2: error writing response", "error", err)
+	}
+	sc.finishStream(st)
+}
+
+func (sc *serverConn) writeResponse(st *stream, req *http.Request, resp *http.Response) error {
+	if closer, ok := resp.BodyReader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	bodyAllowed := resp.StatusCode >= 200 && resp.StatusCode != status.NoContent && resp.StatusCode != status.NotModified
+	fields := []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(resp.StatusCode)}}
+	for key, value := range resp.Headers {
+		name := strings.ToLower(key)
+		switch name {
+		case "connection", "proxy-connection", "keep-alive", "transfer-encoding", "upgrade":
+			continue
+		case "content-length":
+			if !bodyAllowed {
+				continue
+			}
+		}
+		fields = append(fields, hpack.HeaderField{Name: name, Value: value})
+	}
+	if _, ok := resp.Headers["Content-Length"]; !ok && bodyAllowed && resp.BodyReader == nil {
+		fields = append(fields, hpack.HeaderField{Name: "content-length", Value: strconv.Itoa(len(resp.Body))})
+	}
+	for _, c := range resp.Cookies {
+		fields = append(fields, hpack.HeaderField{Name: "set-cookie", Value: c.String()})
+	}
+	for _, line := range resp.SetCookies {
+		fields = append(fields, hpack.HeaderField{Name: "set-cookie", Value: line})
+	}
+
+	noBody := !bodyAllowed || req.Method == "HEAD" || (resp.BodyReader == nil && len(resp.Body) == 0)
+	if err := sc.writeHeaders(st, fields, noBody); err != nil || noBody {
+		return err
+	}
+
+	if resp.BodyReader == nil {
+		return sc.writeData(st, resp.Body, true)
+	}
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := resp.BodyReader.Read(buf)
+		if n > 0 {
+			if werr := sc.writeData(st, buf[:n], false); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return sc.writeData(st, nil, true)
+		}
+		if err != nil {
+			sc.resetStream(st, ErrCodeInternal)
+			return err
+		}
+	}
+}
+
+func (sc *serverConn) writeHeaders(st *stream, fields []hpack.HeaderField, endStream bool) error {
+	sc.mu.Lock()
+	maxFrame := int(sc.peerMaxFrameSize)
+	reset, closed := st.reset, sc.closed
+	sc.mu.Unlock()
+	if closed {
+		return errConnClosed
+	}
+	if reset {
+		return errStreamReset
+	}
+
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+
+	// Interim (1xx) responses may only precede the final one.
+	interim := fields[0].Value[0] == '1'
+	if st.finalSent {
+		return http.ErrFinalResponseSent
+	}
+	st.finalSent = !interim
+
+	block := sc.encoder.AppendFields(nil, fields)
+	var out []byte
+	typ := FrameHeaders
+	for first := true; first || len(block) > 0; first = false {
+		chunk := block
+		if len(chunk) > maxFrame {
+			chunk = chunk[:maxFrame]
+		}
+		block = block[len(chunk):]
+
+		var flags uint8
+		if first && endStream {
+			flags |= FlagEndStream
+		}
+		if len(block) == 0 {
+			flags |= FlagEndHeaders
+		}
+		out = AppendFrame(out, typ, flags, st.id, chunk)
+		typ = FrameContinuation
+	}
+	if _, err := sc.writer.Write(out); err != nil {
+		return err
+	}
+	return sc.writer.Flush()
+}
+
+// writeData sends data on a stream, waiting for flow-control credit as
+// needed. With endStream, the last frame carries END_STREAM.
+func (sc *serverConn) writeData(st *stream, data []byte, endStream bool) error {
+	for {
+		n, err := sc.reserve(st, len(data))
+		if err != nil {
+			return err
+		}
+		chunk := data[:n]
+		data = data[n:]
+
+		var flags uint8
+		if endStream && len(data) == 0 {
+			flags = FlagEndStream
+		}
+		if len(chunk) > 0 || flags != 0 {
+			if err := sc.writeFrame(FrameData, flags, st.id, chunk); err != nil {
+				return err
+			}
+		}
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+// reserve takes up to want bytes of send window from the stream and the
+// connection, blocking until some is available.
+func (sc *serverConn) reserve(st *stream, want int) (int, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for {
+		if sc.closed {
+			return 0, errConnClosed
+		}
+		if st.reset {
+			return 0, errStreamReset
+		}
+		if want == 0 {
+			return 0, nil
+		}
+		n := int64(want)
+		n = min(n, st.sendWindow, sc.connSendWindow, int64(sc.peerMaxFrameSize))
+		if n > 0 {
+			st.sendWindow -= n
+			sc.connSendWindow -= n
+			return int(n), nil
+		}
+		sc.cond.Wait()
+	}
+}
+
+func (sc *serverConn) writeFrame(t FrameType, flags uint8, streamID uint32, payload []byte) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	if _, err := sc.writer.Write(AppendFrame(nil, t, flags, streamID, payload)); err != nil {
+		return err
+	}
+	return sc.writer.Flush()
+}
+
+func (sc *serverConn) writeWindowUpdate(streamID uint32, incr int32) error {
+	payload := binary.BigEndian.AppendUint32(nil, uint32(incr))
+	return sc.writeFrame(FrameWindowUpdate, 0, streamID, payload)
+}
+
+// consumed credits back n bytes of a stream's received data once the
+// handler has read them. Updates are batched until half a window is owed.
+func (sc *serverConn) consumed(st *stream, n int32) {
+	sc.mu.Lock()
+	var streamIncr, connIncr int32
+	if !st.remoteClosed && !st.reset {
+		st.recvPending += n
+		if st.recvPending >= sc.srv.InitialWindowSize/2 {
+			streamIncr = st.recvPending
+			st.recvWindow += streamIncr
+			st.recvPending = 0
+		}
+	}
+	sc.connRecvPending += n
+	if sc.connRecvPending >= sc.srv.InitialConnWindowSize/2 {
+		connIncr = sc.connRecvPending
+		sc.connRecvWindow += connIncr
+		sc.connRecvPending = 0
+	}
+	sc.mu.Unlock()
+
+	if streamIncr > 0 {
+		sc.writeWindowUpdate(st.id, streamIncr)
+	}
+	if connIncr > 0 {
+		sc.writeWindowUpdate(0, connIncr)
+	}
+}
+
+// returnConnWindow credits the connection window for data that will never
+// be read, such as frames for a stream that was already reset.
+func (sc *serverConn) returnConnWindow(n int32) {
+	if n <= 0 {
+		return
+	}
+	sc.mu.Lock()
+	sc.connRecvWindow += n
+	sc.mu.Unlock()
+	sc.writeWindowUpdate(0, n)
+}
+
+// finishStream retires a stream whose response is complete. If the client
+// is still sending, it is told to stop with RST_STREAM(NO_ERROR).
+func (sc *serverConn) finishStream(st *stream) {
+	sc.mu.Lock()
+	remoteOpen := !st.remoteClosed && !st.reset
+	sc.mu.Unlock()
+	if remoteOpen {
+		sc.resetStream(st, ErrCodeNo)
+		return
+	}
+	sc.removeStream(st)
+}
+
+func (sc *serverConn) resetStreamID(id uint32, code ErrCode) {
+	sc.mu.Lock()
+	st, open := sc.streams[id]
+	sc.mu.Unlock()
+	if open {
+		sc.resetStream(st, code)
+	} else {
+		sc.writeFrame(FrameRSTStream, 0, id, binary.BigEndian.AppendUint32(nil, uint32(code)))
+	}
+}
+
+func (sc *serverConn) resetStream(st *stream, code ErrCode) {
+	if sc.removeStream(st) {
+		sc.writeFrame(FrameRSTStream, 0, st.id, binary.BigEndian.AppendUint32(nil, uint32(code)))
+	}
+}
+
+// removeStream closes a stream, frees its unread body for the connection
+// window and reports whether it was still open.
+func (sc *serverConn) removeStream(st *stream) bool {
+	sc.mu.Lock()
+	if st.reset {
+		sc.mu.Unlock()
+		return false
+	}
+	st.reset = true
+	delete(sc.streams, st.id)
+	sc.cond.Broadcast()
+	idle := len(sc.streams) == 0
+	closeConn := idle && sc.goAwaySent
+	sc.mu.Unlock()
+
+	// The handler keeps counting against MaxConcurrentStreams until it
+	// returns; cancelling its context asks it to return early.
+	st.cancel()
+
+	if st.body != nil {
+		sc.returnConnWindow(int32(st.body.closeWithError(errStreamReset)))
+	}
+	if closeConn {
+		sc.conn.Close()
+	} else if idle {
+		sc.resetIdleTimer()
+	}
+	return true
+}
+
+func (sc *serverConn) resetIdleTimer() {
+	if sc.srv.IdleTimeout <= 0 {
+		return
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if len(sc.streams) > 0 || sc.closed {
+		return
+	}
+	if sc.idleTimer == nil {
+		sc.idleTimer = time.AfterFunc(sc.srv.IdleTimeout, sc.startGracefulShutdown)
+	} else {
+		sc.idleTimer.Reset(sc.srv.IdleTimeout)
+	}
+}
+
+// startGracefulShutdown sends GOAWAY(NO_ERROR) so the client opens no new
+// streams, and closes the connection once the open ones are done.
+func (sc *serverConn) startGracefulShutdown() {
+	sc.mu.Lock()
+	if sc.goAwaySent || sc.closed {
+		sc.mu.Unlock()
+		return
+	}
+	sc.goAwaySent = true
+	lastID := sc.maxStreamID
+	idle := len(sc.streams) == 0
+	sc.mu.Unlock()
+
+	sc.writeGoAway(lastID, ErrCodeNo, "")
+	if idle {
+		sc.conn.Close()
+	}
+}
+
+// goAway ends the connection after a connection error.
+func (sc *serverConn) goAway(code ErrCode, reason string) {
+	sc.mu.Lock()
+	sc.goAwaySent = true
+	lastID := sc.maxStreamID
+	sc.mu.Unlock()
+
+	sc.logger.Debug("HTTP/2: closing connection", "remote", sc.remoteAddr, "code", code.String(), "reason", reason)
+	sc.writeGoAway(lastID, code, reason)
+}
+
+func (sc *serverConn) writeGoAway(lastID uint32, code ErrCode, debug string) {
+	payload := binary.BigEndian.AppendUint32(nil, lastID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	payload = append(payload, debug...)
+	sc.writeFrame(FrameGoAway, 0, 0, payload)
+}
+
+func errorResponse(code int, message string) *http.Response {
+	resp := http.NewResponse()
+	resp.StatusCode = code
+	resp.StatusText = http.StatusText(code)
+	resp.SetHeader("Content-Type", "text/plain")
+	resp.SetBody([]byte(message))
+	return resp
+}
+
+// requestBody is a stream's Request.BodyReader. Reading from it returns
+// flow-control credit to the client.
+type requestBody struct {
+	sc *serverConn
+	st *stream
+
+	// expectContinue means the client sent "Expect: 100-continue" and is
+	// waiting for a 100 before sending the body.
+	expectContinue bool
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	if b.expectContinue {
+		b.expectContinue = false
+		continueFields := []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(status.Continue)}}
+		if err := b.sc.writeHeaders(b.st, continueFields, false); err != nil && err != http.ErrFinalResponseSent {
+			return 0, err
+		}
+	}
+	n, err := b.st.body.Read(p)
+	if n > 0 {
+		b.sc.consumed(b.st, int32(n))
+	}
+	return n, err
+}
+
+// pipe buffers a stream's incoming DATA until the handler reads it. Flow
+// control bounds how much can accumulate.
+type pipe struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  []byte
+	err  error
+}
+
+func newPipe() *pipe {
+	p := &pipe{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *pipe) write(data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.buf = append(p.buf, data...)
+		p.cond.Signal()
+	}
+}
+
+func (p *pipe) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.buf) == 0 && p.err == nil {
+		p.cond.Wait()
+	}
+	if len(p.buf) > 0 {
+		n := copy(b, p.buf)
+		p.buf = p.buf[n:]
+		return n, nil
+	}
+	return 0, p.err
+}
+
+// closeWithError makes reads fail with err once the buffer is drained. A
+// reset discards the buffer; the number of bytes dropped is returned.
+func (p *pipe) closeWithError(err error) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil && p.err != io.EOF {
+		return 0
+	}
+	if p.err == nil {
+		p.err = err
+	}
+	dropped := 0
+	if err != io.EOF {
+		p.err = err
+		dropped = len(p.buf)
+		p.buf = nil
+	}
+	p.cond.Broadcast()
+	return dropped
+}
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"log/slog"
+	"net/textproto"
+	"sort"
+)
+
+// SensitiveHeaders lists headers whose values are replaced with
+// "[REDACTED]" in logs. Keys are in canonical form.
+var SensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+	"X-Auth-Token":        true,
+	"X-Csrf-Token":        true,
+}
+
+// HeadersAttr returns headers as a log group, with the values of
+// SensitiveHeaders redacted.
+func HeadersAttr(key string, headers map[string]string) slog.Attr {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	attrs := make([]any, 0, len(names))
+	for _, name := range names {
+		value := headers[name]
+		if SensitiveHeaders[textproto.CanonicalMIMEHeaderKey(name)] {
+			value = "[REDACTED]"
+		}
+		attrs = append(attrs, slog.String(name, value))
+	}
+	return slog.Group(key, attrs...)
+}
+
+// RequestAttrs returns the attributes that identify req in log records,
+// including the request ID and a client IP resolved by ClientIPResolver
+// once those are known, so a Router with its own Logger keeps them.
+func RequestAttrs(req *Request) []any {
+	attrs := []any{slog.String("method", req.Method), slog.String("path", req.Path)}
+	if req.Version != "" {
+		attrs = append(attrs, slog.String("proto", req.Version))
+	}
+	if req.RemoteAddr != "" {
+		attrs = append(attrs, slog.String("remote", req.RemoteAddr))
+	}
+	if req.ConnID != 0 {
+		attrs = append(attrs, slog.Uint64("conn", req.ConnID), slog.Uint64("seq", req.ConnSeq))
+	}
+	if req.ClientIP != "" && req.ClientIP != remoteHost(req.RemoteAddr) {
+		attrs = append(attrs, slog.String("client_ip", req.ClientIP))
+	}
+	if id := req.RequestID(); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	return attrs
+}
+
+// Logger returns the logger for this request, which carries RequestAttrs.
+// The server sets it from Server.Logger; a Router with its own Logger
+// replaces it.
+func (r *Request) Logger() *slog.Logger {
+	if r.logger == nil {
+		r.logger = slog.Default().With(RequestAttrs(r)...)
+	}
+	return r.logger
+}
+
+// SetLogger replaces the request's logger, for example to add attributes
+// such as the authenticated user for the rest of the request.
+func (r *Request) SetLogger(logger *slog.Logger) {
+	r.logger = logger
+}
@@ -0,0 +1,196 @@
+package http
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/metrics"
+)
+
+// Connection states reported by the netrunner_http_connections gauge.
+const (
+	connStateNew      = "new"      // accepted, waiting for the first request
+	connStateActive   = "active"   // reading a request or writing a response
+	connStateIdle     = "idle"     // kept alive between requests
+	connStateUpgraded = "upgraded" // handed to HTTP/2, WebSocket or a tunnel
+)
+
+// Metrics records request, connection and protocol metrics for servers and
+// routers. Set it as Server.Metrics for the connection-level metrics and
+// wrap the handler with Middleware for the per-request ones; both may be
+// shared by several servers.
+type Metrics struct {
+	requests      *metrics.CounterVec
+	duration      *metrics.HistogramVec
+	inFlight      *metrics.Gauge
+	connections   *metrics.GaugeVec
+	tlsHandshakes *metrics.CounterVec
+	bytesRead     *metrics.Counter
+	bytesWritten  *metrics.Counter
+	parseErrors   *metrics.CounterVec
+}
+
+// NewMetrics registers the HTTP metrics in reg.
+func NewMetrics(reg *metrics.Registry) *Metrics {
+	return &Metrics{
+		requests: reg.NewCounterVec("netrunner_http_requests_total",
+			"Requests served, by method, route pattern and status.", "method", "route", "status"),
+		duration: reg.NewHistogramVec("netrunner_http_request_duration_seconds",
+			"Time until the handler returned its response, by method, route pattern and status.",
+			nil, "method", "route", "status"),
+		inFlight: reg.NewGauge("netrunner_http_requests_in_flight",
+			"Requests currently being handled."),
+		connections: reg.NewGaugeVec("netrunner_http_connections",
+			"Open connections by state.", "state"),
+		tlsHandshakes: reg.NewCounterVec("netrunner_tls_handshakes_total",
+			"TLS handshakes by result.", "result"),
+		bytesRead: reg.NewCounter("netrunner_http_received_bytes_total",
+			"Bytes read from client connections, after TLS decryption."),
+		bytesWritten: reg.NewCounter("netrunner_http_sent_bytes_total",
+			"Bytes written to client connections, before TLS encryption."),
+		parseErrors: reg.NewCounterVec("netrunner_http_parse_errors_total",
+			"Requests that could not be read, by reason.", "reason"),
+	}
+}
+
+// MetricsHandler serves the registry in the Prometheus text format.
+func MetricsHandler(reg *metrics.Registry) HandlerFunc {
+	return func(req *Request) *Response {
+		var buf bytes.Buffer
+		if err := reg.WriteText(&buf); err != nil {
+			return InternalServerErrorResponseFor(req)
+		}
+		resp := NewResponse()
+		resp.SetStatus(200)
+		resp.SetHeader("Content-Type", metrics.ContentType)
+		resp.SetBody(buf.Bytes())
+		return resp
+	}
+}
+
+// Middleware counts and times requests. The route label is the pattern of
+// the Router route that matched, "none" if none did, so paths with IDs
+// don't create a series each. It works both as Router middleware and
+// wrapped around a whole handler, which also counts 404s and redirects.
+func (m *Metrics) Middleware(next HandlerFunc) HandlerFunc {
+	return func(req *Request) *Response {
+		m.inFlight.Inc()
+		// Deferred so a panicking handler doesn't leave the gauge raised.
+		defer m.inFlight.Dec()
+		start := time.Now()
+		resp := next(req)
+		duration := time.Since(start)
+
+		code := 500
+		if resp != nil {
+			code = resp.StatusCode
+		}
+		route := req.Route
+		if route == "" {
+			route = "none"
+		}
+		labels := []string{metricMethod(req.Method), route, strconv.Itoa(code)}
+		m.requests.With(labels...).Inc()
+		m.duration.With(labels...).Observe(duration.Seconds())
+		return resp
+	}
+}
+
+// metricMethod maps nonstandard methods to "OTHER" so clients can't create
+// unbounded series.
+func metricMethod(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE":
+		return method
+	}
+	return "OTHER"
+}
+
+func (m *Metrics) tlsHandshake(err error) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.tlsHandshakes.With(result).Inc()
+}
+
+func (m *Metrics) parseError(reason string) {
+	if m != nil {
+		m.parseErrors.With(reason).Inc()
+	}
+}
+
+// connTracker keeps a connection's state in the connections gauge. Its
+// methods do nothing when metrics are off.
+type connTracker struct {
+	m     *Metrics
+	state string
+}
+
+func (m *Metrics) trackConn() *connTracker {
+	if m == nil {
+		return nil
+	}
+	m.connections.With(connStateNew).Inc()
+	return &connTracker{m: m, state: connStateNew}
+}
+
+func (t *connTracker) set(state string) {
+	if t == nil || t.state == state {
+		return
+	}
+	t.m.connections.With(t.state).Dec()
+	t.m.connections.With(state).Inc()
+	t.state = state
+}
+
+func (t *connTracker) close() {
+	if t != nil {
+		t.m.connections.With(t.state).Dec()
+	}
+}
+
+// countConn wraps conn to count the bytes read and written on it. TLS
+// connections keep their ConnectionState method.
+func (m *Metrics) countConn(conn net.Conn) net.Conn {
+	if m == nil {
+		return conn
+	}
+	c := &countingConn{Conn: conn, m: m}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		return &countingTLSConn{countingConn: c, tlsConn: tlsConn}
+	}
+	return c
+}
+
+type countingConn struct {
+	net.Conn
+	m *Metrics
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.m.bytesRead.Add(float64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.m.bytesWritten.Add(float64(n))
+	return n, err
+}
+
+type countingTLSConn struct {
+	*countingConn
+	tlsConn *tls.Conn
+}
+
+func (c *countingTLSConn) ConnectionState() tls.ConnectionState {
+	return c.tlsConn.ConnectionState()
+}
@@ -1,17 +1,29 @@
 package http
 
 import (
-	"fmt"
+	"context"
+	"log/slog"
 	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http/status"
 )
 
-// LoggingMiddleware logs information about each request
+// LoggingMiddleware logs each request with its status and duration to the
+// request's Logger. Server errors are logged at error level.
 func LoggingMiddleware(next HandlerFunc) HandlerFunc {
 	return func(req *Request) *Response {
 		start := time.Now()
 		resp := next(req)
 		duration := time.Since(start)
-		fmt.Printf("%s %s - %d (%v)\n", req.Method, req.Path, resp.StatusCode, duration)
+
+		level, code := slog.LevelInfo, status.InternalServerError
+		if resp != nil {
+			code = resp.StatusCode
+		}
+		if status.IsServerError(code) {
+			level = slog.LevelError
+		}
+		req.Logger().Log(context.Background(), level, "Request handled", "status", code, "duration", duration)
 		return resp
 	}
 }
@@ -0,0 +1,162 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+)
+
+const (
+	problemJSONType = "application/problem+json"
+	htmlType        = "text/html; charset=utf-8"
+	textType        = "text/plain; charset=utf-8"
+)
+
+// Problem returns the RFC 9457 problem details object for the error. Type
+// defaults to "about:blank", whose title is the status reason phrase.
+func (e *HTTPError) Problem() map[string]any {
+	problem := make(map[string]any, len(e.Extensions)+5)
+	for name, value := range e.Extensions {
+		problem[name] = value
+	}
+	problem["type"] = e.problemType()
+	problem["title"] = e.title()
+	problem["status"] = e.Code
+	if e.Message != "" {
+		problem["detail"] = e.Message
+	}
+	if e.Instance != "" {
+		problem["instance"] = e.Instance
+	}
+	return problem
+}
+
+func (e *HTTPError) problemType() string {
+	if e.Type == "" {
+		return "about:blank"
+	}
+	return e.Type
+}
+
+func (e *HTTPError) title() string {
+	if e.Title == "" {
+		return StatusText(e.Code)
+	}
+	return e.Title
+}
+
+// RenderError builds the built-in response for an error in the format the
+// client asks for in Accept: problem+json, HTML or plain text. A nil
+// request, as when the request couldn't be parsed, gets plain text.
+func RenderError(req *Request, e *HTTPError) *Response {
+	resp := NewResponse()
+	resp.StatusCode = e.Code
+	resp.StatusText = StatusText(e.Code)
+
+	var accept string
+	if req != nil {
+		accept = req.Headers["Accept"]
+	}
+	switch negotiateErrorType(accept, textType) {
+	case problemJSONType:
+		body, err := json.Marshal(e.Problem())
+		if err != nil {
+			// An extension member that can't be encoded; drop them all
+			// rather than fail to report the error.
+			body, _ = json.Marshal((&HTTPError{Code: e.Code, Message: e.Message, Type: e.Type, Title: e.Title, Instance: e.Instance}).Problem())
+		}
+		resp.SetHeader("Content-Type", problemJSONType)
+		resp.SetBody(body)
+	case htmlType:
+		title := html.EscapeString(fmt.Sprintf("%d %s", e.Code, e.title()))
+		body := fmt.Sprintf("<!DOCTYPE html>\n<html>\n<head><title>%s</title></head>\n<body>\n<h1>%s</h1>\n", title, title)
+		if e.Message != "" {
+			body += fmt.Sprintf("<p>%s</p>\n", html.EscapeString(e.Message))
+		}
+		body += "</body>\n</html>\n"
+		resp.SetHeader("Content-Type", htmlType)
+		resp.SetBody([]byte(body))
+	default:
+		body := e.Message
+		if body == "" {
+			body = fmt.Sprintf("%d %s", e.Code, e.title())
+		}
+		resp.SetHeader("Content-Type", textType)
+		resp.SetBody([]byte(body))
+	}
+	resp.SetHeader("Vary", "Accept")
+	return resp
+}
+
+// negotiateErrorType picks the error format with the highest quality in an
+// Accept header. Ties, including "*/*" and a missing header, go to
+// preferred, then plain text, problem+json and HTML in that order.
+func negotiateErrorType(accept, preferred string) string {
+	offers := []struct {
+		contentType string
+		mediaTypes  []string
+	}{
+		{textType, []string{"text/plain"}},
+		{problemJSONType, []string{"application/problem+json", "application/json"}},
+		{htmlType, []string{"text/html"}},
+	}
+	if strings.TrimSpace(accept) == "" {
+		return preferred
+	}
+	for i, offer := range offers {
+		if offer.contentType == preferred {
+			copy(offers[1:i+1], offers[:i])
+			offers[0] = offer
+		}
+	}
+
+	best, bestQ := preferred, 0.0
+	for _, offer := range offers {
+		q := 0.0
+		for _, mediaType := range offer.mediaTypes {
+			q = max(q, acceptQuality(accept, mediaType))
+		}
+		if q > bestQ {
+			best, bestQ = offer.contentType, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q-value Accept gives mediaType, taken from the
+// most specific range that matches it.
+func acceptQuality(accept, mediaType string) float64 {
+	mainType, _, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		rangeType := strings.ToLower(strings.TrimSpace(params[0]))
+
+		var s int
+		switch rangeType {
+		case mediaType:
+			s = 2
+		case mainType + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "q") {
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					q = v
+				}
+			}
+		}
+	}
+	return q
+}
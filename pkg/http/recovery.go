@@ -0,0 +1,83 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"runtime/debug"
+
+	"github.com/appyzdl/Netrunner/pkg/http/status"
+)
+
+// ErrAbortHandler is a panic value that aborts a handler without being
+// logged. The server closes the connection (or resets the HTTP/2 stream)
+// instead of sending a response; RecoveryMiddleware passes it through.
+var ErrAbortHandler = errors.New("http: abort handler")
+
+// RecoveryMiddleware turns a panic in the handler into a 500 response and
+// logs the panic with its stack.
+func RecoveryMiddleware(next HandlerFunc) HandlerFunc {
+	return recovery(next, false)
+}
+
+// NewRecoveryMiddleware is like RecoveryMiddleware, but in debug mode the
+// response shows the panic and its stack. Only use debug mode in
+// development.
+func NewRecoveryMiddleware(debugMode bool) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return recovery(next, debugMode)
+	}
+}
+
+func recovery(next HandlerFunc, debugMode bool) HandlerFunc {
+	return func(req *Request) (resp *Response) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == ErrAbortHandler {
+				panic(v)
+			}
+			stack := debug.Stack()
+			logger := req.Logger()
+			if RequestIDFromContext(req.Context()) == "" {
+				// Otherwise RequestIDAssigner or RequestAttrs added it.
+				logger = logger.With("request_id", orDash(req.RequestID()))
+			}
+			logger.Error("Panic serving request", "panic", v, "stack", string(stack))
+			if debugMode {
+				resp = debugPanicResponse(req, v, stack)
+			} else {
+				resp = InternalServerErrorResponseFor(req)
+			}
+		}()
+		return next(req)
+	}
+}
+
+// debugPanicResponse describes a panic in the format the client accepts.
+func debugPanicResponse(req *Request, v any, stack []byte) *Response {
+	message := fmt.Sprintf("panic: %v", v)
+	switch negotiateErrorType(req.Headers["Accept"], htmlType) {
+	case problemJSONType:
+		return RenderError(req, &HTTPError{
+			Code:       status.InternalServerError,
+			Message:    message,
+			Extensions: map[string]any{"stack": string(stack)},
+		})
+	case htmlType:
+		resp := RenderError(req, NewHTTPError(status.InternalServerError, ""))
+		body := fmt.Sprintf("<!DOCTYPE html>\n<html>\n<head><title>500 Internal Server Error</title></head>\n<body>\n"+
+			"<h1>%s</h1>\n<p>%s %s</p>\n<pre>%s</pre>\n</body>\n</html>\n",
+			html.EscapeString(message), html.EscapeString(req.Method), html.EscapeString(req.Path), html.EscapeString(string(stack)))
+		resp.SetHeader("Content-Type", htmlType)
+		resp.SetBody([]byte(body))
+		return resp
+	default:
+		resp := RenderError(req, NewHTTPError(status.InternalServerError, ""))
+		resp.SetHeader("Content-Type", textType)
+		resp.SetBody([]byte(fmt.Sprintf("500 Internal Server Error\n\n%s\n\n%s", message, stack)))
+		return resp
+	}
+}
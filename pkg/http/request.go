@@ -3,9 +3,21 @@ package http
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http/status"
 )
 
 type Request struct {
@@ -15,8 +27,81 @@ type Request struct {
 	Headers map[string]string
 	Body    []byte
 	TLS     *tls.ConnectionState
+
+	// URL is the parsed request target. For outgoing requests it names the
+	// server to contact.
+	URL *url.URL
+
+	// RemoteAddr is the network address of the client that sent the request,
+	// LocalAddr the server address it arrived on.
+	RemoteAddr string
+	LocalAddr  string
+
+	// ClientIP is the client's IP address. The server sets it to the peer's
+	// address; ClientIPResolver replaces it with the address a trusted
+	// proxy forwarded the request for.
+	ClientIP string
+
+	// ConnID identifies the connection the request arrived on and is unique
+	// within the process. ConnSeq numbers the requests on that connection,
+	// starting at 1.
+	ConnID  uint64
+	ConnSeq uint64
+
+	// TLSHandshake is how long the connection's TLS handshake took. It is
+	// only set on the first request of an HTTP/1.x connection, the one
+	// that waited for it.
+	TLSHandshake time.Duration
+
+	// Route is the pattern of the Router route that matched the request,
+	// such as "/static/*".
+	Route string
+
+	// BodyReader streams the request body when it has not been read into
+	// Body yet. Handlers registered with AddStreamRoute read from it directly.
+	BodyReader io.Reader
+
+	// SendInformational is set by the server to write 1xx responses ahead
+	// of the final one. Handlers call WriteInformational instead.
+	SendInformational func(code int, headers map[string]string) error
+
+	ctx        context.Context
+	logger     *slog.Logger
+	timings    *Timings
+	errorPages *ErrorPages
+}
+
+var connIDs atomic.Uint64
+
+// NextConnID returns a new connection ID for Request.ConnID, for servers
+// outside this package that accept their own connections.
+func NextConnID() uint64 {
+	return connIDs.Add(1)
+}
+
+// Context returns the request's context, which is never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
 }
 
+// SetContext replaces the request's context, for example to pass values to
+// later handlers or to outgoing requests made on its behalf.
+func (r *Request) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// ErrFinalResponseSent is returned when an informational response is
+// written after the final response has started.
+var ErrFinalResponseSent = errors.New("http: final response already sent")
+
+// maxHeaderBytes bounds the request line plus headers read by ReadRequest.
+const maxHeaderBytes = 1 << 20
+
+var errHeaderTooLarge = errors.New("request header too large")
+
 func NewRequest() *Request {
 	return &Request{
 		Headers: make(map[string]string),
@@ -80,3 +165,279 @@ func ParseRequest(data []byte, tlsConn *tls.ConnectionState) (*Request, error) {
 
 	return request, nil
 }
+
+// ReadRequest reads a single request from a connection. Unlike ParseRequest
+// it doesn't need the whole request in memory: the body is left unread in
+// BodyReader, framed by Content-Length or chunked transfer coding.
+func ReadRequest(reader *bufio.Reader) (*Request, error) {
+	limit := &headerLimit{remaining: maxHeaderBytes}
+
+	requestLine, err := readLine(reader, limit)
+	if err != nil {
+		return nil, err
+	}
+	// RFC 9112 section 2.2: ignore at least one empty line before the request line.
+	if requestLine == "" {
+		if requestLine, err = readLine(reader, limit); err != nil {
+			return nil, err
+		}
+	}
+
+	parts := strings.Split(requestLine, " ")
+	if len(parts) != 3 || parts[0] == "" || !strings.HasPrefix(parts[2], "HTTP/") {
+		return nil, fmt.Errorf("invalid request line: %s", requestLine)
+	}
+
+	request := &Request{
+		Method:  parts[0],
+		Path:    parts[1],
+		Version: parts[2],
+	}
+
+	switch {
+	case request.Method == "CONNECT" && !strings.HasPrefix(request.Path, "/"):
+		// authority-form: "CONNECT host:port HTTP/1.1"
+		if _, _, err := net.SplitHostPort(request.Path); err != nil {
+			return nil, fmt.Errorf("invalid CONNECT target: %s", request.Path)
+		}
+		request.URL = &url.URL{Host: request.Path}
+	case request.Path == "*":
+	default:
+		if request.URL, err = url.ParseRequestURI(request.Path); err != nil {
+			return nil, fmt.Errorf("invalid request target: %s", request.Path)
+		}
+	}
+
+	if request.Headers, err = readHeaders(reader, limit); err != nil {
+		return nil, err
+	}
+
+	if request.BodyReader, err = bodyReader(reader, request.Headers); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// WriteInformational sends an informational (1xx) response, such as 103
+// Early Hints, while the handler is still preparing the final response.
+// It does nothing for clients that can't receive one, such as HTTP/1.0.
+// 101 is reserved for upgrades; use Response.Hijack for those.
+func (r *Request) WriteInformational(code int, headers map[string]string) error {
+	if code < 100 || code > 199 || code == status.SwitchingProtocols {
+		return fmt.Errorf("http: %d is not an informational status", code)
+	}
+	if r.SendInformational == nil {
+		return nil
+	}
+	return r.SendInformational(code, headers)
+}
+
+// WriteEarlyHints sends 103 Early Hints with the given Link header values,
+// e.g. "</style.css>; rel=preload; as=style", so the client can start
+// fetching them before the page itself arrives.
+func (r *Request) WriteEarlyHints(links ...string) error {
+	return r.WriteInformational(status.EarlyHints, map[string]string{"Link": strings.Join(links, ", ")})
+}
+
+// ReadBody reads the remainder of BodyReader into Body and returns it.
+// Calling it on a request whose body has already been read is a no-op.
+func (r *Request) ReadBody() ([]byte, error) {
+	if r.BodyReader == nil {
+		return r.Body, nil
+	}
+	body, err := io.ReadAll(r.BodyReader)
+	r.BodyReader = nil
+	if err != nil {
+		return nil, err
+	}
+	r.Body = body
+	return body, nil
+}
+
+// ErrBodyTooLarge is returned by ReadBodyLimit for a body over its limit.
+var ErrBodyTooLarge = errors.New("http: request body too large")
+
+// ReadBodyLimit is like ReadBody but fails with ErrBodyTooLarge, without
+// reading further, once the body exceeds max bytes. A max of 0 or less
+// means no limit.
+func (r *Request) ReadBodyLimit(max int64) ([]byte, error) {
+	if max <= 0 || r.BodyReader == nil {
+		return r.ReadBody()
+	}
+	if n, err := strconv.ParseInt(r.Headers["Content-Length"], 10, 64); err == nil && n > max {
+		return nil, ErrBodyTooLarge
+	}
+	body, err := io.ReadAll(io.LimitReader(r.BodyReader, max+1))
+	if err != nil {
+		r.BodyReader = nil
+		return nil, err
+	}
+	if int64(len(body)) > max {
+		return nil, ErrBodyTooLarge
+	}
+	r.BodyReader = nil
+	r.Body = body
+	return body, nil
+}
+
+// Write sends the request in HTTP/1.1 wire format. A BodyReader without a
+// Content-Length is sent with chunked transfer coding.
+func (r *Request) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	target := r.Path
+	if target == "" && r.URL != nil {
+		target = r.URL.RequestURI()
+	}
+	version := r.Version
+	if version == "" {
+		version = "HTTP/1.1"
+	}
+	fmt.Fprintf(bw, "%s %s %s\r\n", r.Method, target, version)
+
+	if _, ok := r.Headers["Host"]; !ok && r.URL != nil {
+		fmt.Fprintf(bw, "Host: %s\r\n", r.URL.Host)
+	}
+
+	chunked := false
+	_, hasLength := r.Headers["Content-Length"]
+	switch {
+	case r.BodyReader != nil && !hasLength:
+		chunked = true
+		r.Headers["Transfer-Encoding"] = "chunked"
+	case r.BodyReader == nil && !hasLength && len(r.Body) > 0:
+		r.Headers["Content-Length"] = strconv.Itoa(len(r.Body))
+	}
+
+	for key, value := range r.Headers {
+		fmt.Fprintf(bw, "%s: %s\r\n", key, value)
+	}
+	bw.WriteString("\r\n")
+
+	var err error
+	switch {
+	case chunked:
+		cw := newChunkedWriter(bw)
+		if _, err = io.Copy(cw, r.BodyReader); err == nil {
+			err = cw.Close()
+		}
+	case r.BodyReader != nil:
+		_, err = io.Copy(bw, r.BodyReader)
+	default:
+		_, err = bw.Write(r.Body)
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// headerLimit tracks how many header bytes may still be read.
+type headerLimit struct {
+	remaining int
+}
+
+func readLine(reader *bufio.Reader, limit *headerLimit) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		limit.remaining -= len(chunk)
+		if limit.remaining < 0 {
+			return "", errHeaderTooLarge
+		}
+		line = append(line, chunk...)
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// readHeaders reads header lines up to the blank line that ends them. Keys
+// are canonicalized, so handlers can look up "Content-Type" regardless of
+// the case the peer used; repeated fields are joined as RFC 9110 allows.
+func readHeaders(reader *bufio.Reader, limit *headerLimit) (map[string]string, error) {
+	headers := make(map[string]string)
+	for {
+		line, err := readLine(reader, limit)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("error reading header: %w", err)
+		}
+		if line == "" {
+			return headers, nil
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("invalid header: %s", line)
+		}
+		addHeader(headers, textproto.CanonicalMIMEHeaderKey(key), strings.TrimSpace(value))
+	}
+}
+
+func addHeader(headers map[string]string, key, value string) {
+	existing, ok := headers[key]
+	switch {
+	case !ok:
+		headers[key] = value
+	case key == "Cookie":
+		headers[key] = existing + "; " + value
+	default:
+		headers[key] = existing + ", " + value
+	}
+}
+
+// bodyReader returns a reader framing the message body that follows the
+// headers, or nil if the message has no body.
+func bodyReader(reader *bufio.Reader, headers map[string]string) (io.Reader, error) {
+	if te := headers["Transfer-Encoding"]; te != "" {
+		if !strings.EqualFold(te, "chunked") {
+			return nil, fmt.Errorf("unsupported transfer encoding: %s", te)
+		}
+		delete(headers, "Content-Length")
+		return newChunkedReader(reader), nil
+	}
+
+	contentLength := headers["Content-Length"]
+	if contentLength == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(contentLength, 10, 64)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid content length: %s", contentLength)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	return io.LimitReader(reader, n), nil
+}
+
+// isHopByHopHeader reports whether a header only applies to a single
+// connection and must not be forwarded by proxies (RFC 9110 section 7.6.1).
+func isHopByHopHeader(key string) bool {
+	switch key {
+	case "Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate",
+		"Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade":
+		return true
+	}
+	return false
+}
+
+// removeHopByHopHeaders strips hop-by-hop headers, including any listed in
+// the Connection header.
+func removeHopByHopHeaders(headers map[string]string) {
+	for _, name := range strings.Split(headers["Connection"], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			delete(headers, textproto.CanonicalMIMEHeaderKey(name))
+		}
+	}
+	for key := range headers {
+		if isHopByHopHeader(key) {
+			delete(headers, key)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"net/netip"
+	"time"
+)
+
+// RequestIDHeader carries the request ID in requests and responses.
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLen bounds incoming IDs so a client can't bloat every log
+// line of its requests.
+const maxRequestIDLen = 128
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID in ctx, or "".
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestID returns the ID assigned by RequestIDAssigner, or "" if none
+// was. An X-Request-Id header the client sent is not trusted on its own.
+func (r *Request) RequestID() string {
+	return RequestIDFromContext(r.Context())
+}
+
+// RequestIDAssigner gives every request an ID. An X-Request-Id sent by a
+// peer in TrustedSources, such as a load balancer that already assigned
+// one, is kept; anything else gets a new ULID.
+//
+// The ID is stored in the request's context, set as its X-Request-Id
+// header (so ReverseProxy forwards it), echoed in the response and added
+// to the request's Logger as "request_id".
+type RequestIDAssigner struct {
+	TrustedSources []netip.Prefix
+
+	// Generate returns new IDs. NewULID if nil.
+	Generate func() string
+}
+
+// NewRequestIDAssigner trusts IDs from the given CIDRs or single addresses.
+func NewRequestIDAssigner(trusted ...string) (*RequestIDAssigner, error) {
+	prefixes, err := parsePrefixes(trusted)
+	if err != nil {
+		return nil, err
+	}
+	return &RequestIDAssigner{TrustedSources: prefixes}, nil
+}
+
+func (a *RequestIDAssigner) Middleware(next HandlerFunc) HandlerFunc {
+	return func(req *Request) *Response {
+		id := req.Headers[RequestIDHeader]
+		if !a.trusted(req) || !validRequestID(id) {
+			id = a.generate()
+		}
+		req.Headers[RequestIDHeader] = id
+		req.SetContext(ContextWithRequestID(req.Context(), id))
+		req.SetLogger(req.Logger().With("request_id", id))
+
+		resp := next(req)
+		if resp != nil {
+			resp.SetHeader(RequestIDHeader, id)
+		}
+		return resp
+	}
+}
+
+func (a *RequestIDAssigner) trusted(req *Request) bool {
+	peer, ok := parseForwardedAddr(req.RemoteAddr)
+	return ok && prefixesContain(a.TrustedSources, peer)
+}
+
+func (a *RequestIDAssigner) generate() string {
+	if a.Generate != nil {
+		return a.Generate()
+	}
+	return NewULID()
+}
+
+// validRequestID accepts up to maxRequestIDLen visible ASCII characters.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a ULID: a 48-bit millisecond timestamp followed by 80
+// random bits, as 26 Crockford base32 characters. ULIDs sort by time.
+func NewULID() string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixMilli())<<16)
+	rand.Read(b[6:])
+
+	// 128 bits are encoded as 26 five-bit groups, the first holding only
+	// the top 3 bits.
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
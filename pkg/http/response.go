@@ -1,7 +1,11 @@
 package http
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"net"
+	"strconv"
 	"strings"
 
 	"github.com/appyzdl/Netrunner/pkg/http/status"
@@ -12,7 +16,24 @@ type Response struct {
 	StatusCode int
 	StatusText string
 	Headers    map[string]string
+	Cookies    []*Cookie
 	Body       []byte
+
+	// SetCookies holds Set-Cookie values that are written as-is after
+	// Cookies. ReadResponse keeps the lines it received here rather than
+	// parsing them, so a proxied response forwards cookies unchanged.
+	SetCookies []string
+
+	// BodyReader, when set, is streamed to the client instead of Body. The
+	// server flushes after every read so long-lived streams reach the client
+	// promptly, and closes the reader if it implements io.Closer.
+	BodyReader io.Reader
+
+	// Hijack, when set, takes over the connection once the response head
+	// has been written, e.g. for CONNECT tunnels or protocol upgrades. The
+	// reader holds any bytes the client already sent. The connection is
+	// closed when Hijack returns.
+	Hijack func(conn net.Conn, reader *bufio.Reader)
 }
 
 func NewResponse() *Response {
@@ -35,6 +56,24 @@ func (r *Response) SetBody(body []byte) {
 	r.SetHeader("Content-Length", fmt.Sprintf("%d", len(body)))
 }
 
+// ReadBody reads the remainder of BodyReader into Body and returns it,
+// closing the reader if it implements io.Closer.
+func (r *Response) ReadBody() ([]byte, error) {
+	if r.BodyReader == nil {
+		return r.Body, nil
+	}
+	body, err := io.ReadAll(r.BodyReader)
+	if closer, ok := r.BodyReader.(io.Closer); ok {
+		closer.Close()
+	}
+	r.BodyReader = nil
+	if err != nil {
+		return nil, err
+	}
+	r.Body = body
+	return body, nil
+}
+
 func (r *Response) Write() []byte {
 	var builder strings.Builder
 
@@ -44,6 +83,7 @@ func (r *Response) Write() []byte {
 	for key, value := range r.Headers {
 		builder.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
 	}
+	r.writeSetCookies(&builder)
 
 	builder.WriteString("\r\n")
 	return append([]byte(builder.String()), r.Body...)
@@ -63,6 +103,7 @@ func FormatResponse(r *Response) []byte {
 		headerLine := fmt.Sprintf("%s: %s\r\n", key, value)
 		builder.WriteString(headerLine)
 	}
+	r.writeSetCookies(&builder)
 
 	builder.WriteString("\r\n")
 
@@ -75,6 +116,115 @@ func FormatResponse(r *Response) []byte {
 	return responseBytes
 }
 
+// ReadResponse reads a response to a request with the given method, as sent
+// by an upstream server. The body is left unread in BodyReader.
+func ReadResponse(reader *bufio.Reader, method string) (*Response, error) {
+	limit := &headerLimit{remaining: maxHeaderBytes}
+
+	statusLine, err := readLine(reader, limit)
+	if err != nil {
+		return nil, err
+	}
+	version, rest, _ := strings.Cut(statusLine, " ")
+	code, reason, _ := strings.Cut(rest, " ")
+	if !strings.HasPrefix(version, "HTTP/") || len(code) != 3 {
+		return nil, fmt.Errorf("invalid status line: %s", statusLine)
+	}
+
+	resp := NewResponse()
+	resp.Version = version
+	resp.StatusText = reason
+	if resp.StatusCode, err = strconv.Atoi(code); err != nil {
+		return nil, fmt.Errorf("invalid status code: %s", code)
+	}
+
+	headers, err := readHeaders(reader, limit)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		if key != "Set-Cookie" {
+			resp.Headers[key] = value
+		}
+	}
+	if setCookie, ok := headers["Set-Cookie"]; ok {
+		// readHeaders joined repeated fields with ", ", which is ambiguous for
+		// Set-Cookie because Expires contains a comma.
+		resp.SetCookies = splitSetCookie(setCookie)
+	}
+
+	if method == "HEAD" || resp.StatusCode < 200 || resp.StatusCode == 204 || resp.StatusCode == 304 {
+		return resp, nil
+	}
+	if method == "CONNECT" && resp.StatusCode < 300 {
+		// The connection becomes a tunnel after a successful CONNECT.
+		return resp, nil
+	}
+	if resp.BodyReader, err = bodyReader(reader, resp.Headers); err != nil {
+		return nil, err
+	}
+	if resp.BodyReader == nil && resp.Headers["Content-Length"] == "" {
+		// No framing: the body runs until the server closes the connection.
+		resp.BodyReader = reader
+	}
+	return resp, nil
+}
+
+func (r *Response) writeSetCookies(builder *strings.Builder) {
+	for _, cookie := range r.Cookies {
+		builder.WriteString(fmt.Sprintf("Set-Cookie: %s\r\n", cookie))
+	}
+	for _, line := range r.SetCookies {
+		builder.WriteString(fmt.Sprintf("Set-Cookie: %s\r\n", line))
+	}
+}
+
+// splitSetCookie splits joined Set-Cookie values on commas that start a new
+// "name=" pair rather than those inside an Expires date.
+func splitSetCookie(joined string) []string {
+	var lines []string
+	start := 0
+	for i := 0; i < len(joined); i++ {
+		if joined[i] != ',' {
+			continue
+		}
+		next := strings.TrimLeft(joined[i+1:], " ")
+		name, _, ok := strings.Cut(next, "=")
+		if ok && isCookieName(name) && !strings.Contains(name, " ") {
+			lines = append(lines, strings.TrimSpace(joined[start:i]))
+			start = i + 1
+		}
+	}
+	return append(lines, strings.TrimSpace(joined[start:]))
+}
+
+// writeHead writes the status line and headers of the response.
+func (r *Response) writeHead(w io.Writer) error {
+	statusText := r.StatusText
+	if statusText == "" {
+		statusText = status.Text(r.StatusCode)
+	}
+	version := r.Version
+	if version == "" {
+		version = "HTTP/1.1"
+	}
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%s %d %s\r\n", version, r.StatusCode, statusText))
+	for key, value := range r.Headers {
+		builder.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
+	}
+	r.writeSetCookies(&builder)
+	builder.WriteString("\r\n")
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+// InternalServerErrorResponse returns a plain-text 500.
+//
+// Deprecated: Use InternalServerErrorResponseFor, which honors Accept and
+// custom error pages.
 func InternalServerErrorResponse() *Response {
 	resp := NewResponse()
 	resp.StatusCode = status.InternalServerError
@@ -83,3 +233,9 @@ func InternalServerErrorResponse() *Response {
 	resp.Body = []byte("500 Internal Server Error")
 	return resp
 }
+
+// InternalServerErrorResponseFor returns a 500 in the format req accepts,
+// using the custom page from the router or server if there is one.
+func InternalServerErrorResponseFor(req *Request) *Response {
+	return renderError(req, NewHTTPError(status.InternalServerError, ""))
+}
@@ -0,0 +1,234 @@
+package http
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/appyzdl/Netrunner/pkg/http/status"
+)
+
+// ReverseProxy forwards requests to an upstream server and streams the
+// response back. Register HandleRequest with Router.AddStreamRoute so
+// request bodies are forwarded as they arrive instead of being buffered.
+type ReverseProxy struct {
+	Target    *url.URL
+	Transport *Transport
+
+	// PreserveHost forwards the client's Host header instead of the
+	// target's host.
+	PreserveHost bool
+
+	// Rewrite, if set, can modify the outgoing request after the standard
+	// rewriting has been applied.
+	Rewrite func(out *Request, in *Request)
+
+	// ModifyResponse, if set, can modify the upstream response before it is
+	// returned. An error turns the response into a 502.
+	ModifyResponse func(*Response) error
+}
+
+func NewReverseProxy(target *url.URL) *ReverseProxy {
+	return &ReverseProxy{Target: target}
+}
+
+func (p *ReverseProxy) HandleRequest(req *Request) *Response {
+	resp, err := p.roundTrip(req)
+	if err != nil {
+		req.Logger().Warn("Proxy error", "target", p.Target.Host, "error", err)
+		return proxyErrorResponse(err)
+	}
+	return resp
+}
+
+// roundTrip forwards the request and returns the rewritten upstream
+// response, or the error that prevented getting one.
+func (p *ReverseProxy) roundTrip(req *Request) (*Response, error) {
+	transport := p.Transport
+	if transport == nil {
+		transport = DefaultTransport
+	}
+
+	out := p.outgoingRequest(req)
+	if p.Rewrite != nil {
+		p.Rewrite(out, req)
+	}
+
+	span := startClientSpan(out)
+	// The description is sent to clients, so it doesn't name the backend.
+	stop := req.Timings().Start("upstream", "Upstream")
+	resp, err := transport.RoundTrip(out)
+	stop()
+	endClientSpan(span, resp, err)
+	if err != nil {
+		return nil, err
+	}
+
+	removeHopByHopHeaders(resp.Headers)
+	if location := resp.Headers["Location"]; location != "" {
+		resp.Headers["Location"] = p.rewriteLocation(location, req)
+	}
+	resp.Version = "HTTP/1.1"
+
+	if p.ModifyResponse != nil {
+		if err := p.ModifyResponse(resp); err != nil {
+			if closer, ok := resp.BodyReader.(io.Closer); ok {
+				closer.Close()
+			}
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// outgoingRequest builds the upstream request from the client's request.
+func (p *ReverseProxy) outgoingRequest(req *Request) *Request {
+	out := NewRequest()
+	out.SetContext(req.Context())
+	out.Method = req.Method
+	out.Version = "HTTP/1.1"
+	out.Body = req.Body
+	out.BodyReader = req.BodyReader
+	for key, value := range req.Headers {
+		out.Headers[key] = value
+	}
+	removeHopByHopHeaders(out.Headers)
+	if id := req.RequestID(); id != "" {
+		out.Headers[RequestIDHeader] = id
+	}
+	if req.BodyReader != nil {
+		// The framing is hop-by-hop; Request.Write re-chunks the body if
+		// the client didn't send a Content-Length.
+		delete(out.Headers, "Transfer-Encoding")
+	}
+
+	target := *p.Target
+	inPath, inQuery, _ := strings.Cut(req.Path, "?")
+	if req.URL != nil {
+		inPath, inQuery = req.URL.Path, req.URL.RawQuery
+	}
+	target.Path = joinURLPath(p.Target.Path, inPath)
+	target.RawPath = ""
+	switch {
+	case p.Target.RawQuery == "":
+		target.RawQuery = inQuery
+	case inQuery != "":
+		target.RawQuery = p.Target.RawQuery + "&" + inQuery
+	}
+	out.URL = &target
+	out.Path = target.RequestURI()
+
+	clientHost := req.Headers["Host"]
+	if !p.PreserveHost || clientHost == "" {
+		out.Headers["Host"] = p.Target.Host
+	}
+
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	clientIP := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		clientIP = host
+	}
+
+	if clientIP != "" {
+		appendHeader(out.Headers, "X-Forwarded-For", clientIP)
+	}
+	out.Headers["X-Forwarded-Proto"] = proto
+	if clientHost != "" {
+		out.Headers["X-Forwarded-Host"] = clientHost
+	}
+
+	forwarded := "proto=" + proto
+	if clientIP != "" {
+		forwarded = "for=" + forwardedNode(clientIP) + ";" + forwarded
+	}
+	if clientHost != "" {
+		forwarded += ";host=" + quoteForwarded(clientHost)
+	}
+	appendHeader(out.Headers, "Forwarded", forwarded)
+
+	return out
+}
+
+// rewriteLocation makes redirects that point at the upstream point at the
+// proxy instead, so clients never see internal addresses.
+func (p *ReverseProxy) rewriteLocation(location string, req *Request) string {
+	u, err := url.Parse(location)
+	if err != nil || u.Host == "" {
+		return location
+	}
+	if !strings.EqualFold(u.Host, p.Target.Host) {
+		return location
+	}
+	host := req.Headers["Host"]
+	if host == "" {
+		return location
+	}
+
+	u.Scheme = "http"
+	if req.TLS != nil {
+		u.Scheme = "https"
+	}
+	u.Host = host
+	if prefix := strings.TrimSuffix(p.Target.Path, "/"); prefix != "" {
+		if trimmed, ok := strings.CutPrefix(u.Path, prefix); ok {
+			u.Path = "/" + strings.TrimPrefix(trimmed, "/")
+		}
+	}
+	return u.String()
+}
+
+// proxyErrorResponse maps transport errors to 504 for timeouts and 502 for
+// everything else.
+func proxyErrorResponse(err error) *Response {
+	code := status.BadGateway
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		code = status.GatewayTimeout
+	}
+
+	return proxyStatusResponse(code)
+}
+
+func appendHeader(headers map[string]string, key, value string) {
+	if existing := headers[key]; existing != "" {
+		headers[key] = existing + ", " + value
+	} else {
+		headers[key] = value
+	}
+}
+
+// forwardedNode formats an address for the "for" parameter of the
+// Forwarded header (RFC 7239 section 6).
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+func quoteForwarded(value string) string {
+	for i := 0; i < len(value); i++ {
+		if !isTokenChar(value[i]) {
+			return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+		}
+	}
+	return value
+}
+
+func joinURLPath(base, path string) string {
+	switch {
+	case base == "":
+		if path == "" {
+			return "/"
+		}
+		return path
+	case path == "" || path == "/":
+		return base
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+}
@@ -0,0 +1,217 @@
+package http
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RotatingFile is an append-only log file that rotates itself by size or
+// age. Rotated files are renamed to Path plus a timestamp suffix, such as
+// access.log.20240102-150405. It is safe for concurrent use.
+type RotatingFile struct {
+	Path string
+
+	// MaxSize rotates the file before a write would take it past this many
+	// bytes; 0 disables size rotation.
+	MaxSize int64
+	// Interval rotates the file once it has been open this long; 0
+	// disables time rotation.
+	Interval time.Duration
+	// MaxBackups is how many rotated files to keep; 0 keeps them all.
+	MaxBackups int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// NewRotatingFile opens path for appending, creating it if needed.
+func NewRotatingFile(path string) (*RotatingFile, error) {
+	f := &RotatingFile{Path: path}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens Path and, only if that succeeds, closes the current file
+// and switches to it.
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file, f.size, f.opened = file, stat.Size(), time.Now()
+	return nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	sizeExceeded := f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize
+	expired := f.Interval > 0 && time.Since(f.opened) >= f.Interval
+	if sizeExceeded || expired {
+		if err := f.rotate(); err != nil {
+			// Keep appending to the current file; the next write retries.
+			slog.Error("Rotating log file failed", "path", f.Path, "error", err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotate renames the current file aside and starts a new one.
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rotate()
+}
+
+// rotate renames Path aside and opens a new file there. If either step
+// fails, writes continue to go to the file that was open.
+func (f *RotatingFile) rotate() error {
+	stamp := time.Now().Format(backupStamp)
+	backup := f.Path + "." + stamp
+	for i := 1; ; i++ {
+		// Stop at any error, not just a missing file, so a name that can't
+		// exist makes Rename fail instead of looping.
+		if _, err := os.Lstat(backup); err != nil {
+			break
+		}
+		backup = fmt.Sprintf("%s.%s.%d", f.Path, stamp, i)
+	}
+	if err := os.Rename(f.Path, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.removeOldBackups()
+	return nil
+}
+
+func (f *RotatingFile) removeOldBackups() {
+	if f.MaxBackups <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(f.Path + ".*")
+	if err != nil {
+		return
+	}
+	// Only remove files rotate named, not others sharing the prefix such
+	// as access.log.gz or access.log.bak.
+	type backup struct {
+		name  string
+		stamp string
+		seq   int
+	}
+	var backups []backup
+	for _, name := range matches {
+		if stamp, seq, ok := parseBackupSuffix(strings.TrimPrefix(name, f.Path+".")); ok {
+			backups = append(backups, backup{name, stamp, seq})
+		}
+	}
+	if len(backups) <= f.MaxBackups {
+		return
+	}
+	// Timestamps sort chronologically; seq orders backups made within the
+	// same second.
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].stamp != backups[j].stamp {
+			return backups[i].stamp < backups[j].stamp
+		}
+		return backups[i].seq < backups[j].seq
+	})
+	for _, old := range backups[:len(backups)-f.MaxBackups] {
+		os.Remove(old.name)
+	}
+}
+
+// backupStamp is the time layout of rotated file suffixes.
+const backupStamp = "20060102-150405"
+
+// parseBackupSuffix parses a suffix rotate produces: a timestamp, followed
+// by ".N" when several backups were made within the same second.
+func parseBackupSuffix(suffix string) (stamp string, seq int, ok bool) {
+	stamp, rest, hasSeq := strings.Cut(suffix, ".")
+	if _, err := time.Parse(backupStamp, stamp); err != nil || len(stamp) != len(backupStamp) {
+		return "", 0, false
+	}
+	if !hasSeq {
+		return stamp, 0, true
+	}
+	seq, err := strconv.Atoi(rest)
+	if err != nil || seq <= 0 || strconv.Itoa(seq) != rest {
+		return "", 0, false
+	}
+	return stamp, seq, true
+}
+
+// Reopen closes and reopens Path, for use after an external tool such as
+// logrotate has moved the file away.
+func (f *RotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.open()
+}
+
+// ReopenOnSignal calls Reopen whenever one of sigs arrives, SIGHUP if none
+// are given, until the returned stop function is called.
+func (f *RotatingFile) ReopenOnSignal(sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, sigs...)
+	go func() {
+		for {
+			select {
+			case <-ch:
+				if err := f.Reopen(); err != nil {
+					slog.Error("Reopening log file failed", "path", f.Path, "error", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
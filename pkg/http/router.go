@@ -1,10 +1,13 @@
 package http
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
 
 	"github.com/appyzdl/Netrunner/pkg/http/status"
+	"github.com/appyzdl/Netrunner/pkg/trace"
 )
 
 type (
@@ -13,51 +16,199 @@ type (
 )
 
 type Route struct {
-	Method string
+	Method      string
 	PathPattern string
 }
 
+type route struct {
+	pattern string
+	handler HandlerFunc
+	// stream routes get the request body unread in Request.BodyReader.
+	stream bool
+}
+
 type Router struct {
-	routes     map[string]map[string]HandlerFunc
-	middleware []MiddlewareFunc
+	routes          map[string]map[string]*route
+	middleware      []MiddlewareFunc
+	middlewareNames []string
+
+	// ErrorHandler renders errors from AddRouteE handlers as well as the
+	// router's own 404 and 400 responses. Nil means DefaultErrorHandler.
+	ErrorHandler ErrorHandler
+
+	// Logger, if set, replaces the server's logger for requests handled by
+	// this router.
+	Logger *slog.Logger
+
+	// AllowInsecure serves cleartext requests instead of redirecting them
+	// to HTTPS, for internal listeners such as the metrics endpoint.
+	AllowInsecure bool
+
+	// Tracer, if set, traces each request in a server span continuing the
+	// client's traceparent, with a child span per middleware. Requests made
+	// with Client or ReverseProxy from the request's context become child
+	// spans too.
+	Tracer *trace.Tracer
+
+	// ErrorPages, if set, supplies the HTML pages for the router's own
+	// errors and for NotFoundResponseFor, InternalServerErrorResponseFor and
+	// DefaultErrorHandler in its handlers. It replaces the server's pages.
+	ErrorPages *ErrorPages
+
+	// MaxBodyBytes limits the request bodies read for AddRoute handlers;
+	// larger ones get 413 Content Too Large. NewRouter sets it to
+	// DefaultMaxBodyBytes and 0 means no limit. AddStreamRoute handlers
+	// read the body themselves and aren't limited.
+	MaxBodyBytes int64
 }
 
+// DefaultMaxBodyBytes is the request body limit of a new Router.
+const DefaultMaxBodyBytes = 10 << 20
+
 func NewRouter() *Router {
 	return &Router{
-		routes:     make(map[string]map[string]HandlerFunc),
-		middleware: []MiddlewareFunc{},
+		routes:       make(map[string]map[string]*route),
+		middleware:   []MiddlewareFunc{},
+		MaxBodyBytes: DefaultMaxBodyBytes,
 	}
 }
 
 func (r *Router) Use(mw MiddlewareFunc) {
 	r.middleware = append(r.middleware, mw)
+	r.middlewareNames = append(r.middlewareNames, "middleware "+middlewareName(mw))
 }
 
+// AddRoute registers a handler for method and path. A path ending in "/*"
+// matches every path below that prefix. The request body is read into
+// Request.Body before the handler runs.
 func (r *Router) AddRoute(method, path string, handler HandlerFunc) {
+	r.addRoute(method, path, &route{handler: handler})
+}
+
+// AddStreamRoute is like AddRoute but leaves the request body unread in
+// Request.BodyReader, for handlers such as proxies that forward it as it
+// arrives.
+func (r *Router) AddStreamRoute(method, path string, handler HandlerFunc) {
+	r.addRoute(method, path, &route{handler: handler, stream: true})
+}
+
+// AddRouteE is like AddRoute for a handler that returns an error. Errors
+// are rendered by the router's ErrorHandler.
+func (r *Router) AddRouteE(method, path string, handler HandlerFuncE) {
+	r.AddRoute(method, path, func(req *Request) *Response {
+		resp, err := handler(req)
+		if err != nil {
+			return r.handleError(req, err)
+		}
+		return resp
+	})
+}
+
+func (r *Router) handleError(req *Request, err error) *Response {
+	if r.ErrorHandler != nil {
+		return r.ErrorHandler(req, err)
+	}
+	return DefaultErrorHandler(req, err)
+}
+
+func (r *Router) addRoute(method, path string, rt *route) {
 	if _, ok := r.routes[method]; !ok {
-		r.routes[method] = make(map[string]HandlerFunc)
+		r.routes[method] = make(map[string]*route)
 	}
-	r.routes[method][path] = handler
+	rt.pattern = path
+	r.routes[method][path] = rt
 }
 
 func (r *Router) HandleRequest(req *Request) *Response {
-	if req.TLS == nil && r.shouldRedirectToHTTPS(req) {
+	if r.Logger != nil {
+		req.SetLogger(r.Logger.With(RequestAttrs(req)...))
+	}
+	if r.ErrorPages != nil {
+		req.errorPages = r.ErrorPages
+	}
+	if r.Tracer != nil {
+		return traceRequest(r.Tracer, req, r.handleRequest)
+	}
+	return r.handleRequest(req)
+}
+
+func (r *Router) handleRequest(req *Request) *Response {
+	if req.TLS == nil && !r.AllowInsecure && r.shouldRedirectToHTTPS(req) {
 		return r.redirectToHTTPS(req)
 	}
 
-	if handlers, ok := r.routes[req.Method]; ok {
-		if handler, ok := handlers[req.Path]; ok {
-			// middleware
-			for i := len(r.middleware) - 1; i >= 0; i-- {
-				handler = r.middleware[i](handler)
+	if rt := r.match(req.Method, requestPath(req)); rt != nil {
+		req.Route = rt.pattern
+		handler := rt.handler
+		if !rt.stream {
+			handler = r.readBody(handler)
+		}
+		// middleware
+		for i := len(r.middleware) - 1; i >= 0; i-- {
+			handler = r.middleware[i](handler)
+			if r.Tracer != nil {
+				handler = traceStep(r.Tracer, r.middlewareNames[i], handler)
+			}
+		}
+		return handler(req)
+	}
+	return r.handleError(req, NewHTTPError(status.NotFound, ""))
+}
+
+// match finds the route for a path: an exact match wins, otherwise the
+// longest "/*" prefix pattern that covers the path.
+func (r *Router) match(method, path string) *route {
+	handlers, ok := r.routes[method]
+	if !ok {
+		return nil
+	}
+	if rt, ok := handlers[path]; ok {
+		return rt
+	}
+
+	var best *route
+	bestLen := -1
+	for pattern, rt := range handlers {
+		prefix, ok := strings.CutSuffix(pattern, "*")
+		if !ok || !strings.HasSuffix(prefix, "/") {
+			continue
+		}
+		if (strings.HasPrefix(path, prefix) || path+"/" == prefix) && len(prefix) > bestLen {
+			best, bestLen = rt, len(prefix)
+		}
+	}
+	return best
+}
+
+// requestPath returns the request path without the query string.
+func requestPath(req *Request) string {
+	if req.URL != nil && req.URL.Path != "" {
+		return req.URL.Path
+	}
+	path, _, _ := strings.Cut(req.Path, "?")
+	return path
+}
+
+// readBody reads the request body before calling the handler, so handlers
+// registered with AddRoute can use Request.Body directly.
+func (r *Router) readBody(next HandlerFunc) HandlerFunc {
+	return func(req *Request) *Response {
+		if _, err := req.ReadBodyLimit(r.MaxBodyBytes); err != nil {
+			if errors.Is(err, ErrBodyTooLarge) {
+				return r.handleError(req, NewHTTPError(status.ContentTooLarge, ""))
 			}
-			return handler(req)
+			return r.handleError(req, WrapHTTPError(status.BadRequest, "Invalid request body", err))
 		}
+		return next(req)
 	}
-	return NotFoundResponse()
 }
 
 func (r *Router) shouldRedirectToHTTPS(req *Request) bool {
+	// Cleartext HTTP/2 (h2c) only comes from clients that chose it on
+	// purpose, such as services on a trusted network, so it isn't redirected.
+	if req.Version == "HTTP/2.0" {
+		return false
+	}
 	return !strings.HasPrefix(req.Path, "/static/")
 }
 
@@ -70,6 +221,10 @@ func (r *Router) redirectToHTTPS(req *Request) *Response {
 	return resp
 }
 
+// NotFoundResponse returns a plain-text 404.
+//
+// Deprecated: Use NotFoundResponseFor, which honors Accept and custom
+// error pages.
 func NotFoundResponse() *Response {
 	resp := NewResponse()
 	resp.StatusCode = 404
@@ -77,3 +232,9 @@ func NotFoundResponse() *Response {
 	resp.SetBody([]byte("404 - Not Found"))
 	return resp
 }
+
+// NotFoundResponseFor returns a 404 in the format req accepts, using the
+// custom page from the router or server if there is one.
+func NotFoundResponseFor(req *Request) *Response {
+	return renderError(req, NewHTTPError(status.NotFound, ""))
+}
@@ -0,0 +1,688 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http/status"
+)
+
+// ErrServerClosed is returned by Serve after Close has been called.
+var ErrServerClosed = errors.New("http: server closed")
+
+// maxDrainBytes is how much of an unread request body the server will
+// discard to keep a connection alive for the next request.
+const maxDrainBytes = 256 << 10
+
+// Server accepts connections and serves HTTP/1.1 requests on them, with
+// keep-alive and streamed request and response bodies.
+//
+// Handlers receive the request body unread in Request.BodyReader; the
+// Router reads it into Request.Body for routes added with AddRoute.
+type Server struct {
+	Addr      string
+	Handler   HandlerFunc
+	TLSConfig *tls.Config
+
+	// ReadTimeout bounds reading a request: the request line, headers and
+	// body. IdleTimeout bounds how long a kept-alive connection may wait
+	// for the next request.
+	ReadTimeout time.Duration
+	IdleTimeout time.Duration
+
+	// TLSNextProto maps ALPN protocol names to functions that take over a
+	// TLS connection once that protocol has been negotiated, e.g. "h2" as
+	// set up by http2.ConfigureServer, and is passed how long the handshake
+	// took. The connection is closed when the function returns.
+	TLSNextProto map[string]func(s *Server, conn net.Conn, state *tls.ConnectionState, handshake time.Duration)
+
+	// H2C, when set, serves cleartext HTTP/2 on non-TLS connections that
+	// open with the HTTP/2 preface (upgrade is nil) or whose request asks
+	// for "Upgrade: h2c", in which case the 101 response has already been
+	// sent and the request body read. Set by http2.ConfigureH2C.
+	H2C func(s *Server, conn net.Conn, reader *bufio.Reader, upgrade *Request)
+
+	// Logger receives the server's log records and is the base of every
+	// request's Logger. Nil means slog.Default(). Requests are logged at
+	// debug level, with sensitive headers redacted.
+	Logger *slog.Logger
+
+	// Metrics, if set, records connection states, TLS handshakes, bytes
+	// read and written and request parse errors.
+	Metrics *Metrics
+
+	// ErrorPages, if set, supplies the HTML pages for the server's own
+	// error responses and is passed on to every request's handler.
+	ErrorPages *ErrorPages
+
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{}
+	conns      map[net.Conn]bool // true while idle between requests
+	onShutdown []func()
+	closed     bool
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+func NewServer(addr string, handler HandlerFunc) *Server {
+	return &Server{
+		Addr:        addr,
+		Handler:     handler,
+		ReadTimeout: 30 * time.Second,
+		IdleTimeout: 60 * time.Second,
+	}
+}
+
+// ListenAndServe listens on s.Addr and serves plain HTTP.
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to start server: %v", err)
+	}
+	return s.Serve(listener)
+}
+
+// ListenAndServeTLS listens on s.Addr and serves HTTPS using the given
+// certificate, or s.TLSConfig if both file names are empty.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	config := &tls.Config{}
+	if s.TLSConfig != nil {
+		config = s.TLSConfig.Clone()
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	listener, err := tls.Listen("tcp", s.Addr, config)
+	if err != nil {
+		return fmt.Errorf("failed to start server: %v", err)
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on the listener and serves each one in its own
+// goroutine until Close is called, then returns ErrServerClosed.
+func (s *Server) Serve(listener net.Listener) error {
+	if !s.trackListener(listener, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(listener, false)
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			s.logger().Error("Failed to accept connection", "error", err)
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		if !s.trackConn(conn, true) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close immediately closes all listeners and connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	var err error
+	for listener := range s.listeners {
+		if cerr := listener.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return err
+}
+
+// RegisterOnShutdown registers a function to call when Shutdown starts,
+// so protocols running on taken-over connections can wind them down.
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onShutdown = append(s.onShutdown, f)
+}
+
+// Shutdown stops accepting connections, closes idle ones and waits for the
+// rest to finish their current request before closing them. It returns the
+// context's error if that expires first; Close can then end the stragglers.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	for listener := range s.listeners {
+		if cerr := listener.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	hooks := s.onShutdown
+	s.mu.Unlock()
+
+	for _, f := range hooks {
+		go f()
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeIdleConns closes connections waiting for a request and reports
+// whether no connections remain.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, idle := range s.conns {
+		if idle {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) setIdle(conn net.Conn, idle bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.conns[conn]; ok {
+		s.conns[conn] = idle
+	}
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *Server) trackListener(listener net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if add {
+		if s.closed {
+			return false
+		}
+		if s.listeners == nil {
+			s.listeners = make(map[net.Listener]struct{})
+		}
+		s.listeners[listener] = struct{}{}
+	} else {
+		delete(s.listeners, listener)
+	}
+	return true
+}
+
+func (s *Server) trackConn(conn net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if add {
+		if s.closed {
+			return false
+		}
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]bool)
+		}
+		s.conns[conn] = true
+	} else {
+		delete(s.conns, conn)
+	}
+	return true
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		// A panicking handler takes down only its own connection.
+		if v := recover(); v != nil && v != ErrAbortHandler {
+			s.logger().Error("Panic serving connection", "remote", conn.RemoteAddr().String(), "panic", v, "stack", string(debug.Stack()))
+		}
+	}()
+	defer s.trackConn(conn, false)
+	defer conn.Close()
+	tracker := s.Metrics.trackConn()
+	defer tracker.close()
+
+	var tlsState *tls.ConnectionState
+	var handshake time.Duration
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn.SetDeadline(time.Now().Add(s.ReadTimeout))
+		start := time.Now()
+		err := tlsConn.Handshake()
+		handshake = time.Since(start)
+		s.Metrics.tlsHandshake(err)
+		if err != nil {
+			s.logger().Debug("TLS handshake error", "remote", conn.RemoteAddr().String(), "error", err)
+			return
+		}
+		state := tlsConn.ConnectionState()
+		tlsState = &state
+		conn.SetDeadline(time.Time{})
+	}
+
+	// rw is what the protocol is spoken over; conn stays the key the
+	// server tracks the connection by.
+	rw := s.Metrics.countConn(conn)
+	if tlsState != nil {
+		if next := s.TLSNextProto[tlsState.NegotiatedProtocol]; next != nil {
+			s.setIdle(conn, false)
+			tracker.set(connStateUpgraded)
+			next(s, rw, tlsState, handshake)
+			return
+		}
+	}
+
+	reader := bufio.NewReader(rw)
+	writer := bufio.NewWriter(rw)
+	connID := NextConnID()
+
+	for served := 0; ; served++ {
+		// Wait for the first byte of the next request. A kept-alive
+		// connection that stays idle is simply closed.
+		wait := s.ReadTimeout
+		if served > 0 {
+			wait = s.IdleTimeout
+		}
+		conn.SetReadDeadline(time.Now().Add(wait))
+		s.setIdle(conn, true)
+		if served > 0 {
+			tracker.set(connStateIdle)
+		}
+		if _, err := reader.Peek(1); err != nil {
+			if served == 0 {
+				s.handleReadError(writer, conn, err)
+			}
+			return
+		}
+		s.setIdle(conn, false)
+		tracker.set(connStateActive)
+
+		conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+		if s.H2C != nil && tlsState == nil && served == 0 && hasH2Preface(reader) {
+			conn.SetReadDeadline(time.Time{})
+			tracker.set(connStateUpgraded)
+			s.H2C(s, rw, reader, nil)
+			return
+		}
+		request, err := ReadRequest(reader)
+		if err != nil {
+			s.handleReadError(writer, conn, err)
+			return
+		}
+		if request.BodyReader == nil {
+			conn.SetReadDeadline(time.Time{})
+		}
+		// Otherwise the deadline stays until the next request so a client
+		// can't hold a handler by trickling the body.
+
+		request.TLS = tlsState
+		request.RemoteAddr = conn.RemoteAddr().String()
+		request.LocalAddr = conn.LocalAddr().String()
+		request.ClientIP = remoteHost(request.RemoteAddr)
+		request.ConnID = connID
+		request.ConnSeq = uint64(served + 1)
+		request.errorPages = s.ErrorPages
+		if served == 0 {
+			request.TLSHandshake = handshake
+		}
+		request.SetLogger(s.logger().With(RequestAttrs(request)...))
+		request.Logger().Debug("Received request", HeadersAttr("headers", request.Headers))
+
+		interim := &informationalWriter{w: writer}
+		if request.Version != "HTTP/1.0" {
+			request.SendInformational = interim.write
+		}
+		var expect *expectContinueReader
+		if value, ok := request.Headers["Expect"]; ok && request.Version != "HTTP/1.0" {
+			if !strings.EqualFold(value, "100-continue") {
+				s.writeHTTPError(writer, request, NewHTTPError(status.ExpectationFailed, "Unsupported expectation"))
+				return
+			}
+			if request.BodyReader != nil {
+				expect = &expectContinueReader{r: request.BodyReader, interim: interim}
+				request.BodyReader = expect
+			}
+		}
+
+		if s.H2C != nil && tlsState == nil && isH2CUpgrade(request) {
+			tracker.set(connStateUpgraded)
+			s.upgradeH2C(rw, reader, writer, request)
+			return
+		}
+
+		body := request.BodyReader
+		response := s.Handler(request)
+		if response == nil {
+			response = InternalServerErrorResponseFor(request)
+		}
+
+		keepAlive := wantsKeepAlive(request) && !s.isClosed()
+		if continued := interim.finish(); expect != nil && !continued {
+			// The client was never told to send the body and may or may not
+			// do so anyway, so the connection can't be reused.
+			keepAlive = false
+			body = nil
+		}
+		keepAlive, err = writeResponse(writer, request, response, keepAlive)
+		if err != nil {
+			request.Logger().Debug("Error writing response", "error", err)
+			return
+		}
+		if response.Hijack != nil {
+			conn.SetDeadline(time.Time{})
+			tracker.set(connStateUpgraded)
+			response.Hijack(rw, reader)
+			return
+		}
+
+		if body != nil {
+			// Discard whatever the handler left unread so the next request
+			// starts at a message boundary.
+			n, err := io.Copy(io.Discard, io.LimitReader(body, maxDrainBytes+1))
+			if err != nil || n > maxDrainBytes {
+				return
+			}
+		}
+		if !keepAlive {
+			return
+		}
+	}
+}
+
+// informationalWriter writes 1xx responses on an HTTP/1.1 connection
+// until the final response starts.
+type informationalWriter struct {
+	w *bufio.Writer
+
+	mu        sync.Mutex
+	done      bool
+	continued bool
+}
+
+func (iw *informationalWriter) write(code int, headers map[string]string) error {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+	if iw.done {
+		return ErrFinalResponseSent
+	}
+
+	resp := NewResponse()
+	resp.StatusCode = code
+	for key, value := range headers {
+		resp.Headers[key] = value
+	}
+	if err := resp.writeHead(iw.w); err != nil {
+		return err
+	}
+	if err := iw.w.Flush(); err != nil {
+		return err
+	}
+	if code == status.Continue {
+		iw.continued = true
+	}
+	return nil
+}
+
+// finish stops further interim responses once the final one is about to be
+// written, and reports whether 100 Continue was sent.
+func (iw *informationalWriter) finish() bool {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+	iw.done = true
+	return iw.continued
+}
+
+// expectContinueReader sends "100 Continue" when the handler first reads a
+// body the client is holding back, so a handler or middleware that rejects
+// the request first (auth, size limits) saves the client the upload.
+type expectContinueReader struct {
+	r       io.Reader
+	interim *informationalWriter
+	asked   bool
+}
+
+func (e *expectContinueReader) Read(p []byte) (int, error) {
+	if !e.asked {
+		e.asked = true
+		if err := e.interim.write(status.Continue, nil); err != nil && err != ErrFinalResponseSent {
+			return 0, err
+		}
+	}
+	return e.r.Read(p)
+}
+
+// h2Preface is the start of every HTTP/2 connection.
+const h2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// hasH2Preface reports whether the connection opens with the HTTP/2
+// preface. It only waits for more bytes while those seen so far match, so
+// short HTTP/1 requests are never held up.
+func hasH2Preface(reader *bufio.Reader) bool {
+	for n := 1; n <= len(h2Preface); n++ {
+		peeked, err := reader.Peek(n)
+		if err != nil || peeked[n-1] != h2Preface[n-1] {
+			return false
+		}
+	}
+	return true
+}
+
+func isH2CUpgrade(req *Request) bool {
+	return req.Version == "HTTP/1.1" && req.Method != "CONNECT" &&
+		headerHasToken(req.Headers["Upgrade"], "h2c") &&
+		headerHasToken(req.Headers["Connection"], "upgrade") &&
+		headerHasToken(req.Headers["Connection"], "http2-settings") &&
+		req.Headers["Http2-Settings"] != ""
+}
+
+// upgradeH2C switches the connection to HTTP/2 after reading the whole
+// request body, which the upgraded request is then served with.
+func (s *Server) upgradeH2C(conn net.Conn, reader *bufio.Reader, writer *bufio.Writer, req *Request) {
+	if _, err := req.ReadBodyLimit(DefaultMaxBodyBytes); err != nil {
+		if errors.Is(err, ErrBodyTooLarge) {
+			s.writeHTTPError(writer, req, NewHTTPError(status.ContentTooLarge, ""))
+			return
+		}
+		s.writeHTTPError(writer, req, NewHTTPError(status.BadRequest, "Invalid request body"))
+		return
+	}
+
+	resp := NewResponse()
+	resp.StatusCode = status.SwitchingProtocols
+	resp.StatusText = StatusText(status.SwitchingProtocols)
+	resp.SetHeader("Connection", "Upgrade")
+	resp.SetHeader("Upgrade", "h2c")
+	if err := resp.writeHead(writer); err != nil || writer.Flush() != nil {
+		return
+	}
+	s.H2C(s, conn, reader, req)
+}
+
+func headerHasToken(header, token string) bool {
+	for _, part := range strings.Split(header, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) handleReadError(writer *bufio.Writer, conn net.Conn, err error) {
+	if err == io.EOF || errors.Is(err, net.ErrClosed) {
+		return
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		s.Metrics.parseError("timeout")
+		s.writeHTTPError(writer, nil, NewHTTPError(status.RequestTimeout, "Request timeout"))
+		return
+	}
+	if errors.Is(err, errHeaderTooLarge) {
+		s.Metrics.parseError("header_too_large")
+		s.writeHTTPError(writer, nil, NewHTTPError(status.RequestHeaderFieldsTooLarge, "Request header too large"))
+		return
+	}
+	s.Metrics.parseError("malformed")
+	s.logger().Debug("Error parsing request", "remote", conn.RemoteAddr().String(), "error", err)
+	s.writeHTTPError(writer, nil, NewHTTPError(status.BadRequest, "Invalid request"))
+}
+
+// writeHTTPError writes an error response and closes the connection. req is
+// nil when the request couldn't be parsed.
+func (s *Server) writeHTTPError(writer *bufio.Writer, req *Request, err *HTTPError) {
+	var response *Response
+	if req == nil && s.ErrorPages != nil {
+		response = s.ErrorPages.Render(nil, err)
+	} else {
+		response = renderError(req, err)
+	}
+	response.SetHeader("Connection", "close")
+
+	response.writeHead(writer)
+	writer.Write(response.Body)
+	writer.Flush()
+}
+
+func wantsKeepAlive(req *Request) bool {
+	connection := strings.ToLower(req.Headers["Connection"])
+	if req.Version == "HTTP/1.0" {
+		return strings.Contains(connection, "keep-alive")
+	}
+	return !strings.Contains(connection, "close")
+}
+
+// writeResponse frames and writes the response. It reports whether the
+// connection can be kept open for another request.
+func writeResponse(writer *bufio.Writer, req *Request, resp *Response, keepAlive bool) (bool, error) {
+	if resp.Hijack != nil {
+		// The connection is handed over after the head, so there is no
+		// body framing and it is never reused for HTTP.
+		if err := resp.writeHead(writer); err != nil {
+			return false, err
+		}
+		return false, writer.Flush()
+	}
+	if closer, ok := resp.BodyReader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if strings.EqualFold(resp.Headers["Connection"], "close") {
+		keepAlive = false
+	}
+
+	bodyAllowed := resp.StatusCode >= 200 && resp.StatusCode != status.NoContent && resp.StatusCode != status.NotModified
+	_, hasLength := resp.Headers["Content-Length"]
+	chunked := false
+	switch {
+	case !bodyAllowed:
+		delete(resp.Headers, "Content-Length")
+	case resp.BodyReader != nil && !hasLength:
+		if req.Version == "HTTP/1.1" {
+			chunked = true
+			resp.Headers["Transfer-Encoding"] = "chunked"
+		} else {
+			// The end of the body is signalled by closing the connection.
+			keepAlive = false
+		}
+	case resp.BodyReader == nil && !hasLength:
+		resp.Headers["Content-Length"] = strconv.Itoa(len(resp.Body))
+	}
+
+	if keepAlive {
+		if req.Version == "HTTP/1.0" {
+			resp.Headers["Connection"] = "keep-alive"
+		}
+	} else {
+		resp.Headers["Connection"] = "close"
+	}
+
+	if err := resp.writeHead(writer); err != nil {
+		return false, err
+	}
+	if !bodyAllowed || req.Method == "HEAD" {
+		return keepAlive, writer.Flush()
+	}
+
+	if resp.BodyReader == nil {
+		writer.Write(resp.Body)
+		return keepAlive, writer.Flush()
+	}
+
+	var dst io.Writer = writer
+	var cw io.WriteCloser
+	if chunked {
+		cw = newChunkedWriter(writer)
+		dst = cw
+	}
+	if err := streamBody(dst, writer, resp.BodyReader); err != nil {
+		// The head has already gone out, so the only way to signal a broken
+		// stream is to close the connection without the final chunk.
+		writer.Flush()
+		return false, err
+	}
+	if cw != nil {
+		cw.Close()
+	}
+	return keepAlive, writer.Flush()
+}
+
+// streamBody copies src to dst, flushing after every read so data that is
+// produced slowly (proxied or event streams) is not held in the buffer.
+func streamBody(dst io.Writer, flusher *bufio.Writer, src io.Reader) error {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if ferr := flusher.Flush(); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package http
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timing is a named phase of handling a request, reported to the browser
+// in the Server-Timing header.
+type Timing struct {
+	Name        string
+	Description string
+	Duration    time.Duration
+}
+
+// Timings collects the phases recorded while handling a request. It is
+// safe for concurrent use.
+type Timings struct {
+	mu      sync.Mutex
+	entries []Timing
+}
+
+// Timings returns the request's timings, for handlers and middleware to
+// record phases such as "db" or "render" in.
+func (r *Request) Timings() *Timings {
+	if r.timings == nil {
+		r.timings = &Timings{}
+	}
+	return r.timings
+}
+
+// Record adds a phase that took d.
+func (t *Timings) Record(name, description string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = append(t.entries, Timing{Name: name, Description: description, Duration: d})
+}
+
+// Start begins timing a phase; call the returned function when it ends.
+//
+//	defer req.Timings().Start("db", "Load cart")()
+func (t *Timings) Start(name, description string) func() {
+	start := time.Now()
+	return func() { t.Record(name, description, time.Since(start)) }
+}
+
+// All returns the recorded phases in the order they were recorded.
+func (t *Timings) All() []Timing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Timing(nil), t.entries...)
+}
+
+// ServerTimingOptions says who gets the Server-Timing header, which
+// reveals how long internal work takes and so isn't sent to everyone by
+// default.
+type ServerTimingOptions struct {
+	// Enabled sends the header on every response.
+	Enabled bool
+
+	// AllowedOrigins sends it only for requests from these origins, such
+	// as "https://app.example.com", judged by the Origin or Referer header.
+	// Those responses also get Timing-Allow-Origin so the page can read
+	// the timings of cross-origin requests, and every response gets
+	// Vary: Origin so caches don't share them between origins.
+	AllowedOrigins []string
+}
+
+// NewServerTimingMiddleware reports the request's Timings plus "total",
+// the time the rest of the handler chain took, and "tls", the TLS
+// handshake on the first request of a connection. A Server-Timing header
+// from a proxied backend is kept for clients that may see timings and
+// removed for the rest.
+func NewServerTimingMiddleware(opts ServerTimingOptions) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) *Response {
+			start := time.Now()
+			resp := next(req)
+			if resp == nil {
+				return resp
+			}
+			if len(opts.AllowedOrigins) > 0 {
+				resp.SetHeader("Vary", appendVary(resp.Headers["Vary"], "Origin"))
+			}
+			origin, allowed := opts.allowed(req)
+			if !allowed {
+				delete(resp.Headers, "Server-Timing")
+				return resp
+			}
+
+			timings := req.Timings().All()
+			if req.TLSHandshake > 0 {
+				timings = append(timings, Timing{Name: "tls", Description: "TLS handshake", Duration: req.TLSHandshake})
+			}
+			timings = append(timings, Timing{Name: "total", Duration: time.Since(start)})
+			value := FormatServerTiming(timings)
+			if upstream := resp.Headers["Server-Timing"]; upstream != "" {
+				// Keep the timings of a proxied backend.
+				value = upstream + ", " + value
+			}
+			resp.SetHeader("Server-Timing", value)
+			if origin != "" {
+				resp.SetHeader("Timing-Allow-Origin", origin)
+			}
+			return resp
+		}
+	}
+}
+
+// allowed reports whether req may see timings and, for allowed origins,
+// which origin to name in Timing-Allow-Origin.
+func (o ServerTimingOptions) allowed(req *Request) (string, bool) {
+	origin := req.Headers["Origin"]
+	if origin == "" {
+		if u, err := url.Parse(req.Headers["Referer"]); err == nil && u.Host != "" {
+			origin = u.Scheme + "://" + u.Host
+		}
+	}
+	for _, allowed := range o.AllowedOrigins {
+		if origin != "" && strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+	}
+	return "", o.Enabled
+}
+
+func appendVary(vary, field string) string {
+	if headerHasToken(vary, field) {
+		return vary
+	}
+	if vary == "" {
+		return field
+	}
+	return vary + ", " + field
+}
+
+// FormatServerTiming formats timings as a Server-Timing header value, with
+// durations in milliseconds: `db;dur=53.2;desc="Load cart", total;dur=80`.
+func FormatServerTiming(timings []Timing) string {
+	var b strings.Builder
+	for _, t := range timings {
+		name := timingToken(t.Name)
+		if name == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(name)
+		b.WriteString(";dur=")
+		b.WriteString(strconv.FormatFloat(float64(t.Duration.Microseconds())/1000, 'f', -1, 64))
+		if t.Description != "" {
+			b.WriteString(`;desc="`)
+			for _, c := range []byte(t.Description) {
+				if c < ' ' || c == 0x7f {
+					continue
+				}
+				if c == '"' || c == '\\' {
+					b.WriteByte('\\')
+				}
+				b.WriteByte(c)
+			}
+			b.WriteByte('"')
+		}
+	}
+	return b.String()
+}
+
+// timingToken drops the characters a metric name can't contain.
+func timingToken(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r > ' ' && r < 0x7f && !strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return r
+		}
+		return -1
+	}, name)
+}
@@ -0,0 +1,161 @@
+package sse
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Broker fans events out to every subscriber of a topic. Each topic keeps
+// its own replay buffer, so clients reconnecting to a broker handler are
+// sent what they missed before live events resume.
+type Broker struct {
+	// NewReplay creates the replay buffer for a topic; nil disables replay.
+	NewReplay func() ReplayBuffer
+
+	// BufferSize is how many events may queue for a subscriber. One that
+	// falls further behind is disconnected; its client will reconnect and
+	// catch up from the replay buffer.
+	BufferSize int
+
+	Heartbeat time.Duration
+	Retry     time.Duration
+
+	mu     sync.Mutex
+	topics map[string]*topic
+}
+
+type topic struct {
+	subscribers map[*Subscription]struct{}
+	replay      ReplayBuffer
+	nextID      uint64
+}
+
+// Subscription receives the events published to one topic.
+type Subscription struct {
+	// Events is closed when the subscription ends.
+	Events <-chan Event
+
+	events chan Event
+	broker *Broker
+	topic  string
+	once   sync.Once
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		NewReplay:  func() ReplayBuffer { return NewRingBuffer(100) },
+		BufferSize: 64,
+		Heartbeat:  15 * time.Second,
+		topics:     make(map[string]*topic),
+	}
+}
+
+func (b *Broker) topicLocked(name string) *topic {
+	if b.topics == nil {
+		b.topics = make(map[string]*topic)
+	}
+	t, ok := b.topics[name]
+	if !ok {
+		t = &topic{subscribers: make(map[*Subscription]struct{})}
+		if b.NewReplay != nil {
+			t.replay = b.NewReplay()
+		}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// Publish sends an event to all current subscribers of the topic. Events
+// without an ID are numbered so clients can resume after them.
+func (b *Broker) Publish(name string, e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.topicLocked(name)
+	t.nextID++
+	if e.ID == "" {
+		e.ID = strconv.FormatUint(t.nextID, 10)
+	}
+	if t.replay != nil {
+		t.replay.Add(e)
+	}
+	for sub := range t.subscribers {
+		select {
+		case sub.events <- e:
+		default:
+			delete(t.subscribers, sub)
+			sub.once.Do(func() { close(sub.events) })
+		}
+	}
+}
+
+// Subscribe starts receiving a topic's events. If lastEventID is found in
+// the replay buffer, the events after it are returned as well; taking both
+// under one lock means none are lost or duplicated in between.
+func (b *Broker) Subscribe(name, lastEventID string) (*Subscription, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.topicLocked(name)
+	events := make(chan Event, b.BufferSize)
+	sub := &Subscription{Events: events, events: events, broker: b, topic: name}
+	t.subscribers[sub] = struct{}{}
+
+	var missed []Event
+	if t.replay != nil && lastEventID != "" {
+		missed, _ = t.replay.Since(lastEventID)
+	}
+	return sub, missed
+}
+
+// Close ends the subscription and closes Events.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	if t, ok := s.broker.topics[s.topic]; ok {
+		delete(t.subscribers, s)
+	}
+	s.once.Do(func() { close(s.events) })
+}
+
+// Subscribers returns the number of current subscribers of a topic.
+func (b *Broker) Subscribers(name string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if t, ok := b.topics[name]; ok {
+		return len(t.subscribers)
+	}
+	return 0
+}
+
+// Handler returns an SSE handler that streams a topic to each client.
+func (b *Broker) Handler(name string) *Handler {
+	h := NewHandler(func(stream *Stream) {
+		sub, missed := b.Subscribe(name, stream.LastEventID)
+		defer sub.Close()
+
+		for _, e := range missed {
+			if stream.Send(e) != nil {
+				return
+			}
+		}
+		for {
+			select {
+			case e, ok := <-sub.Events:
+				if !ok {
+					return
+				}
+				if stream.Send(e) != nil {
+					return
+				}
+			case <-stream.Done():
+				return
+			}
+		}
+	})
+	h.Heartbeat = b.Heartbeat
+	h.Retry = b.Retry
+	return h
+}
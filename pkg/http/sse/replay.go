@@ -0,0 +1,59 @@
+package sse
+
+import "sync"
+
+// ReplayBuffer remembers recent events so a client reconnecting with
+// Last-Event-ID can be sent the ones it missed.
+type ReplayBuffer interface {
+	Add(e Event)
+
+	// Since returns the events after the one with the given ID. It reports
+	// false if that ID is no longer (or was never) in the buffer, in which
+	// case the client may have missed more than can be replayed.
+	Since(lastEventID string) ([]Event, bool)
+}
+
+// RingBuffer is an in-memory ReplayBuffer holding the most recent events.
+type RingBuffer struct {
+	mu     sync.Mutex
+	events []Event
+	start  int
+	size   int
+}
+
+func NewRingBuffer(size int) *RingBuffer {
+	return &RingBuffer{events: make([]Event, size)}
+}
+
+func (r *RingBuffer) Add(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.events) == 0 {
+		return
+	}
+	if r.size < len(r.events) {
+		r.events[(r.start+r.size)%len(r.events)] = e
+		r.size++
+		return
+	}
+	r.events[r.start] = e
+	r.start = (r.start + 1) % len(r.events)
+}
+
+func (r *RingBuffer) Since(lastEventID string) ([]Event, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := r.size - 1; i >= 0; i-- {
+		if r.events[(r.start+i)%len(r.events)].ID != lastEventID {
+			continue
+		}
+		missed := make([]Event, 0, r.size-1-i)
+		for j := i + 1; j < r.size; j++ {
+			missed = append(missed, r.events[(r.start+j)%len(r.events)])
+		}
+		return missed, true
+	}
+	return nil, false
+}
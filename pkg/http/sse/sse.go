@@ -0,0 +1,242 @@
+// Package sse implements Server-Sent Events (the text/event-stream format of
+// the HTML Living Standard) on top of Netrunner's streaming responses.
+package sse
+
+import (
+	"errors"
+	"io"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+	"github.com/appyzdl/Netrunner/pkg/http/status"
+)
+
+// ErrStreamClosed is returned by Stream methods once the client has
+// disconnected or the handler has returned.
+var ErrStreamClosed = errors.New("sse: stream closed")
+
+// Event is a single message. Only Data is required.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+
+	// Retry tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+// String formats the event as it is sent on the wire. Multi-line data is
+// split into one data field per line; newlines in ID and Event would end
+// the field early, so they are dropped.
+func (e Event) String() string {
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + stripNewlines(strings.ReplaceAll(e.ID, "\x00", "")) + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + stripNewlines(e.Event) + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func stripNewlines(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// Stream is one client's open event stream.
+type Stream struct {
+	// Request is the request that opened the stream.
+	Request *http.Request
+
+	// LastEventID is the Last-Event-ID the client sent when reconnecting.
+	LastEventID string
+
+	mu     sync.Mutex
+	w      *io.PipeWriter
+	done   chan struct{}
+	closed bool
+}
+
+// Send writes an event to the client.
+func (s *Stream) Send(e Event) error {
+	return s.write(e.String())
+}
+
+// Comment writes a comment line, which clients ignore. It is mostly useful
+// to keep intermediaries from timing out an idle connection.
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range strings.Split(stripCR(text), "\n") {
+		b.WriteString(":" + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Done is closed when the client disconnects.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Stream) write(data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStreamClosed
+	}
+	if _, err := io.WriteString(s.w, data); err != nil {
+		s.closeLocked()
+		return ErrStreamClosed
+	}
+	return nil
+}
+
+func (s *Stream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeLocked()
+}
+
+func (s *Stream) closeLocked() {
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+		s.w.Close()
+	}
+}
+
+func stripCR(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\r", "\n")
+}
+
+// streamBody is the response body of an event stream. The server closes it
+// when the response ends, which is how a handler learns the client left.
+type streamBody struct {
+	*io.PipeReader
+	stream *Stream
+}
+
+func (b *streamBody) Close() error {
+	b.stream.close()
+	return b.PipeReader.Close()
+}
+
+// Handler serves an event stream, calling Serve with each new client.
+type Handler struct {
+	Serve func(stream *Stream)
+
+	// Heartbeat is the interval between keep-alive comments; 0 disables
+	// them. Heartbeats are also what detects a client that vanished while
+	// no events were being sent.
+	Heartbeat time.Duration
+
+	// Retry, if set, is sent to each client as its reconnection delay.
+	Retry time.Duration
+
+	// Replay, if set, supplies the events a reconnecting client missed.
+	Replay ReplayBuffer
+}
+
+func NewHandler(serve func(stream *Stream)) *Handler {
+	return &Handler{
+		Serve:     serve,
+		Heartbeat: 15 * time.Second,
+	}
+}
+
+func (h *Handler) HandleRequest(req *http.Request) *http.Response {
+	if req.Method != "GET" {
+		resp := http.NewResponse()
+		resp.StatusCode = status.MethodNotAllowed
+		resp.StatusText = http.StatusText(status.MethodNotAllowed)
+		resp.SetHeader("Allow", "GET")
+		resp.SetBody([]byte("405 - Method Not Allowed"))
+		return resp
+	}
+
+	pr, pw := io.Pipe()
+	stream := &Stream{
+		Request:     req,
+		LastEventID: req.Headers["Last-Event-Id"],
+		w:           pw,
+		done:        make(chan struct{}),
+	}
+
+	go h.run(stream)
+
+	resp := http.NewResponse()
+	resp.StatusCode = status.OK
+	resp.StatusText = http.StatusText(status.OK)
+	resp.SetHeader("Content-Type", "text/event-stream; charset=utf-8")
+	resp.SetHeader("Cache-Control", "no-cache")
+	// Ask buffering reverse proxies such as nginx to pass events through.
+	resp.SetHeader("X-Accel-Buffering", "no")
+	resp.BodyReader = &streamBody{PipeReader: pr, stream: stream}
+	return resp
+}
+
+func (h *Handler) run(stream *Stream) {
+	defer stream.close()
+	defer func() {
+		// Serve runs outside the request's handler chain, so a panic here
+		// would escape RecoveryMiddleware and crash the server. Log it and
+		// end the stream instead.
+		if v := recover(); v != nil && v != http.ErrAbortHandler {
+			stream.Request.Logger().Error("Panic serving event stream", "panic", v, "stack", string(debug.Stack()))
+		}
+	}()
+
+	if h.Retry > 0 {
+		stream.write("retry: " + strconv.FormatInt(h.Retry.Milliseconds(), 10) + "\n\n")
+	} else {
+		// Get the response head and first chunk out straight away so the
+		// client's EventSource fires onopen.
+		stream.Comment("")
+	}
+
+	if h.Replay != nil && stream.LastEventID != "" {
+		events, _ := h.Replay.Since(stream.LastEventID)
+		for _, e := range events {
+			if stream.Send(e) != nil {
+				return
+			}
+		}
+	}
+
+	if h.Heartbeat > 0 {
+		go func() {
+			ticker := time.NewTicker(h.Heartbeat)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if stream.Comment("heartbeat") != nil {
+						return
+					}
+				case <-stream.done:
+					return
+				}
+			}
+		}()
+	}
+
+	if h.Serve != nil {
+		h.Serve(stream)
+	} else {
+		<-stream.done
+	}
+}
@@ -1,45 +1,190 @@
 package status
 
+// Status codes from the IANA HTTP Status Code Registry.
 const (
-	OK                   = 200
-	Created              = 201
-	Accepted             = 202
-	NoContent            = 204
-	MovedPermanently     = 301
-	Found                = 302
-	BadRequest           = 400
-	Unauthorized         = 401
-	Forbidden            = 403
-	NotFound             = 404
-	MethodNotAllowed     = 405
-	StatusRequestTimeout = 408
-	IamATeaPot           = 418
-	InternalServerError  = 500
-	NotImplemented       = 501
-	BadGateway           = 502
-	ServiceUnavailable   = 503
+	Continue                    = 100
+	SwitchingProtocols          = 101
+	Processing                  = 102
+	EarlyHints                  = 103
+	UploadResumptionSupported   = 104 // temporary registration
+	OK                          = 200
+	Created                     = 201
+	Accepted                    = 202
+	NonAuthoritativeInfo        = 203
+	NoContent                   = 204
+	ResetContent                = 205
+	PartialContent              = 206
+	MultiStatus                 = 207
+	AlreadyReported             = 208
+	IMUsed                      = 226
+	MultipleChoices             = 300
+	MovedPermanently            = 301
+	Found                       = 302
+	SeeOther                    = 303
+	NotModified                 = 304
+	UseProxy                    = 305
+	TemporaryRedirect           = 307 // 306 is reserved and unused
+	PermanentRedirect           = 308
+	BadRequest                  = 400
+	Unauthorized                = 401
+	PaymentRequired             = 402
+	Forbidden                   = 403
+	NotFound                    = 404
+	MethodNotAllowed            = 405
+	NotAcceptable               = 406
+	ProxyAuthRequired           = 407
+	RequestTimeout              = 408
+	Conflict                    = 409
+	Gone                        = 410
+	LengthRequired              = 411
+	PreconditionFailed          = 412
+	ContentTooLarge             = 413
+	URITooLong                  = 414
+	UnsupportedMediaType        = 415
+	RangeNotSatisfiable         = 416
+	ExpectationFailed           = 417
+	IamATeaPot                  = 418
+	MisdirectedRequest          = 421
+	UnprocessableContent        = 422
+	Locked                      = 423
+	FailedDependency            = 424
+	TooEarly                    = 425
+	UpgradeRequired             = 426
+	PreconditionRequired        = 428
+	TooManyRequests             = 429
+	RequestHeaderFieldsTooLarge = 431
+	UnavailableForLegalReasons  = 451
+	InternalServerError         = 500
+	NotImplemented              = 501
+	BadGateway                  = 502
+	ServiceUnavailable          = 503
+	GatewayTimeout              = 504
+	HTTPVersionNotSupported     = 505
+	VariantAlsoNegotiates       = 506
+	InsufficientStorage         = 507
+	LoopDetected                = 508
+	NotExtended                 = 510
+	NetworkAuthRequired         = 511
+
+	// StatusRequestTimeout is the original name of RequestTimeout.
+	StatusRequestTimeout = RequestTimeout
 )
 
 var statusText = map[int]string{
-	OK:                   "OK",
-	Created:              "Created",
-	Accepted:             "Accepted",
-	NoContent:            "No Content",
-	MovedPermanently:     "Moved Permanently",
-	Found:                "Found",
-	BadRequest:           "Bad Request",
-	Unauthorized:         "Unauthorized",
-	Forbidden:            "Forbidden",
-	NotFound:             "Not Found",
-	MethodNotAllowed:     "Method Not Allowed",
-	IamATeaPot:           "I'm a teapot",
-	InternalServerError:  "Internal Server Error",
-	NotImplemented:       "Not Implemented",
-	BadGateway:           "Bad Gateway",
-	ServiceUnavailable:   "Service Unavailable",
-	StatusRequestTimeout: "Request Timeout",
+	Continue:                    "Continue",
+	SwitchingProtocols:          "Switching Protocols",
+	Processing:                  "Processing",
+	EarlyHints:                  "Early Hints",
+	UploadResumptionSupported:   "Upload Resumption Supported",
+	OK:                          "OK",
+	Created:                     "Created",
+	Accepted:                    "Accepted",
+	NonAuthoritativeInfo:        "Non-Authoritative Information",
+	NoContent:                   "No Content",
+	ResetContent:                "Reset Content",
+	PartialContent:              "Partial Content",
+	MultiStatus:                 "Multi-Status",
+	AlreadyReported:             "Already Reported",
+	IMUsed:                      "IM Used",
+	MultipleChoices:             "Multiple Choices",
+	MovedPermanently:            "Moved Permanently",
+	Found:                       "Found",
+	SeeOther:                    "See Other",
+	NotModified:                 "Not Modified",
+	UseProxy:                    "Use Proxy",
+	TemporaryRedirect:           "Temporary Redirect",
+	PermanentRedirect:           "Permanent Redirect",
+	BadRequest:                  "Bad Request",
+	Unauthorized:                "Unauthorized",
+	PaymentRequired:             "Payment Required",
+	Forbidden:                   "Forbidden",
+	NotFound:                    "Not Found",
+	MethodNotAllowed:            "Method Not Allowed",
+	NotAcceptable:               "Not Acceptable",
+	ProxyAuthRequired:           "Proxy Authentication Required",
+	RequestTimeout:              "Request Timeout",
+	Conflict:                    "Conflict",
+	Gone:                        "Gone",
+	LengthRequired:              "Length Required",
+	PreconditionFailed:          "Precondition Failed",
+	ContentTooLarge:             "Content Too Large",
+	URITooLong:                  "URI Too Long",
+	UnsupportedMediaType:        "Unsupported Media Type",
+	RangeNotSatisfiable:         "Range Not Satisfiable",
+	ExpectationFailed:           "Expectation Failed",
+	IamATeaPot:                  "I'm a teapot",
+	MisdirectedRequest:          "Misdirected Request",
+	UnprocessableContent:        "Unprocessable Content",
+	Locked:                      "Locked",
+	FailedDependency:            "Failed Dependency",
+	TooEarly:                    "Too Early",
+	UpgradeRequired:             "Upgrade Required",
+	PreconditionRequired:        "Precondition Required",
+	TooManyRequests:             "Too Many Requests",
+	RequestHeaderFieldsTooLarge: "Request Header Fields Too Large",
+	UnavailableForLegalReasons:  "Unavailable For Legal Reasons",
+	InternalServerError:         "Internal Server Error",
+	NotImplemented:              "Not Implemented",
+	BadGateway:                  "Bad Gateway",
+	ServiceUnavailable:          "Service Unavailable",
+	GatewayTimeout:              "Gateway Timeout",
+	HTTPVersionNotSupported:     "HTTP Version Not Supported",
+	VariantAlsoNegotiates:       "Variant Also Negotiates",
+	InsufficientStorage:         "Insufficient Storage",
+	LoopDetected:                "Loop Detected",
+	NotExtended:                 "Not Extended",
+	NetworkAuthRequired:         "Network Authentication Required",
 }
 
+// Text returns the reason phrase for a status code. Codes outside the
+// registry get a generic phrase for their class, so a status line always
+// has one.
 func Text(code int) string {
-	return statusText[code]
+	if text, ok := statusText[code]; ok {
+		return text
+	}
+	switch {
+	case IsInformational(code):
+		return "Informational"
+	case IsSuccess(code):
+		return "Success"
+	case IsRedirect(code):
+		return "Redirection"
+	case IsClientError(code):
+		return "Client Error"
+	case IsServerError(code):
+		return "Server Error"
+	}
+	return "Unknown Status"
+}
+
+// IsRegistered reports whether code is in the IANA registry.
+func IsRegistered(code int) bool {
+	_, ok := statusText[code]
+	return ok
+}
+
+func IsInformational(code int) bool {
+	return code >= 100 && code <= 199
+}
+
+func IsSuccess(code int) bool {
+	return code >= 200 && code <= 299
+}
+
+func IsRedirect(code int) bool {
+	return code >= 300 && code <= 399
+}
+
+func IsClientError(code int) bool {
+	return code >= 400 && code <= 499
+}
+
+func IsServerError(code int) bool {
+	return code >= 500 && code <= 599
+}
+
+// IsError reports whether code is a client or server error.
+func IsError(code int) bool {
+	return code >= 400 && code <= 599
 }
@@ -0,0 +1,162 @@
+package http
+
+import (
+	"net"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/appyzdl/Netrunner/pkg/trace"
+)
+
+// traceRequest handles req in a server span that continues the trace from
+// the request's traceparent header. Attributes follow the OpenTelemetry
+// HTTP semantic conventions. For streamed responses the span ends when the
+// handler returns, not when the body has been sent.
+func traceRequest(tracer *trace.Tracer, req *Request, handle HandlerFunc) *Response {
+	ctx := req.Context()
+	if sc, err := trace.ParseTraceparent(req.Headers["Traceparent"]); err == nil {
+		sc.TraceState = trace.ParseTraceState(req.Headers["Tracestate"])
+		ctx = trace.ContextWithRemoteSpanContext(ctx, sc)
+	}
+	ctx, span := tracer.Start(ctx, req.Method, trace.WithKind(trace.KindServer))
+	defer span.End()
+	if span.IsRecording() {
+		span.SetAttributes(serverSpanAttrs(req)...)
+	}
+	sc := span.SpanContext()
+	req.SetContext(ctx)
+	req.SetLogger(req.Logger().With("trace_id", sc.TraceID.String(), "span_id", sc.SpanID.String()))
+
+	resp := handle(req)
+
+	code := 500
+	if resp != nil {
+		code = resp.StatusCode
+	}
+	if req.Route != "" {
+		span.SetName(req.Method + " " + req.Route)
+		span.SetAttributes(trace.String("http.route", req.Route))
+	}
+	span.SetAttributes(trace.Int("http.response.status_code", code))
+	if code >= 500 {
+		span.SetStatus(trace.StatusError, "")
+	}
+	return resp
+}
+
+func serverSpanAttrs(req *Request) []trace.Attribute {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	attrs := []trace.Attribute{
+		trace.String("http.request.method", req.Method),
+		trace.String("url.path", requestPath(req)),
+		trace.String("url.scheme", scheme),
+		trace.String("network.protocol.version", strings.TrimPrefix(strings.TrimSuffix(req.Version, ".0"), "HTTP/")),
+	}
+	if _, query, ok := strings.Cut(req.Path, "?"); ok {
+		attrs = append(attrs, trace.String("url.query", query))
+	}
+	if host := req.Headers["Host"]; host != "" {
+		attrs = append(attrs, hostAttrs("server", host)...)
+	}
+	if client := clientHost(req); client != "" {
+		attrs = append(attrs, trace.String("client.address", client))
+	}
+	if ua := req.Headers["User-Agent"]; ua != "" {
+		attrs = append(attrs, trace.String("user_agent.original", ua))
+	}
+	return attrs
+}
+
+// hostAttrs splits host into prefix.address and prefix.port attributes.
+func hostAttrs(prefix, host string) []trace.Attribute {
+	name, portText, err := net.SplitHostPort(host)
+	if err != nil {
+		return []trace.Attribute{trace.String(prefix+".address", host)}
+	}
+	attrs := []trace.Attribute{trace.String(prefix+".address", name)}
+	if port, err := strconv.Atoi(portText); err == nil {
+		attrs = append(attrs, trace.Int(prefix+".port", port))
+	}
+	return attrs
+}
+
+// traceStep runs next in a child span of the request's current span, used
+// for each Router middleware.
+func traceStep(tracer *trace.Tracer, name string, next HandlerFunc) HandlerFunc {
+	return func(req *Request) *Response {
+		parent := trace.SpanFromContext(req.Context())
+		ctx, span := tracer.Start(req.Context(), name)
+		req.SetContext(ctx)
+		defer func() {
+			span.End()
+			// Keep values added by the middleware but make the parent the
+			// current span again.
+			req.SetContext(trace.ContextWithSpan(req.Context(), parent))
+		}()
+		return next(req)
+	}
+}
+
+// middlewareName names a middleware after its function, such as
+// "http.LoggingMiddleware" or "http.(*AccessLog).Middleware".
+func middlewareName(mw MiddlewareFunc) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(mw).Pointer())
+	if fn == nil {
+		return "middleware"
+	}
+	name := fn.Name()
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(name, "-fm")
+}
+
+// startClientSpan starts a client span for an outgoing request made while
+// handling a traced one and sets the request's traceparent and tracestate
+// headers. It returns nil if the request's context holds no span.
+func startClientSpan(req *Request) *trace.Span {
+	parent := trace.SpanFromContext(req.Context())
+	if parent == nil {
+		return nil
+	}
+	ctx, span := parent.Tracer().Start(req.Context(), req.Method, trace.WithKind(trace.KindClient))
+	req.SetContext(ctx)
+	if span.IsRecording() && req.URL != nil {
+		u := *req.URL
+		u.User = nil
+		span.SetAttributes(trace.String("http.request.method", req.Method), trace.String("url.full", u.String()))
+		span.SetAttributes(hostAttrs("server", req.URL.Host)...)
+	}
+
+	sc := span.SpanContext()
+	req.Headers["Traceparent"] = sc.Traceparent()
+	if sc.TraceState != "" {
+		req.Headers["Tracestate"] = sc.TraceState
+	} else {
+		delete(req.Headers, "Tracestate")
+	}
+	return span
+}
+
+// endClientSpan records the outcome of an outgoing request. The span ends
+// when the response head has arrived.
+func endClientSpan(span *trace.Span, resp *Response, err error) {
+	if span == nil {
+		return
+	}
+	switch {
+	case err != nil:
+		span.RecordError(err)
+	case resp.StatusCode >= 400:
+		span.SetAttributes(trace.Int("http.response.status_code", resp.StatusCode))
+		span.SetStatus(trace.StatusError, "")
+	default:
+		span.SetAttributes(trace.Int("http.response.status_code", resp.StatusCode))
+	}
+	span.End()
+}
@@ -0,0 +1,496 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http/status"
+)
+
+// BalanceStrategy decides which upstream in a pool receives a request.
+type BalanceStrategy int
+
+const (
+	RoundRobin BalanceStrategy = iota
+	WeightedRoundRobin
+	LeastConnections
+	ConsistentHash
+)
+
+// ErrNoHealthyUpstream is returned when every upstream in a pool is down.
+var ErrNoHealthyUpstream = errors.New("no healthy upstream")
+
+// hashReplicas is the number of points each unit of weight gets on the
+// consistent hash ring.
+const hashReplicas = 100
+
+// Upstream is one backend server in an UpstreamPool.
+type Upstream struct {
+	URL    *url.URL
+	Weight int
+
+	pool *UpstreamPool
+
+	// The fields below are guarded by the pool's mutex.
+	healthy       bool
+	active        int
+	failures      int
+	successes     int
+	ejectedUntil  time.Time
+	recoveredAt   time.Time
+	currentWeight float64
+}
+
+// Healthy reports whether the upstream is currently receiving traffic.
+func (u *Upstream) Healthy() bool {
+	u.pool.mu.Lock()
+	defer u.pool.mu.Unlock()
+	return u.healthy
+}
+
+// HealthCheck configures active health checking of the upstreams.
+type HealthCheck struct {
+	Path     string
+	Interval time.Duration
+	Timeout  time.Duration
+
+	// An upstream is marked down after UnhealthyThreshold consecutive failed
+	// checks and up again after HealthyThreshold consecutive passing ones.
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+// UpstreamPool load-balances proxied requests across a group of upstream
+// servers. It is a HandlerFunc via HandleRequest.
+//
+// Upstreams that fail MaxFails requests in a row are ejected for
+// EjectDuration (passive health checking); HealthCheck probes them in the
+// background (active health checking). An upstream coming back is eased in
+// over SlowStart by ramping its effective weight up from a small fraction.
+type UpstreamPool struct {
+	Strategy  BalanceStrategy
+	Transport *Transport
+
+	// HashKey picks the key for ConsistentHash. It defaults to the client IP.
+	HashKey func(*Request) string
+
+	HealthCheck   *HealthCheck
+	MaxFails      int
+	EjectDuration time.Duration
+	SlowStart     time.Duration
+
+	mu        sync.Mutex
+	upstreams []*Upstream
+	ring      []ringPoint
+	stop      chan struct{}
+	now       func() time.Time
+}
+
+type ringPoint struct {
+	hash     uint32
+	upstream *Upstream
+}
+
+func NewUpstreamPool(strategy BalanceStrategy) *UpstreamPool {
+	return &UpstreamPool{
+		Strategy:      strategy,
+		MaxFails:      3,
+		EjectDuration: 30 * time.Second,
+		now:           time.Now,
+	}
+}
+
+// AddUpstream adds a backend with the given weight (values below 1 count
+// as 1).
+func (p *UpstreamPool) AddUpstream(rawURL string, weight int) (*Upstream, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream URL: %s", rawURL)
+	}
+	if weight < 1 {
+		weight = 1
+	}
+
+	upstream := &Upstream{URL: u, Weight: weight, pool: p, healthy: true}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.upstreams = append(p.upstreams, upstream)
+	p.rebuildRing()
+	return upstream, nil
+}
+
+// Upstreams returns the backends in the order they were added.
+func (p *UpstreamPool) Upstreams() []*Upstream {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*Upstream(nil), p.upstreams...)
+}
+
+// HandleRequest proxies the request to an upstream chosen by the pool's
+// strategy. If the upstream fails the request is retried on the next one,
+// as long as sending it twice is harmless: its body can be replayed and
+// either its method is idempotent or none of it reached the upstream.
+func (p *UpstreamPool) HandleRequest(req *Request) *Response {
+	tried := make(map[*Upstream]bool)
+	var lastErr error
+
+	for {
+		upstream := p.pick(req, tried)
+		if upstream == nil {
+			break
+		}
+		tried[upstream] = true
+
+		proxy := &ReverseProxy{Target: upstream.URL, Transport: p.Transport}
+		resp, err := proxy.roundTrip(req)
+		if err != nil {
+			p.release(upstream, false)
+			lastErr = err
+			req.Logger().Warn("Upstream failed", "upstream", upstream.URL.Host, "error", err)
+			if req.BodyReader != nil || !(isIdempotent(req.Method) || errors.Is(err, errNothingWritten)) {
+				return proxyErrorResponse(err)
+			}
+			continue
+		}
+
+		ok := resp.StatusCode != status.BadGateway &&
+			resp.StatusCode != status.ServiceUnavailable &&
+			resp.StatusCode != status.GatewayTimeout
+		if resp.BodyReader == nil {
+			p.release(upstream, ok)
+		} else {
+			// The upstream stays busy until its response has been streamed.
+			resp.BodyReader = &releaseOnClose{
+				reader:  resp.BodyReader,
+				release: func() { p.release(upstream, ok) },
+			}
+		}
+		return resp
+	}
+
+	if lastErr == nil {
+		req.Logger().Error(ErrNoHealthyUpstream.Error())
+		return proxyStatusResponse(status.ServiceUnavailable)
+	}
+	return proxyErrorResponse(lastErr)
+}
+
+// StartHealthChecks probes every upstream on HealthCheck.Interval until
+// Close is called.
+func (p *UpstreamPool) StartHealthChecks() {
+	if p.HealthCheck == nil {
+		return
+	}
+	p.mu.Lock()
+	if p.stop != nil {
+		p.mu.Unlock()
+		return
+	}
+	p.stop = make(chan struct{})
+	stop := p.stop
+	p.mu.Unlock()
+
+	interval := p.HealthCheck.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			p.checkAll()
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the background health checks.
+func (p *UpstreamPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
+
+func (p *UpstreamPool) checkAll() {
+	var wg sync.WaitGroup
+	for _, upstream := range p.Upstreams() {
+		wg.Add(1)
+		go func(u *Upstream) {
+			defer wg.Done()
+			p.recordCheck(u, p.probe(u))
+		}(upstream)
+	}
+	wg.Wait()
+}
+
+func (p *UpstreamPool) probe(u *Upstream) bool {
+	timeout := p.HealthCheck.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	transport := &Transport{DialTimeout: timeout, ResponseHeaderTimeout: timeout}
+
+	target := *u.URL
+	target.Path = joinURLPath(u.URL.Path, p.HealthCheck.Path)
+	req := NewRequest()
+	req.Method = "GET"
+	req.URL = &target
+	req.Path = target.RequestURI()
+	req.Headers["Connection"] = "close"
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return false
+	}
+	resp.ReadBody()
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
+
+func (p *UpstreamPool) recordCheck(u *Upstream, passed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	healthyThreshold := max(p.HealthCheck.HealthyThreshold, 1)
+	unhealthyThreshold := max(p.HealthCheck.UnhealthyThreshold, 1)
+
+	if passed {
+		u.successes++
+		u.failures = 0
+		if !u.healthy && u.successes >= healthyThreshold {
+			p.markHealthy(u)
+		}
+		return
+	}
+	u.successes = 0
+	u.failures++
+	if u.healthy && u.failures >= unhealthyThreshold {
+		p.markUnhealthy(u, time.Time{})
+	}
+}
+
+// release records the outcome of a proxied request.
+func (p *UpstreamPool) release(u *Upstream, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	u.active--
+	if ok {
+		u.failures = 0
+		return
+	}
+	u.failures++
+	if u.healthy && p.MaxFails > 0 && u.failures >= p.MaxFails {
+		p.markUnhealthy(u, p.now().Add(p.EjectDuration))
+	}
+}
+
+// markUnhealthy takes an upstream out of rotation. A non-zero until means
+// it was ejected passively and is readmitted automatically at that time.
+func (p *UpstreamPool) markUnhealthy(u *Upstream, until time.Time) {
+	slog.Warn("Upstream marked unhealthy", "upstream", u.URL.Host)
+	u.healthy = false
+	u.successes = 0
+	u.ejectedUntil = until
+	p.rebuildRing()
+}
+
+func (p *UpstreamPool) markHealthy(u *Upstream) {
+	slog.Info("Upstream marked healthy", "upstream", u.URL.Host)
+	u.healthy = true
+	u.failures = 0
+	u.ejectedUntil = time.Time{}
+	u.recoveredAt = p.now()
+	u.currentWeight = 0
+	p.rebuildRing()
+}
+
+// pick chooses an upstream not in skip and counts the request against it.
+func (p *UpstreamPool) pick(req *Request, skip map[*Upstream]bool) *Upstream {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	var candidates []*Upstream
+	for _, u := range p.upstreams {
+		if !u.healthy && !u.ejectedUntil.IsZero() && !now.Before(u.ejectedUntil) {
+			p.markHealthy(u)
+		}
+		if u.healthy && !skip[u] {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var chosen *Upstream
+	switch p.Strategy {
+	case LeastConnections:
+		chosen = p.leastConnections(candidates, now)
+	case ConsistentHash:
+		chosen = p.consistentHash(req, skip)
+	default:
+		chosen = p.smoothWeighted(candidates, now)
+	}
+	if chosen == nil {
+		chosen = candidates[0]
+	}
+	chosen.active++
+	return chosen
+}
+
+// effectiveWeight is the upstream's weight, scaled down while it is within
+// SlowStart of recovering.
+func (p *UpstreamPool) effectiveWeight(u *Upstream, now time.Time) float64 {
+	weight := float64(u.Weight)
+	if p.Strategy == RoundRobin {
+		weight = 1
+	}
+	if p.SlowStart > 0 && !u.recoveredAt.IsZero() {
+		if elapsed := now.Sub(u.recoveredAt); elapsed < p.SlowStart {
+			weight *= max(float64(elapsed)/float64(p.SlowStart), 0.1)
+		}
+	}
+	return weight
+}
+
+// smoothWeighted implements nginx's smooth weighted round-robin, which
+// spreads picks of heavier upstreams evenly instead of in bursts. With
+// equal weights it is plain round-robin.
+func (p *UpstreamPool) smoothWeighted(candidates []*Upstream, now time.Time) *Upstream {
+	var best *Upstream
+	total := 0.0
+	for _, u := range candidates {
+		weight := p.effectiveWeight(u, now)
+		u.currentWeight += weight
+		total += weight
+		if best == nil || u.currentWeight > best.currentWeight {
+			best = u
+		}
+	}
+	best.currentWeight -= total
+	return best
+}
+
+func (p *UpstreamPool) leastConnections(candidates []*Upstream, now time.Time) *Upstream {
+	var best *Upstream
+	bestScore := 0.0
+	for _, u := range candidates {
+		score := float64(u.active+1) / p.effectiveWeight(u, now)
+		if best == nil || score < bestScore {
+			best, bestScore = u, score
+		}
+	}
+	return best
+}
+
+func (p *UpstreamPool) consistentHash(req *Request, skip map[*Upstream]bool) *Upstream {
+	if len(p.ring) == 0 {
+		return nil
+	}
+	keyFunc := p.HashKey
+	if keyFunc == nil {
+		keyFunc = HashByClientIP
+	}
+	hash := crc32.ChecksumIEEE([]byte(keyFunc(req)))
+
+	start := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= hash })
+	for i := 0; i < len(p.ring); i++ {
+		point := p.ring[(start+i)%len(p.ring)]
+		if !skip[point.upstream] {
+			return point.upstream
+		}
+	}
+	return nil
+}
+
+// rebuildRing recomputes the consistent hash ring from the healthy
+// upstreams. Keys of a removed upstream move to its ring neighbours while
+// every other key stays where it was.
+func (p *UpstreamPool) rebuildRing() {
+	p.ring = p.ring[:0]
+	for _, u := range p.upstreams {
+		if !u.healthy {
+			continue
+		}
+		for i := 0; i < hashReplicas*u.Weight; i++ {
+			hash := crc32.ChecksumIEEE([]byte(u.URL.Host + "#" + strconv.Itoa(i)))
+			p.ring = append(p.ring, ringPoint{hash: hash, upstream: u})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+}
+
+// HashByHeader keys consistent hashing on a request header.
+func HashByHeader(name string) func(*Request) string {
+	return func(req *Request) string {
+		return req.Headers[name]
+	}
+}
+
+// HashByCookie keys consistent hashing on a cookie value.
+func HashByCookie(name string) func(*Request) string {
+	return func(req *Request) string {
+		if c, err := req.Cookie(name); err == nil {
+			return c.Value
+		}
+		return ""
+	}
+}
+
+// HashByClientIP keys consistent hashing on the client's IP address: the
+// one resolved from forwarding headers sent by trusted proxies, or else the
+// connection's peer.
+func HashByClientIP(req *Request) string {
+	if req.ClientIP != "" {
+		return req.ClientIP
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// releaseOnClose calls release once the body has been read to the end or
+// closed.
+type releaseOnClose struct {
+	reader  io.Reader
+	release func()
+	once    sync.Once
+}
+
+func (r *releaseOnClose) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil {
+		r.once.Do(r.release)
+	}
+	return n, err
+}
+
+func (r *releaseOnClose) Close() error {
+	r.once.Do(r.release)
+	if closer, ok := r.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
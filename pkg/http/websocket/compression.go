@@ -0,0 +1,78 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+)
+
+// permessage-deflate (RFC 7692) is negotiated without context takeover, so
+// every message is compressed independently and no per-connection
+// dictionary has to be kept.
+const deflateResponse = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+
+// deflateTail is the empty stored block that RFC 7692 strips from the end of
+// each compressed message, followed by a final empty block so the
+// decompressor sees a complete stream.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := fw.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail[:4]), nil
+}
+
+// decompress inflates a message, failing with errMessageTooBig as soon as
+// the result exceeds limit.
+func decompress(data []byte, limit int64) ([]byte, error) {
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader(deflateTail)))
+	defer fr.Close()
+
+	out, err := io.ReadAll(io.LimitReader(fr, limit+1))
+	if err != nil {
+		return nil, errBadFrame
+	}
+	if int64(len(out)) > limit {
+		return nil, errMessageTooBig
+	}
+	return out, nil
+}
+
+// acceptDeflate reports whether one of the client's extension offers is a
+// permessage-deflate configuration this implementation can honour.
+func acceptDeflate(header string) bool {
+	for _, offer := range strings.Split(header, ",") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		ok := true
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			switch name {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits":
+				// compress/flate always uses a 32KB window.
+				if strings.Trim(value, `"`) != "15" {
+					ok = false
+				}
+			default:
+				ok = false
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,560 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+	CloseMessage  MessageType = 8
+	PingMessage   MessageType = 9
+	PongMessage   MessageType = 10
+)
+
+const continuationFrame = 0
+
+// Close codes from RFC 6455 section 7.4.1.
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseInternalServerErr       = 1011
+)
+
+const (
+	maxControlPayload = 125
+	closeTimeout      = 5 * time.Second
+)
+
+// DefaultMaxMessageSize is the limit on incoming messages, after
+// decompression, when none is set.
+const DefaultMaxMessageSize = 32 << 20
+
+// CloseError is returned by ReadMessage once the peer has closed the
+// connection.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+var (
+	ErrCloseSent      = errors.New("websocket: close sent")
+	errConcurrentRead = errors.New("websocket: concurrent read")
+	errBadFrame       = errors.New("websocket: protocol error")
+	errMessageTooBig  = errors.New("websocket: message too big")
+	errInvalidPayload = errors.New("websocket: invalid UTF-8 in text message")
+)
+
+// Conn is a WebSocket connection. One goroutine may read and any number
+// may write concurrently.
+type Conn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	isServer bool
+
+	subprotocol    string
+	compress       bool
+	maxMessageSize int64
+
+	// writeMu serializes frames; messageMu keeps the frames of a fragmented
+	// message together (control frames may still be interleaved).
+	writeMu   sync.Mutex
+	messageMu sync.Mutex
+	closeSent bool
+
+	pingHandler func(data []byte) error
+	pongHandler func(data []byte) error
+
+	// reading is set while a ReadMessage call, or Close draining the
+	// connection, owns the reader. readFailed is closed once a read has
+	// failed, including on the peer's close frame.
+	reading        atomic.Bool
+	readFailed     chan struct{}
+	readFailedOnce sync.Once
+	closeReceived  atomic.Bool
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, isServer bool) *Conn {
+	c := &Conn{
+		conn:           conn,
+		reader:         reader,
+		isServer:       isServer,
+		maxMessageSize: DefaultMaxMessageSize,
+		readFailed:     make(chan struct{}),
+	}
+	c.pingHandler = func(data []byte) error {
+		err := c.WriteControl(PongMessage, data)
+		if err == ErrCloseSent {
+			return nil
+		}
+		return err
+	}
+	return c
+}
+
+// Subprotocol returns the negotiated subprotocol, if any.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// RemoteAddr returns the peer's network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetMaxMessageSize limits the size of messages read, after
+// decompression; 0 means DefaultMaxMessageSize. Larger messages close the
+// connection with CloseMessageTooBig.
+func (c *Conn) SetMaxMessageSize(limit int64) {
+	if limit <= 0 {
+		limit = DefaultMaxMessageSize
+	}
+	c.maxMessageSize = limit
+}
+
+// SetReadDeadline sets the deadline for the next ReadMessage.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetPingHandler replaces the default handler, which answers with a pong.
+func (c *Conn) SetPingHandler(h func(data []byte) error) {
+	c.pingHandler = h
+}
+
+// SetPongHandler sets a handler for pongs, e.g. to extend a read deadline.
+func (c *Conn) SetPongHandler(h func(data []byte) error) {
+	c.pongHandler = h
+}
+
+// ReadMessage reads the next complete data message, reassembling
+// fragments and answering control frames as they arrive.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if !c.reading.CompareAndSwap(false, true) {
+		return 0, nil, errConcurrentRead
+	}
+	defer c.reading.Store(false)
+
+	messageType, payload, err := c.readMessage()
+	if err != nil {
+		c.readFailedOnce.Do(func() { close(c.readFailed) })
+	}
+	return messageType, payload, err
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	var (
+		messageType MessageType
+		compressed  bool
+		payload     []byte
+	)
+
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return 0, nil, c.failRead(err)
+		}
+
+		switch f.opcode {
+		case PingMessage:
+			if c.pingHandler != nil {
+				if err := c.pingHandler(f.payload); err != nil {
+					return 0, nil, err
+				}
+			}
+			continue
+		case PongMessage:
+			if c.pongHandler != nil {
+				if err := c.pongHandler(f.payload); err != nil {
+					return 0, nil, err
+				}
+			}
+			continue
+		case CloseMessage:
+			return 0, nil, c.handleClose(f.payload)
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.failRead(errBadFrame)
+			}
+			messageType = f.opcode
+			compressed = f.rsv1
+		case continuationFrame:
+			if messageType == 0 {
+				return 0, nil, c.failRead(errBadFrame)
+			}
+		}
+
+		if int64(len(payload)+len(f.payload)) > c.maxMessageSize {
+			return 0, nil, c.failRead(errMessageTooBig)
+		}
+		payload = append(payload, f.payload...)
+		if !f.fin {
+			continue
+		}
+
+		if compressed {
+			if payload, err = decompress(payload, c.maxMessageSize); err != nil {
+				return 0, nil, c.failRead(err)
+			}
+		}
+		if messageType == TextMessage && !utf8.Valid(payload) {
+			return 0, nil, c.failRead(errInvalidPayload)
+		}
+		return messageType, payload, nil
+	}
+}
+
+// WriteMessage sends a complete text or binary message in a single frame.
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return c.WriteControl(messageType, data)
+	}
+	w, err := c.NextWriter(messageType)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// NextWriter starts a fragmented message: each Write is sent as its own
+// frame and Close sends the final one. Other data messages wait until the
+// writer is closed.
+func (c *Conn) NextWriter(messageType MessageType) (io.WriteCloser, error) {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return nil, fmt.Errorf("websocket: invalid data message type %d", messageType)
+	}
+	c.messageMu.Lock()
+	return &messageWriter{conn: c, opcode: messageType}, nil
+}
+
+// WriteControl sends a ping, pong or close frame.
+func (c *Conn) WriteControl(messageType MessageType, data []byte) error {
+	if messageType != PingMessage && messageType != PongMessage && messageType != CloseMessage {
+		return fmt.Errorf("websocket: invalid control message type %d", messageType)
+	}
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("websocket: control frame payload too large")
+	}
+	return c.writeFrame(true, false, messageType, data)
+}
+
+// Ping sends a ping frame; the peer's pong is passed to the pong handler.
+func (c *Conn) Ping(data []byte) error {
+	return c.WriteControl(PingMessage, data)
+}
+
+// Close performs the closing handshake with CloseNormalClosure and closes
+// the underlying connection.
+func (c *Conn) Close() error {
+	return c.CloseWithCode(CloseNormalClosure, "")
+}
+
+// CloseWithCode sends a close frame with the given code and reason, waits
+// briefly for the peer's close frame, then closes the connection. If
+// another goroutine is in ReadMessage, that call receives the peer's close
+// frame instead.
+func (c *Conn) CloseWithCode(code int, reason string) error {
+	err := c.WriteControl(CloseMessage, closePayload(code, reason))
+	if err == nil && !c.closeReceived.Load() {
+		c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+		if c.reading.CompareAndSwap(false, true) {
+			// Drain until the peer answers with its own close frame.
+			for {
+				if _, _, rerr := c.readMessage(); rerr != nil {
+					break
+				}
+			}
+			c.reading.Store(false)
+		} else {
+			select {
+			case <-c.readFailed:
+			case <-time.After(closeTimeout):
+			}
+		}
+	}
+	if cerr := c.conn.Close(); err == nil || err == ErrCloseSent {
+		err = cerr
+	}
+	return err
+}
+
+// handleClose answers a close frame and reports it as a CloseError.
+func (c *Conn) handleClose(payload []byte) error {
+	c.closeReceived.Store(true)
+
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		return c.failRead(errBadFrame)
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Text = string(payload[2:])
+		if !validCloseCode(closeErr.Code) || !utf8.ValidString(closeErr.Text) {
+			return c.failRead(errBadFrame)
+		}
+	}
+
+	echo := closePayload(closeErr.Code, "")
+	if closeErr.Code == CloseNoStatusReceived {
+		echo = nil
+	}
+	c.WriteControl(CloseMessage, echo)
+	return closeErr
+}
+
+// failRead closes the connection with the code matching a read error.
+func (c *Conn) failRead(err error) error {
+	code := CloseProtocolError
+	switch err {
+	case errMessageTooBig:
+		code = CloseMessageTooBig
+	case errInvalidPayload:
+		code = CloseInvalidFramePayloadData
+	case errBadFrame:
+	default:
+		if _, ok := err.(*CloseError); ok {
+			return err
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+	}
+	c.WriteControl(CloseMessage, closePayload(code, err.Error()))
+	c.closeReceived.Store(true)
+	return err
+}
+
+type frame struct {
+	fin     bool
+	rsv1    bool
+	opcode  MessageType
+	payload []byte
+}
+
+func (c *Conn) readFrame() (*frame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return nil, err
+	}
+
+	f := &frame{
+		fin:    header[0]&0x80 != 0,
+		rsv1:   header[0]&0x40 != 0,
+		opcode: MessageType(header[0] & 0x0f),
+	}
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7f)
+
+	if header[0]&0x30 != 0 || (f.rsv1 && (!c.compress || f.opcode == continuationFrame || f.opcode >= CloseMessage)) {
+		return nil, errBadFrame
+	}
+	switch f.opcode {
+	case continuationFrame, TextMessage, BinaryMessage:
+	case CloseMessage, PingMessage, PongMessage:
+		if !f.fin || length > maxControlPayload {
+			return nil, errBadFrame
+		}
+	default:
+		return nil, errBadFrame
+	}
+	// Clients must mask every frame and servers must not (section 5.1).
+	if masked != c.isServer {
+		return nil, errBadFrame
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+		if length < 0 {
+			return nil, errBadFrame
+		}
+	}
+	if length > c.maxMessageSize {
+		return nil, errMessageTooBig
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Grow the buffer as the payload arrives rather than trusting the
+	// length in the header.
+	payload, err := io.ReadAll(io.LimitReader(c.reader, length))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(payload)) < length {
+		return nil, io.ErrUnexpectedEOF
+	}
+	f.payload = payload
+	if masked {
+		maskBytes(mask, f.payload)
+	}
+	return f, nil
+}
+
+func (c *Conn) writeFrame(fin, rsv1 bool, opcode MessageType, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+
+	header := make([]byte, 2, 14)
+	header[0] = byte(opcode)
+	if fin {
+		header[0] |= 0x80
+	}
+	if rsv1 {
+		header[0] |= 0x40
+	}
+
+	length := len(payload)
+	switch {
+	case length <= 125:
+		header[1] = byte(length)
+	case length <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	if !c.isServer {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		header[1] |= 0x80
+		header = append(header, mask[:]...)
+		masked := make([]byte, length)
+		copy(masked, payload)
+		maskBytes(mask, masked)
+		payload = masked
+	}
+
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// messageWriter sends each Write as a fragment of one message.
+type messageWriter struct {
+	conn    *Conn
+	opcode  MessageType
+	started bool
+	pending []byte
+	closed  bool
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("websocket: write to closed message writer")
+	}
+	if w.conn.compress {
+		// Compressed messages are deflated as a whole on Close.
+		w.pending = append(w.pending, p...)
+		return len(p), nil
+	}
+	if err := w.flushFrame(false, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.conn.messageMu.Unlock()
+
+	if w.conn.compress {
+		compressed, err := compress(w.pending)
+		if err != nil {
+			return err
+		}
+		return w.conn.writeFrame(true, true, w.opcode, compressed)
+	}
+	return w.flushFrame(true, nil)
+}
+
+func (w *messageWriter) flushFrame(fin bool, p []byte) error {
+	opcode := MessageType(continuationFrame)
+	if !w.started {
+		opcode = w.opcode
+		w.started = true
+	}
+	return w.conn.writeFrame(fin, false, opcode, p)
+}
+
+func maskBytes(mask [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= mask[i%4]
+	}
+}
+
+func closePayload(code int, reason string) []byte {
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return append(payload, reason...)
+}
+
+// validCloseCode reports whether a peer may send the code (section 7.4).
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
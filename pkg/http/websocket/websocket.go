@@ -0,0 +1,271 @@
+// Package websocket implements the WebSocket protocol (RFC 6455) on top of
+// Netrunner's HTTP server, including permessage-deflate (RFC 7692).
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+	"github.com/appyzdl/Netrunner/pkg/http/status"
+)
+
+// acceptGUID is appended to Sec-WebSocket-Key to compute the accept value.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Upgrader turns an HTTP request into a WebSocket connection.
+type Upgrader struct {
+	// Subprotocols lists the subprotocols the server supports, in order of
+	// preference.
+	Subprotocols []string
+
+	// CheckOrigin decides whether to accept the request's Origin. By default
+	// cross-origin requests are rejected.
+	CheckOrigin func(req *http.Request) bool
+
+	// MaxMessageSize limits incoming messages, after decompression; 0
+	// means DefaultMaxMessageSize.
+	MaxMessageSize int64
+
+	// EnableCompression negotiates permessage-deflate if the client offers it.
+	EnableCompression bool
+}
+
+// Upgrade validates the handshake and returns the response for the route
+// handler to return. On success the response is a 101 that hands the
+// connection to handler once written; the connection is closed when
+// handler returns. On failure it is an error response and handler is never
+// called.
+func (u *Upgrader) Upgrade(req *http.Request, handler func(*Conn)) *http.Response {
+	if req.Method != "GET" || req.Version != "HTTP/1.1" {
+		return errorResponse(status.BadRequest, "websocket: handshake requires an HTTP/1.1 GET request")
+	}
+	if !headerContainsToken(req.Headers["Upgrade"], "websocket") ||
+		!headerContainsToken(req.Headers["Connection"], "upgrade") {
+		resp := errorResponse(status.UpgradeRequired, "websocket: missing Upgrade: websocket")
+		resp.SetHeader("Upgrade", "websocket")
+		return resp
+	}
+	if req.Headers["Sec-Websocket-Version"] != "13" {
+		resp := errorResponse(status.UpgradeRequired, "websocket: unsupported version")
+		resp.SetHeader("Sec-WebSocket-Version", "13")
+		return resp
+	}
+	key := req.Headers["Sec-Websocket-Key"]
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return errorResponse(status.BadRequest, "websocket: invalid Sec-WebSocket-Key")
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		return errorResponse(status.Forbidden, "websocket: origin not allowed")
+	}
+
+	resp := http.NewResponse()
+	resp.StatusCode = status.SwitchingProtocols
+	resp.StatusText = http.StatusText(status.SwitchingProtocols)
+	resp.SetHeader("Upgrade", "websocket")
+	resp.SetHeader("Connection", "Upgrade")
+	resp.SetHeader("Sec-WebSocket-Accept", acceptKey(key))
+
+	subprotocol := u.selectSubprotocol(req)
+	if subprotocol != "" {
+		resp.SetHeader("Sec-WebSocket-Protocol", subprotocol)
+	}
+	compress := u.EnableCompression && acceptDeflate(req.Headers["Sec-Websocket-Extensions"])
+	if compress {
+		resp.SetHeader("Sec-WebSocket-Extensions", deflateResponse)
+	}
+
+	resp.Hijack = func(conn net.Conn, reader *bufio.Reader) {
+		ws := newConn(conn, reader, true)
+		ws.subprotocol = subprotocol
+		ws.compress = compress
+		ws.SetMaxMessageSize(u.MaxMessageSize)
+
+		handler(ws)
+		ws.Close()
+	}
+	return resp
+}
+
+func (u *Upgrader) selectSubprotocol(req *http.Request) string {
+	for _, offered := range strings.Split(req.Headers["Sec-Websocket-Protocol"], ",") {
+		offered = strings.TrimSpace(offered)
+		for _, supported := range u.Subprotocols {
+			if offered == supported {
+				return supported
+			}
+		}
+	}
+	return ""
+}
+
+// sameOrigin accepts requests without an Origin header (non-browser
+// clients) and those whose Origin host matches the Host header.
+func sameOrigin(req *http.Request) bool {
+	origin := req.Headers["Origin"]
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Headers["Host"])
+}
+
+// Dialer opens client WebSocket connections.
+type Dialer struct {
+	Subprotocols      []string
+	EnableCompression bool
+	HandshakeTimeout  time.Duration
+	TLSConfig         *tls.Config
+}
+
+// DefaultDialer is used by Dial.
+var DefaultDialer = &Dialer{HandshakeTimeout: 10 * time.Second}
+
+// Dial connects to a ws:// or wss:// URL using DefaultDialer.
+func Dial(rawURL string, headers map[string]string) (*Conn, *http.Response, error) {
+	return DefaultDialer.Dial(rawURL, headers)
+}
+
+// Dial connects to a ws:// or wss:// URL and performs the opening handshake.
+// The handshake response is returned even if the server refused the upgrade.
+func (d *Dialer) Dial(rawURL string, headers map[string]string) (*Conn, *http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	addr := u.Host
+	switch u.Scheme {
+	case "ws":
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	case "wss":
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "443")
+		}
+	default:
+		return nil, nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+
+	dialer := &net.Dialer{Timeout: d.HandshakeTimeout}
+	var conn net.Conn
+	if u.Scheme == "wss" {
+		config := &tls.Config{ServerName: u.Hostname()}
+		if d.TLSConfig != nil {
+			config = d.TLSConfig.Clone()
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, config)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.HandshakeTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(d.HandshakeTimeout))
+	}
+
+	ws, resp, err := d.handshake(conn, u, headers)
+	if err != nil {
+		conn.Close()
+		return nil, resp, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ws, resp, nil
+}
+
+func (d *Dialer) handshake(conn net.Conn, u *url.URL, headers map[string]string) (*Conn, *http.Response, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := http.NewRequest()
+	req.Method = "GET"
+	req.Version = "HTTP/1.1"
+	req.URL = u
+	req.Path = u.RequestURI()
+	for k, v := range headers {
+		req.Headers[k] = v
+	}
+	req.Headers["Host"] = u.Host
+	req.Headers["Upgrade"] = "websocket"
+	req.Headers["Connection"] = "Upgrade"
+	req.Headers["Sec-WebSocket-Key"] = key
+	req.Headers["Sec-WebSocket-Version"] = "13"
+	if len(d.Subprotocols) > 0 {
+		req.Headers["Sec-WebSocket-Protocol"] = strings.Join(d.Subprotocols, ", ")
+	}
+	if d.EnableCompression {
+		req.Headers["Sec-WebSocket-Extensions"] = "permessage-deflate; client_no_context_takeover; server_no_context_takeover"
+	}
+
+	if err := req.Write(conn); err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, "GET")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != status.SwitchingProtocols {
+		resp.ReadBody()
+		return nil, resp, fmt.Errorf("websocket: bad handshake status %d", resp.StatusCode)
+	}
+	if !headerContainsToken(resp.Headers["Upgrade"], "websocket") ||
+		!headerContainsToken(resp.Headers["Connection"], "upgrade") ||
+		resp.Headers["Sec-Websocket-Accept"] != acceptKey(key) {
+		return nil, resp, errors.New("websocket: invalid handshake response")
+	}
+
+	ws := newConn(conn, reader, false)
+	ws.subprotocol = resp.Headers["Sec-Websocket-Protocol"]
+	if ext := resp.Headers["Sec-Websocket-Extensions"]; ext != "" {
+		if !d.EnableCompression || !strings.HasPrefix(strings.TrimSpace(ext), "permessage-deflate") {
+			return nil, resp, fmt.Errorf("websocket: unexpected extension %q", ext)
+		}
+		ws.compress = true
+	}
+	return ws, resp, nil
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func headerContainsToken(header, token string) bool {
+	for _, part := range strings.Split(header, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func errorResponse(code int, message string) *http.Response {
+	resp := http.NewResponse()
+	resp.StatusCode = code
+	resp.StatusText = http.StatusText(code)
+	resp.SetHeader("Content-Type", "text/plain")
+	resp.SetBody([]byte(message))
+	return resp
+}
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// WriteText writes every metric in the Prometheus text exposition format,
+// sorted by name and label values so scrapes are easy to diff.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.RLock()
+	fn := f.fn
+	list := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		list = append(list, s)
+	}
+	f.mu.RUnlock()
+	if fn == nil && len(list) == 0 {
+		return
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.Join(list[i].values, "\xff") < strings.Join(list[j].values, "\xff")
+	})
+
+	if f.help != "" {
+		w.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+	}
+	w.WriteString("# TYPE " + f.name + " " + f.typ + "\n")
+	if fn != nil {
+		writeSample(w, f.name, nil, nil, "", "", fn())
+		return
+	}
+
+	for _, s := range list {
+		switch m := s.metric.(type) {
+		case *Counter:
+			writeSample(w, f.name, f.labels, s.values, "", "", m.Value())
+		case *Gauge:
+			writeSample(w, f.name, f.labels, s.values, "", "", m.Value())
+		case *Histogram:
+			var cumulative uint64
+			for i, upper := range m.upper {
+				cumulative += m.counts[i].Load()
+				writeSample(w, f.name+"_bucket", f.labels, s.values, "le", formatFloat(upper), float64(cumulative))
+			}
+			// Read the total after the buckets so +Inf is never below them.
+			count := m.count.Load()
+			writeSample(w, f.name+"_bucket", f.labels, s.values, "le", "+Inf", float64(count))
+			writeSample(w, f.name+"_sum", f.labels, s.values, "", "", m.sum.load())
+			writeSample(w, f.name+"_count", f.labels, s.values, "", "", float64(count))
+		}
+	}
+}
+
+// writeSample writes one line; extraName and extraValue add a label such
+// as a histogram bucket's "le".
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(label + `="` + escapeLabelValue(values[i]) + `"`)
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraName + `="` + extraValue + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, +1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string       { return helpEscaper.Replace(s) }
+func escapeLabelValue(s string) string { return labelEscaper.Replace(s) }
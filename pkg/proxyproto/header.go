@@ -0,0 +1,380 @@
+// Package proxyproto implements the receiving side of the HAProxy PROXY
+// protocol (versions 1 and 2), which load balancers use to pass the
+// original client address to the servers behind them.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// v1MaxLen is the longest possible v1 header, including the CRLF.
+const v1MaxLen = 107
+
+// ErrInvalidHeader is wrapped by every header parsing error.
+var ErrInvalidHeader = errors.New("proxyproto: invalid header")
+
+// Command says whether the connection was relayed for a client (Proxy) or
+// opened by the proxy itself, e.g. for a health check (Local).
+type Command byte
+
+const (
+	Local Command = 0x0
+	Proxy Command = 0x1
+)
+
+// TLV types (PROXY protocol specification section 2.2).
+const (
+	TypeALPN      byte = 0x01
+	TypeAuthority byte = 0x02
+	TypeCRC32C    byte = 0x03
+	TypeNoop      byte = 0x04
+	TypeUniqueID  byte = 0x05
+	TypeSSL       byte = 0x20
+	TypeNetNS     byte = 0x30
+
+	// Sub-TLVs of TypeSSL.
+	SubtypeSSLVersion byte = 0x21
+	SubtypeSSLCN      byte = 0x22
+	SubtypeSSLCipher  byte = 0x23
+	SubtypeSSLSigAlg  byte = 0x24
+	SubtypeSSLKeyAlg  byte = 0x25
+)
+
+// TLV is a type-length-value extension of a v2 header.
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Header is a parsed PROXY protocol header. Source and Destination are nil
+// for Local connections and for unknown or unspecified address families.
+type Header struct {
+	Version     int
+	Command     Command
+	Source      net.Addr
+	Destination net.Addr
+	TLVs        []TLV
+}
+
+// TLV returns the value of the first TLV of type t.
+func (h *Header) TLV(t byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ALPN is the application protocol the client negotiated with the proxy.
+func (h *Header) ALPN() string {
+	v, _ := h.TLV(TypeALPN)
+	return string(v)
+}
+
+// Authority is the host name the client asked for, usually via SNI.
+func (h *Header) Authority() string {
+	v, _ := h.TLV(TypeAuthority)
+	return string(v)
+}
+
+// TLSInfo describes the TLS connection between the client and the proxy,
+// from the PP2_TYPE_SSL TLV.
+type TLSInfo struct {
+	// Client holds the PP2_CLIENT_* flags.
+	Client byte
+	// Verified reports whether the client presented a certificate that the
+	// proxy verified.
+	Verified bool
+
+	Version    string
+	CommonName string
+	Cipher     string
+	SigAlg     string
+	KeyAlg     string
+}
+
+const (
+	ClientSSL      byte = 0x01
+	ClientCertConn byte = 0x02
+	ClientCertSess byte = 0x04
+)
+
+// TLS returns the TLS details sent by the proxy, or nil if the client
+// didn't connect over TLS.
+func (h *Header) TLS() *TLSInfo {
+	v, ok := h.TLV(TypeSSL)
+	if !ok || len(v) < 5 || v[0]&ClientSSL == 0 {
+		return nil
+	}
+	info := &TLSInfo{
+		Client:   v[0],
+		Verified: binary.BigEndian.Uint32(v[1:5]) == 0,
+	}
+	subs, err := parseTLVs(v[5:])
+	if err != nil {
+		return info
+	}
+	for _, sub := range subs {
+		switch sub.Type {
+		case SubtypeSSLVersion:
+			info.Version = string(sub.Value)
+		case SubtypeSSLCN:
+			info.CommonName = string(sub.Value)
+		case SubtypeSSLCipher:
+			info.Cipher = string(sub.Value)
+		case SubtypeSSLSigAlg:
+			info.SigAlg = string(sub.Value)
+		case SubtypeSSLKeyAlg:
+			info.KeyAlg = string(sub.Value)
+		}
+	}
+	return info
+}
+
+// hasHeader reports whether the buffered bytes start a PROXY header. It
+// returns false as soon as they can't, so a client that speaks first with
+// a short message isn't kept waiting.
+func hasHeader(r *bufio.Reader) (bool, error) {
+	for n := 1; n <= len(v2Signature); n++ {
+		b, err := r.Peek(n)
+		if err != nil {
+			return false, err
+		}
+		v1 := n <= len(v1Prefix) && bytes.Equal(b, v1Prefix[:n])
+		v2 := bytes.Equal(b, v2Signature[:n])
+		if !v1 && !v2 {
+			return false, nil
+		}
+		if v1 && n == len(v1Prefix) {
+			return true, nil
+		}
+	}
+	return true, nil
+}
+
+// ReadHeader reads a v1 or v2 header from r.
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	b, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(b, v1Prefix) {
+		return readV1(r)
+	}
+	return readV2(r)
+}
+
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidHeader, fmt.Sprintf(format, args...))
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= v1MaxLen {
+			return nil, invalid("v1 header too long")
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, invalid("v1 header not terminated by CRLF")
+	}
+
+	fields := strings.Split(text, " ")
+	h := &Header{Version: 1, Command: Proxy}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return h, nil
+	}
+	if len(fields) != 6 {
+		return nil, invalid("v1 header has %d fields", len(fields))
+	}
+	src, dst := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, err1 := parsePort(fields[4])
+	dstPort, err2 := parsePort(fields[5])
+	if src == nil || dst == nil || err1 != nil || err2 != nil {
+		return nil, invalid("v1 header has bad addresses")
+	}
+	switch fields[1] {
+	case "TCP4":
+		if src.To4() == nil || dst.To4() == nil || strings.Contains(fields[2]+fields[3], ":") {
+			return nil, invalid("v1 TCP4 header with non-IPv4 address")
+		}
+	case "TCP6":
+		if !strings.Contains(fields[2], ":") || !strings.Contains(fields[3], ":") {
+			return nil, invalid("v1 TCP6 header with non-IPv6 address")
+		}
+	default:
+		return nil, invalid("v1 header has protocol %q", fields[1])
+	}
+	h.Source = &net.TCPAddr{IP: src, Port: srcPort}
+	h.Destination = &net.TCPAddr{IP: dst, Port: dstPort}
+	return h, nil
+}
+
+func parsePort(s string) (int, error) {
+	if len(s) > 1 && s[0] == '0' {
+		return 0, errors.New("leading zero")
+	}
+	port, err := strconv.ParseUint(s, 10, 16)
+	return int(port), err
+}
+
+// Address families and transports of a v2 header.
+const (
+	familyUnspec = 0x0
+	familyInet   = 0x1
+	familyInet6  = 0x2
+	familyUnix   = 0x3
+
+	transportStream = 0x1
+	transportDgram  = 0x2
+)
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(fixed[:12], v2Signature) {
+		return nil, invalid("bad v2 signature")
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, invalid("unsupported version %d", fixed[12]>>4)
+	}
+	h := &Header{Version: 2, Command: Command(fixed[12] & 0xf)}
+	if h.Command != Local && h.Command != Proxy {
+		return nil, invalid("unknown command %d", h.Command)
+	}
+	family, transport := fixed[13]>>4, fixed[13]&0xf
+
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	var addrLen int
+	switch family {
+	case familyInet:
+		addrLen = 12
+	case familyInet6:
+		addrLen = 36
+	case familyUnix:
+		addrLen = 216
+	case familyUnspec:
+	default:
+		return nil, invalid("unknown address family %d", family)
+	}
+	if len(payload) < addrLen {
+		return nil, invalid("v2 header too short for its addresses")
+	}
+	addrs, rest := payload[:addrLen], payload[addrLen:]
+
+	tlvs, err := parseTLVs(rest)
+	if err != nil {
+		return nil, err
+	}
+	h.TLVs = tlvs
+	if sum, ok := h.TLV(TypeCRC32C); ok {
+		if err := checkCRC(fixed[:], payload, addrLen, sum); err != nil {
+			return nil, err
+		}
+	}
+
+	// A LOCAL connection's addresses must be ignored.
+	if h.Command == Local {
+		return h, nil
+	}
+	switch family {
+	case familyInet, familyInet6:
+		n := addrLen/2 - 2
+		srcIP, dstIP := net.IP(addrs[:n:n]), net.IP(addrs[n:2*n])
+		srcPort := int(binary.BigEndian.Uint16(addrs[2*n:]))
+		dstPort := int(binary.BigEndian.Uint16(addrs[2*n+2:]))
+		switch transport {
+		case transportStream:
+			h.Source = &net.TCPAddr{IP: srcIP, Port: srcPort}
+			h.Destination = &net.TCPAddr{IP: dstIP, Port: dstPort}
+		case transportDgram:
+			h.Source = &net.UDPAddr{IP: srcIP, Port: srcPort}
+			h.Destination = &net.UDPAddr{IP: dstIP, Port: dstPort}
+		}
+	case familyUnix:
+		network := "unix"
+		if transport == transportDgram {
+			network = "unixgram"
+		}
+		h.Source = &net.UnixAddr{Name: cString(addrs[:108]), Net: network}
+		h.Destination = &net.UnixAddr{Name: cString(addrs[108:]), Net: network}
+	}
+	return h, nil
+}
+
+func parseTLVs(b []byte) ([]TLV, error) {
+	var tlvs []TLV
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, invalid("truncated TLV")
+		}
+		n := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+n {
+			return nil, invalid("TLV longer than header")
+		}
+		tlvs = append(tlvs, TLV{Type: b[0], Value: b[3 : 3+n]})
+		b = b[3+n:]
+	}
+	return tlvs, nil
+}
+
+// checkCRC verifies a PP2_TYPE_CRC32C checksum, which covers the whole
+// header with the checksum itself zeroed.
+func checkCRC(fixed, payload []byte, addrLen int, sum []byte) error {
+	if len(sum) != 4 {
+		return invalid("CRC32C TLV has %d bytes", len(sum))
+	}
+	zeroed := append([]byte(nil), payload...)
+	for b := zeroed[addrLen:]; len(b) >= 3; {
+		n := int(binary.BigEndian.Uint16(b[1:3]))
+		if b[0] == TypeCRC32C {
+			clear(b[3 : 3+n])
+			break
+		}
+		b = b[3+n:]
+	}
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	crc.Write(fixed)
+	crc.Write(zeroed)
+	if crc.Sum32() != binary.BigEndian.Uint32(sum) {
+		return invalid("CRC32C mismatch")
+	}
+	return nil
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
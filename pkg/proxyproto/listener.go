@@ -0,0 +1,175 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// ErrUntrustedSource is returned when a peer that isn't a trusted proxy
+// sends a PROXY header, and ErrMissingHeader when a trusted peer is required
+// to send one but doesn't.
+var (
+	ErrUntrustedSource = errors.New("proxyproto: header from untrusted source")
+	ErrMissingHeader   = errors.New("proxyproto: missing header")
+)
+
+// Listener wraps a listener whose connections may start with a PROXY
+// header, as sent by HAProxy and most cloud load balancers with the PROXY
+// protocol enabled. Only peers in TrustedSources may send one; their
+// connections then report the original client and server addresses from
+// RemoteAddr and LocalAddr.
+//
+// Headers are read on the connection's first Read or address lookup rather
+// than in Accept, so one slow peer can't hold up the accept loop. Wrap the
+// listener before TLS: tls.NewListener(proxyproto.NewListener(...), ...).
+type Listener struct {
+	net.Listener
+
+	TrustedSources []netip.Prefix
+
+	// Required makes a missing header from a trusted source an error.
+	Required bool
+
+	// ReadHeaderTimeout bounds reading the header.
+	ReadHeaderTimeout time.Duration
+}
+
+// NewListener wraps inner, trusting headers from the given CIDRs or single
+// addresses.
+func NewListener(inner net.Listener, trusted ...string) (*Listener, error) {
+	l := &Listener{Listener: inner, ReadHeaderTimeout: 10 * time.Second}
+	for _, s := range trusted {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(s)
+			if addrErr != nil {
+				return nil, fmt.Errorf("proxyproto: invalid trusted source %q", s)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		l.TrustedSources = append(l.TrustedSources, prefix.Masked())
+	}
+	return l, nil
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{
+		Conn:     conn,
+		reader:   bufio.NewReader(conn),
+		trusted:  l.trusted(conn.RemoteAddr()),
+		required: l.Required,
+		timeout:  l.ReadHeaderTimeout,
+	}, nil
+}
+
+func (l *Listener) trusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok {
+		return false
+	}
+	for _, prefix := range l.TrustedSources {
+		if prefix.Contains(ip.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection accepted by a Listener.
+type Conn struct {
+	net.Conn
+	reader   *bufio.Reader
+	trusted  bool
+	required bool
+	timeout  time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+
+	// readDeadline is the deadline last set by the user of the
+	// connection, restored after the header has been read.
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+// Header returns the connection's PROXY header, reading it if that hasn't
+// happened yet. It is nil if the peer didn't send one.
+func (c *Conn) Header() (*Header, error) {
+	c.once.Do(c.readHeader)
+	return c.header, c.err
+}
+
+func (c *Conn) readHeader() {
+	if c.timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer func() {
+			c.mu.Lock()
+			c.Conn.SetReadDeadline(c.readDeadline)
+			c.mu.Unlock()
+		}()
+	}
+
+	present, err := hasHeader(c.reader)
+	switch {
+	case err != nil:
+		// Leave the error to the first Read.
+	case present && !c.trusted:
+		c.err = ErrUntrustedSource
+	case present:
+		c.header, c.err = ReadHeader(c.reader)
+	case c.trusted && c.required:
+		c.err = ErrMissingHeader
+	}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr is the client's address from the header, or the peer's.
+func (c *Conn) RemoteAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h != nil && h.Source != nil {
+		return h.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr is the address the client connected to from the header, or the
+// local end of the connection.
+func (c *Conn) LocalAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h != nil && h.Destination != nil {
+		return h.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
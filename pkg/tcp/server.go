@@ -1,6 +1,7 @@
 package tcp
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -11,13 +12,21 @@ func StartServer(address string) error {
 	if err != nil {
 		return fmt.Errorf("failed to start server: %v", err)
 	}
-	defer listener.Close()
-
 	fmt.Printf("Server listening on %s\n", address)
+	return Serve(listener)
+}
+
+// Serve echoes on connections accepted from listener, which may be wrapped,
+// e.g. by proxyproto.NewListener.
+func Serve(listener net.Listener) error {
+	defer listener.Close()
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
 			fmt.Printf("Failed to accept connection: %v\n", err)
 			continue
 		}
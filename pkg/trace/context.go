@@ -0,0 +1,130 @@
+// Package trace records spans and propagates them between services with
+// the W3C Trace Context headers (traceparent and tracestate).
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// TraceID identifies a trace, SpanID a span within it.
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (t TraceID) IsValid() bool  { return t != TraceID{} }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+func (s SpanID) IsValid() bool   { return s != SpanID{} }
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+// FlagSampled is the trace flag set when the trace is being recorded.
+const FlagSampled byte = 0x01
+
+// SpanContext is the part of a span that crosses process boundaries.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+
+	// Remote is set for span contexts parsed from incoming headers.
+	Remote bool
+}
+
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+func (sc SpanContext) Sampled() bool { return sc.Flags&FlagSampled != 0 }
+
+// Traceparent formats sc as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
+
+var errInvalidTraceparent = errors.New("trace: invalid traceparent")
+
+// ParseTraceparent parses a traceparent header value. Versions newer than
+// 00 are parsed as far as version 00 goes, as the specification requires.
+func ParseTraceparent(value string) (SpanContext, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 55 || (len(value) > 55 && value[:2] == "00") {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	if value[2] != '-' || value[35] != '-' || value[52] != '-' || (len(value) > 55 && value[55] != '-') {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	version, ok := decodeHex(value[:2], 1)
+	if !ok || version[0] == 0xff {
+		return SpanContext{}, errInvalidTraceparent
+	}
+
+	var sc SpanContext
+	traceID, ok1 := decodeHex(value[3:35], 16)
+	spanID, ok2 := decodeHex(value[36:52], 8)
+	flags, ok3 := decodeHex(value[53:55], 1)
+	if !ok1 || !ok2 || !ok3 {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Flags = flags[0] & FlagSampled
+	if !sc.IsValid() {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	sc.Remote = true
+	return sc, nil
+}
+
+// decodeHex decodes exactly n bytes of lowercase hex.
+func decodeHex(s string, n int) ([]byte, bool) {
+	if len(s) != 2*n || strings.ToLower(s) != s {
+		return nil, false
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+// maxTraceStateLen is the longest tracestate passed on; longer ones may
+// be dropped according to the specification.
+const maxTraceStateLen = 512
+
+// ParseTraceState returns a tracestate header value fit to propagate, or ""
+// if it is malformed or too long.
+func ParseTraceState(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) > maxTraceStateLen {
+		return ""
+	}
+	members := strings.Split(value, ",")
+	if len(members) > 32 {
+		return ""
+	}
+	for _, member := range members {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(member, "=")
+		if !ok || key == "" || val == "" || strings.ContainsAny(key+val, " \t,=") {
+			return ""
+		}
+	}
+	return value
+}
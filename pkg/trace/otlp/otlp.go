@@ -0,0 +1,184 @@
+// Package otlp exports spans to an OpenTelemetry collector over OTLP/HTTP
+// with JSON encoding.
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+	"github.com/appyzdl/Netrunner/pkg/trace"
+)
+
+// Exporter posts spans to Endpoint, usually a collector's
+// "http://host:4318/v1/traces".
+type Exporter struct {
+	Endpoint string
+
+	// Headers are added to every export request, e.g. for authentication.
+	Headers map[string]string
+
+	// Resource describes the process the spans come from; NewExporter
+	// sets service.name.
+	Resource []trace.Attribute
+
+	Client *http.Client
+}
+
+func NewExporter(endpoint, serviceName string) *Exporter {
+	return &Exporter{
+		Endpoint: endpoint,
+		Resource: []trace.Attribute{trace.String("service.name", serviceName)},
+		Client:   &http.Client{},
+	}
+}
+
+func (e *Exporter) ExportSpans(ctx context.Context, spans []*trace.SpanData) error {
+	body, err := json.Marshal(e.encode(spans))
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(e.Endpoint)
+	if err != nil {
+		return fmt.Errorf("otlp: invalid endpoint: %w", err)
+	}
+
+	req := http.NewRequest()
+	req.Method = "POST"
+	req.URL = u
+	req.Path = u.RequestURI()
+	for key, value := range e.Headers {
+		req.Headers[key] = value
+	}
+	req.Headers["Content-Type"] = "application/json"
+	req.Body = body
+
+	client := e.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("otlp: export failed: %w", err)
+	}
+	respBody, _ := resp.ReadBody()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("otlp: collector returned %d: %s", resp.StatusCode, respBody)
+	}
+	return nil
+}
+
+// The JSON encoding of ExportTraceServiceRequest. IDs are hex and 64-bit
+// integers are strings, as the OTLP/JSON mapping requires.
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	resource struct {
+		Attributes []keyValue `json:"attributes"`
+	}
+	scopeSpans struct {
+		Scope scope  `json:"scope"`
+		Spans []span `json:"spans"`
+	}
+	scope struct {
+		Name string `json:"name"`
+	}
+	span struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		TraceState        string     `json:"traceState,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes,omitempty"`
+		Events            []event    `json:"events,omitempty"`
+		Status            status     `json:"status"`
+	}
+	event struct {
+		TimeUnixNano string     `json:"timeUnixNano"`
+		Name         string     `json:"name"`
+		Attributes   []keyValue `json:"attributes,omitempty"`
+	}
+	status struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	keyValue struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+	anyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+	}
+)
+
+func (e *Exporter) encode(spans []*trace.SpanData) exportRequest {
+	out := make([]span, 0, len(spans))
+	for _, s := range spans {
+		encoded := span{
+			TraceID:           s.SpanContext.TraceID.String(),
+			SpanID:            s.SpanContext.SpanID.String(),
+			TraceState:        s.SpanContext.TraceState,
+			Name:              s.Name,
+			Kind:              int(s.Kind),
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        encodeAttributes(s.Attributes),
+			Status:            status{Code: int(s.StatusCode), Message: s.StatusMessage},
+		}
+		if s.Parent.IsValid() {
+			encoded.ParentSpanID = s.Parent.String()
+		}
+		for _, ev := range s.Events {
+			encoded.Events = append(encoded.Events, event{
+				TimeUnixNano: strconv.FormatInt(ev.Time.UnixNano(), 10),
+				Name:         ev.Name,
+				Attributes:   encodeAttributes(ev.Attributes),
+			})
+		}
+		out = append(out, encoded)
+	}
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: encodeAttributes(e.Resource)},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: "netrunner"}, Spans: out}},
+	}}}
+}
+
+func encodeAttributes(attrs []trace.Attribute) []keyValue {
+	var out []keyValue
+	for _, attr := range attrs {
+		var v anyValue
+		switch value := attr.Value.(type) {
+		case string:
+			v.StringValue = &value
+		case int64:
+			s := strconv.FormatInt(value, 10)
+			v.IntValue = &s
+		case int:
+			s := strconv.Itoa(value)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &value
+		case bool:
+			v.BoolValue = &value
+		default:
+			s := fmt.Sprint(value)
+			v.StringValue = &s
+		}
+		out = append(out, keyValue{Key: attr.Key, Value: v})
+	}
+	return out
+}
@@ -0,0 +1,82 @@
+package trace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// Sampler decides whether a new span is recorded and exported. Spans that
+// aren't sampled still get IDs and propagate, with the sampled flag unset.
+type Sampler interface {
+	ShouldSample(parent SpanContext, traceID TraceID, name string) bool
+}
+
+type samplerFunc func(parent SpanContext, traceID TraceID, name string) bool
+
+func (f samplerFunc) ShouldSample(parent SpanContext, traceID TraceID, name string) bool {
+	return f(parent, traceID, name)
+}
+
+// AlwaysSample records every span, NeverSample none.
+func AlwaysSample() Sampler {
+	return samplerFunc(func(SpanContext, TraceID, string) bool { return true })
+}
+
+func NeverSample() Sampler {
+	return samplerFunc(func(SpanContext, TraceID, string) bool { return false })
+}
+
+// TraceIDRatio samples the given fraction of traces. The decision depends
+// only on the trace ID, so every service using the same ratio agrees.
+func TraceIDRatio(fraction float64) Sampler {
+	switch {
+	case fraction >= 1:
+		return AlwaysSample()
+	case fraction <= 0:
+		return NeverSample()
+	}
+	bound := uint64(fraction * (1 << 63))
+	return samplerFunc(func(_ SpanContext, traceID TraceID, _ string) bool {
+		return binary.BigEndian.Uint64(traceID[8:])>>1 < bound
+	})
+}
+
+// ParentBased follows the parent's sampling decision and uses root for
+// spans that start a trace.
+func ParentBased(root Sampler) Sampler {
+	return samplerFunc(func(parent SpanContext, traceID TraceID, name string) bool {
+		if parent.IsValid() {
+			return parent.Sampled()
+		}
+		return root.ShouldSample(parent, traceID, name)
+	})
+}
+
+// ParseSampler builds a sampler from the names used by OTEL_TRACES_SAMPLER
+// and OTEL_TRACES_SAMPLER_ARG: always_on, always_off, traceidratio and
+// their parentbased_ variants. An empty name is parentbased_always_on.
+func ParseSampler(name, arg string) (Sampler, error) {
+	ratio := 1.0
+	if arg != "" {
+		var err error
+		if ratio, err = strconv.ParseFloat(arg, 64); err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("trace: invalid sampler ratio %q", arg)
+		}
+	}
+	switch name {
+	case "always_on":
+		return AlwaysSample(), nil
+	case "always_off":
+		return NeverSample(), nil
+	case "traceidratio":
+		return TraceIDRatio(ratio), nil
+	case "", "parentbased_always_on":
+		return ParentBased(AlwaysSample()), nil
+	case "parentbased_always_off":
+		return ParentBased(NeverSample()), nil
+	case "parentbased_traceidratio":
+		return ParentBased(TraceIDRatio(ratio)), nil
+	}
+	return nil, fmt.Errorf("trace: unknown sampler %q", name)
+}
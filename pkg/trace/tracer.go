@@ -0,0 +1,362 @@
+package trace
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SpanKind says what a span represents, as in OpenTelemetry.
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// StatusCode is the outcome of a span. Unset means the operation finished
+// without anything worth noting, which is normal for most spans.
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attribute is a key-value pair describing a span. Values are strings,
+// int64s, float64s or bools.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attribute          { return Attribute{key, value} }
+func Int(key string, value int) Attribute         { return Attribute{key, int64(value)} }
+func Int64(key string, value int64) Attribute     { return Attribute{key, value} }
+func Float64(key string, value float64) Attribute { return Attribute{key, value} }
+func Bool(key string, value bool) Attribute       { return Attribute{key, value} }
+
+// Event is something that happened at a point in time during a span.
+type Event struct {
+	Name       string
+	Time       time.Time
+	Attributes []Attribute
+}
+
+// SpanData is a finished span as handed to an Exporter.
+type SpanData struct {
+	Name          string
+	Kind          SpanKind
+	SpanContext   SpanContext
+	Parent        SpanID
+	Start, End    time.Time
+	Attributes    []Attribute
+	Events        []Event
+	StatusCode    StatusCode
+	StatusMessage string
+}
+
+// Exporter sends finished spans to a tracing backend.
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []*SpanData) error
+}
+
+// Tracer creates spans and exports the sampled ones in batches from a
+// background goroutine, so exporting never blocks a request. Spans are
+// dropped when the queue is full.
+type Tracer struct {
+	Sampler Sampler
+
+	// ErrorLog, if set, is called when an export fails.
+	ErrorLog func(err error)
+
+	exporter     Exporter
+	batchSize    int
+	batchTimeout time.Duration
+
+	queue   chan *SpanData
+	flush   chan chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	dropped atomic.Uint64
+}
+
+// NewTracer starts a tracer exporting to exporter, sampling with
+// ParentBased(AlwaysSample()) until Sampler is changed. Call Shutdown to
+// export the remaining spans.
+func NewTracer(exporter Exporter) *Tracer {
+	t := &Tracer{
+		Sampler:      ParentBased(AlwaysSample()),
+		exporter:     exporter,
+		batchSize:    512,
+		batchTimeout: 5 * time.Second,
+		queue:        make(chan *SpanData, 2048),
+		flush:        make(chan chan struct{}),
+		stop:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Dropped returns how many spans were dropped because the queue was full.
+func (t *Tracer) Dropped() uint64 { return t.dropped.Load() }
+
+func (t *Tracer) run() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(t.batchTimeout)
+	defer ticker.Stop()
+
+	var batch []*SpanData
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.ExportSpans(context.Background(), batch); err != nil && t.ErrorLog != nil {
+			t.ErrorLog(err)
+		}
+		batch = nil
+	}
+	drain := func() {
+		for {
+			select {
+			case span := <-t.queue:
+				batch = append(batch, span)
+			default:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= t.batchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case done := <-t.flush:
+			drain()
+			export()
+			close(done)
+		case <-t.stop:
+			drain()
+			export()
+			return
+		}
+	}
+}
+
+// Flush exports the spans ended so far and waits until that's done.
+func (t *Tracer) Flush() {
+	done := make(chan struct{})
+	select {
+	case t.flush <- done:
+		<-done
+	case <-t.stopped:
+	}
+}
+
+// Shutdown exports the remaining spans and stops the tracer. Spans ended
+// afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.once.Do(func() { close(t.stop) })
+	select {
+	case <-t.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Tracer) enqueue(span *SpanData) {
+	select {
+	case <-t.stop:
+		t.dropped.Add(1)
+		return
+	default:
+	}
+	select {
+	case t.queue <- span:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// SpanOption configures a span in Start.
+type SpanOption func(*Span)
+
+func WithKind(kind SpanKind) SpanOption {
+	return func(s *Span) { s.data.Kind = kind }
+}
+
+func WithAttributes(attrs ...Attribute) SpanOption {
+	return func(s *Span) { s.data.Attributes = append(s.data.Attributes, attrs...) }
+}
+
+// Start begins a span that is a child of the span in ctx, or of the
+// remote span context from ContextWithRemoteSpanContext, and returns a
+// context holding the new span.
+func (t *Tracer) Start(ctx context.Context, name string, opts ...SpanOption) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+
+	sc := SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), TraceState: parent.TraceState}
+	if !parent.IsValid() {
+		sc.TraceID = newTraceID()
+	}
+	sampler := t.Sampler
+	if sampler == nil {
+		sampler = ParentBased(AlwaysSample())
+	}
+	if sampler.ShouldSample(parent, sc.TraceID, name) {
+		sc.Flags |= FlagSampled
+	}
+
+	span := &Span{tracer: t, data: SpanData{
+		Name:        name,
+		Kind:        KindInternal,
+		SpanContext: sc,
+		Parent:      parent.SpanID,
+		Start:       time.Now(),
+	}}
+	for _, opt := range opts {
+		opt(span)
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+// Span is an operation being traced. All methods are safe to call on a nil
+// Span, which is what SpanFromContext returns when tracing is off.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// Tracer returns the tracer that started the span, so code that only has
+// a context can start child spans.
+func (s *Span) Tracer() *Tracer {
+	if s == nil {
+		return nil
+	}
+	return s.tracer
+}
+
+// IsRecording reports whether the span will be exported, so callers can
+// skip computing expensive attributes.
+func (s *Span) IsRecording() bool {
+	return s != nil && s.data.SpanContext.Sampled()
+}
+
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Name = name
+}
+
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if !s.IsRecording() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.StatusCode = code
+	s.data.StatusMessage = message
+}
+
+// RecordError adds an "exception" event for err and marks the span as
+// failed.
+func (s *Span) RecordError(err error) {
+	if err == nil || !s.IsRecording() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Events = append(s.data.Events, Event{
+		Name:       "exception",
+		Time:       time.Now(),
+		Attributes: []Attribute{String("exception.message", err.Error())},
+	})
+	s.data.StatusCode = StatusError
+	s.data.StatusMessage = err.Error()
+}
+
+// End finishes the span and queues it for export if it was sampled.
+// Calls after the first do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.SpanContext.Sampled() {
+		s.tracer.enqueue(&data)
+	}
+}
+
+type (
+	spanKey   struct{}
+	remoteKey struct{}
+)
+
+// ContextWithSpan returns a copy of ctx holding span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the current span in ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext returns a copy of ctx in which sc, parsed
+// from incoming headers, is the parent of the next span started.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context of the current span in
+// ctx, or the remote one if no span has been started yet.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext()
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
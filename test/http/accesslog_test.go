@@ -0,0 +1,236 @@
+package http_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+)
+
+func accessLogRequest() *http.Request {
+	return &http.Request{
+		Method:     "GET",
+		Path:       "/apache_pb.gif?x=1",
+		Version:    "HTTP/1.1",
+		RemoteAddr: "127.0.0.1:51234",
+		Headers: map[string]string{
+			"Host":          "example.com",
+			"Authorization": "Basic ZnJhbms6c2VjcmV0", // frank:secret
+			"Referer":       "http://www.example.com/start.html",
+			"User-Agent":    `Mozilla/4.08 "evil"`,
+			"X-Request-Id":  "req-1",
+		},
+	}
+}
+
+func okHandler(body string) http.HandlerFunc {
+	return func(req *http.Request) *http.Response {
+		resp := http.NewResponse()
+		resp.StatusCode = 200
+		resp.SetBody([]byte(body))
+		return resp
+	}
+}
+
+func TestAccessLogCommonAndCombined(t *testing.T) {
+	var out bytes.Buffer
+	http.NewAccessLog(&out, "").Middleware(okHandler("hello"))(accessLogRequest())
+	common := regexp.MustCompile(`^127\.0\.0\.1 - frank \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /apache_pb\.gif\?x=1 HTTP/1\.1" 200 5\n$`)
+	if !common.MatchString(out.String()) {
+		t.Errorf("Common log line = %q", out.String())
+	}
+
+	out.Reset()
+	http.NewAccessLog(&out, http.CombinedLogFormat).Middleware(okHandler(""))(accessLogRequest())
+	if !strings.HasSuffix(out.String(), `" 200 - "http://www.example.com/start.html" "Mozilla/4.08 \"evil\""`+"\n") {
+		t.Errorf("Combined log line = %q", out.String())
+	}
+}
+
+func TestAccessLogCustomFormat(t *testing.T) {
+	var out bytes.Buffer
+	log := http.NewAccessLog(&out, `%m %U%q %s %{X-Request-Id}i %{Content-Length}o %v %% %Z`)
+	log.Middleware(okHandler("abc"))(accessLogRequest())
+	if got, want := out.String(), "GET /apache_pb.gif?x=1 200 req-1 3 example.com % %Z\n"; got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	var out bytes.Buffer
+	req := accessLogRequest()
+	req.TLS = &tls.ConnectionState{Version: tls.VersionTLS13}
+	req.SetContext(http.ContextWithRequestID(req.Context(), "req-1"))
+
+	// A streamed body is logged once the server has sent and closed it.
+	resp := http.NewAccessLog(&out, http.JSONLogFormat).Middleware(func(req *http.Request) *http.Response {
+		resp := http.NewResponse()
+		resp.StatusCode = 200
+		resp.BodyReader = io.NopCloser(strings.NewReader("streamed body"))
+		return resp
+	})(req)
+	if out.Len() != 0 {
+		t.Fatalf("Logged before the body was sent: %s", out.String())
+	}
+	io.Copy(io.Discard, resp.BodyReader)
+	resp.BodyReader.(io.Closer).Close()
+
+	var record map[string]any
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("Not a JSON line: %q", out.String())
+	}
+	want := map[string]any{
+		"remote_addr": "127.0.0.1:51234",
+		"user":        "frank",
+		"status":      float64(200),
+		"bytes":       float64(len("streamed body")),
+		"user_agent":  `Mozilla/4.08 "evil"`,
+		"referer":     "http://www.example.com/start.html",
+		"tls_version": "TLS 1.3",
+		"request_id":  "req-1",
+	}
+	for key, value := range want {
+		if record[key] != value {
+			t.Errorf("%s = %v, want %v", key, record[key], value)
+		}
+	}
+}
+
+func TestRotatingFileBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	file, err := http.NewRotatingFile(path)
+	if err != nil {
+		t.Fatalf("NewRotatingFile failed: %v", err)
+	}
+	defer file.Close()
+	file.MaxSize = 10
+	file.MaxBackups = 1
+
+	for _, line := range []string{"0123456\n", "abcdefg\n", "ABCDEFG\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != "ABCDEFG\n" {
+		t.Errorf("Current file holds %q", data)
+	}
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 1 {
+		t.Fatalf("Got backups %v, want exactly one", backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != "abcdefg\n" {
+		t.Errorf("Backup holds %q, want the newest rotated line", data)
+	}
+}
+
+func TestRotatingFileKeepsUnrelatedFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	others := []string{path + ".gz", path + ".bak", path + ".20240102-150405.old"}
+	for _, name := range others {
+		os.WriteFile(name, []byte("keep\n"), 0o644)
+	}
+	file, err := http.NewRotatingFile(path)
+	if err != nil {
+		t.Fatalf("NewRotatingFile failed: %v", err)
+	}
+	defer file.Close()
+	file.MaxBackups = 1
+
+	for i := 0; i < 3; i++ {
+		file.Write([]byte("line\n"))
+		if err := file.Rotate(); err != nil {
+			t.Fatalf("Rotate failed: %v", err)
+		}
+	}
+
+	for _, name := range others {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("%s was removed: %v", filepath.Base(name), err)
+		}
+	}
+	if backups, _ := filepath.Glob(path + ".2*"); len(backups) != 2 {
+		// One backup plus the unrelated .20240102-150405.old file.
+		t.Errorf("Got backups %v, want one rotated file", backups)
+	}
+}
+
+func TestRotatingFileKeepsWritingWhenRotationFails(t *testing.T) {
+	// The backup name would exceed the file name length limit, so every
+	// rename fails.
+	path := filepath.Join(t.TempDir(), strings.Repeat("a", 250))
+	file, err := http.NewRotatingFile(path)
+	if err != nil {
+		t.Fatalf("NewRotatingFile failed: %v", err)
+	}
+	defer file.Close()
+	file.MaxSize = 10
+
+	for _, line := range []string{"0123456\n", "abcdefg\n", "ABCDEFG\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if data, _ := os.ReadFile(path); string(data) != "0123456\nabcdefg\nABCDEFG\n" {
+		t.Errorf("File holds %q, want every line", data)
+	}
+}
+
+func TestRotatingFileByTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	file, err := http.NewRotatingFile(path)
+	if err != nil {
+		t.Fatalf("NewRotatingFile failed: %v", err)
+	}
+	defer file.Close()
+	file.Interval = 20 * time.Millisecond
+
+	file.Write([]byte("old\n"))
+	time.Sleep(30 * time.Millisecond)
+	file.Write([]byte("new\n"))
+
+	if data, _ := os.ReadFile(path); string(data) != "new\n" {
+		t.Errorf("Current file holds %q, want only the new line", data)
+	}
+}
+
+func TestRotatingFileReopenOnSIGHUP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	file, err := http.NewRotatingFile(path)
+	if err != nil {
+		t.Fatalf("NewRotatingFile failed: %v", err)
+	}
+	defer file.Close()
+	stop := file.ReopenOnSignal()
+	defer stop()
+
+	file.Write([]byte("before\n"))
+	// What logrotate does before signalling the server.
+	os.Rename(path, path+".1")
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("File was not reopened after SIGHUP")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	file.Write([]byte("after\n"))
+	if data, _ := os.ReadFile(path); string(data) != "after\n" {
+		t.Errorf("Reopened file holds %q", data)
+	}
+}
@@ -0,0 +1,215 @@
+package admin_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+	"github.com/appyzdl/Netrunner/pkg/http/admin"
+	"github.com/appyzdl/Netrunner/pkg/metrics"
+)
+
+func startAdmin(t *testing.T, a *admin.Admin, addr string) net.Listener {
+	t.Helper()
+	listener, err := admin.Listen(addr)
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	server := http.NewServer(addr, a.Router().HandleRequest)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return listener
+}
+
+func get(t *testing.T, listener net.Listener, method, path, body string) (*http.Response, string) {
+	t.Helper()
+	addr := listener.Addr()
+	conn, err := net.Dial(addr.Network(), addr.String())
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	raw := method + " " + path + " HTTP/1.1\r\nHost: admin\r\nConnection: close\r\n"
+	if body != "" {
+		raw += "Content-Length: " + strconv.Itoa(len(body)) + "\r\n"
+	}
+	conn.Write([]byte(raw + "\r\n" + body))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), method)
+	if err != nil {
+		t.Fatalf("Could not read response to %s: %v", path, err)
+	}
+	respBody, err := resp.ReadBody()
+	if err != nil {
+		t.Fatalf("Could not read body of %s: %v", path, err)
+	}
+	return resp, string(respBody)
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	a := admin.New()
+	listener := startAdmin(t, a, "127.0.0.1:0")
+
+	if resp, body := get(t, listener, "GET", "/healthz", ""); resp.StatusCode != 200 || body != "ok\n" {
+		t.Errorf("/healthz = %d %q, want 200 ok", resp.StatusCode, body)
+	}
+	if resp, _ := get(t, listener, "GET", "/readyz", ""); resp.StatusCode != 503 {
+		t.Errorf("/readyz before startup = %d, want 503", resp.StatusCode)
+	}
+	a.SetReady(true)
+	if resp, _ := get(t, listener, "GET", "/readyz", ""); resp.StatusCode != 200 {
+		t.Errorf("/readyz when ready = %d, want 200", resp.StatusCode)
+	}
+	// Draining: liveness stays up, readiness fails.
+	a.SetReady(false)
+	if resp, _ := get(t, listener, "GET", "/readyz", ""); resp.StatusCode != 503 {
+		t.Errorf("/readyz while draining = %d, want 503", resp.StatusCode)
+	}
+	if resp, _ := get(t, listener, "GET", "/healthz", ""); resp.StatusCode != 200 {
+		t.Errorf("/healthz while draining = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	a := admin.New()
+	a.SetReady(true)
+	socket := filepath.Join(t.TempDir(), "admin.sock")
+	listener := startAdmin(t, a, "unix:"+socket)
+
+	if resp, _ := get(t, listener, "GET", "/readyz", ""); resp.StatusCode != 200 {
+		t.Errorf("/readyz over Unix socket = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	reg := metrics.NewRegistry()
+	reg.NewCounter("admin_test_total", "A test counter.").Inc()
+	a := admin.New()
+	a.Registry = reg
+	listener := startAdmin(t, a, "127.0.0.1:0")
+
+	resp, body := get(t, listener, "GET", "/metrics", "")
+	if resp.StatusCode != 200 || !strings.Contains(body, "admin_test_total 1") {
+		t.Errorf("/metrics = %d %q", resp.StatusCode, body)
+	}
+	if _, index := get(t, listener, "GET", "/", ""); !strings.Contains(index, "/metrics") {
+		t.Errorf("Index does not list /metrics: %q", index)
+	}
+}
+
+func TestRuntimeStats(t *testing.T) {
+	listener := startAdmin(t, admin.New(), "127.0.0.1:0")
+
+	resp, body := get(t, listener, "GET", "/debug/runtime", "")
+	if resp.StatusCode != 200 || resp.Headers["Content-Type"] != "application/json" {
+		t.Fatalf("/debug/runtime = %d %s", resp.StatusCode, resp.Headers["Content-Type"])
+	}
+	var stats struct {
+		Goroutines int `json:"goroutines"`
+		Memory     struct {
+			HeapAlloc uint64 `json:"heap_alloc_bytes"`
+		} `json:"memory"`
+	}
+	if err := json.Unmarshal([]byte(body), &stats); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if stats.Goroutines == 0 || stats.Memory.HeapAlloc == 0 {
+		t.Errorf("Missing stats: %s", body)
+	}
+}
+
+func TestGoroutineDump(t *testing.T) {
+	listener := startAdmin(t, admin.New(), "127.0.0.1:0")
+
+	_, body := get(t, listener, "GET", "/debug/goroutines", "")
+	if !strings.Contains(body, "goroutine ") || !strings.Contains(body, "TestGoroutineDump") {
+		t.Errorf("Goroutine dump lacks the test goroutine:\n%s", body)
+	}
+	if _, grouped := get(t, listener, "GET", "/debug/goroutines?debug=1", ""); !strings.HasPrefix(grouped, "goroutine profile: total") {
+		t.Errorf("?debug=1 = %.80q", grouped)
+	}
+}
+
+func TestPprof(t *testing.T) {
+	listener := startAdmin(t, admin.New(), "127.0.0.1:0")
+
+	if _, index := get(t, listener, "GET", "/debug/pprof/", ""); !strings.Contains(index, "heap") {
+		t.Errorf("pprof index lacks heap: %q", index)
+	}
+
+	resp, body := get(t, listener, "GET", "/debug/pprof/heap?gc=1", "")
+	if resp.StatusCode != 200 || resp.Headers["Content-Type"] != "application/octet-stream" || len(body) == 0 {
+		t.Errorf("heap = %d %s, %d bytes", resp.StatusCode, resp.Headers["Content-Type"], len(body))
+	}
+	// Profiles are gzipped protobufs.
+	if !strings.HasPrefix(body, "\x1f\x8b") {
+		t.Errorf("heap profile is not gzipped")
+	}
+	if _, text := get(t, listener, "GET", "/debug/pprof/heap?debug=1", ""); !strings.HasPrefix(text, "heap profile:") {
+		t.Errorf("heap?debug=1 = %.80q", text)
+	}
+
+	resp, body = get(t, listener, "GET", "/debug/pprof/profile?seconds=1", "")
+	if resp.StatusCode != 200 || !strings.HasPrefix(body, "\x1f\x8b") {
+		t.Errorf("CPU profile = %d, %d bytes", resp.StatusCode, len(body))
+	}
+	if resp, _ := get(t, listener, "GET", "/debug/pprof/profile?seconds=0", ""); resp.StatusCode != 400 {
+		t.Errorf("seconds=0 = %d, want 400", resp.StatusCode)
+	}
+	if resp, _ := get(t, listener, "GET", "/debug/pprof/nonsense", ""); resp.StatusCode != 404 {
+		t.Errorf("Unknown profile = %d, want 404", resp.StatusCode)
+	}
+
+	_, symbols := get(t, listener, "POST", "/debug/pprof/symbol", "0x1")
+	if !strings.HasPrefix(symbols, "num_symbols: 1\n") {
+		t.Errorf("symbol = %q", symbols)
+	}
+}
+
+func TestBuildInfoAndConfig(t *testing.T) {
+	a := admin.New()
+	listener := startAdmin(t, a, "127.0.0.1:0")
+
+	resp, body := get(t, listener, "GET", "/buildinfo", "")
+	if resp.StatusCode != 200 || !strings.Contains(body, `"go_version"`) {
+		t.Errorf("/buildinfo = %d %q", resp.StatusCode, body)
+	}
+
+	if resp, _ := get(t, listener, "GET", "/config", ""); resp.StatusCode != 404 {
+		t.Errorf("/config without a config = %d, want 404", resp.StatusCode)
+	}
+	configured := admin.New()
+	configured.Config = map[string]string{"http_addr": ":8080"}
+	resp, body = get(t, startAdmin(t, configured, "127.0.0.1:0"), "GET", "/config", "")
+	var config map[string]string
+	if err := json.Unmarshal([]byte(body), &config); err != nil || config["http_addr"] != ":8080" {
+		t.Errorf("/config = %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestListenRefusesPublicAddresses(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0:0", ":0"} {
+		if listener, err := admin.Listen(addr); !errors.Is(err, admin.ErrNotLoopback) {
+			if listener != nil {
+				listener.Close()
+			}
+			t.Errorf("Listen(%q) error = %v, want ErrNotLoopback", addr, err)
+		}
+	}
+	listener, err := admin.Listen("localhost:0")
+	if err != nil {
+		t.Fatalf("Listen on localhost failed: %v", err)
+	}
+	listener.Close()
+
+	listener, err = admin.ListenPublic("0.0.0.0:0")
+	if err != nil {
+		t.Fatalf("ListenPublic failed: %v", err)
+	}
+	listener.Close()
+}
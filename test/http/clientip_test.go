@@ -0,0 +1,73 @@
+package http_test
+
+import (
+	"testing"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	resolver, err := http.NewClientIPResolver("10.0.0.0/8", "192.168.1.1", "2001:db8::/32")
+	if err != nil {
+		t.Fatalf("NewClientIPResolver failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"untrusted peer ignores headers", "203.0.113.9:5000",
+			map[string]string{"X-Forwarded-For": "1.2.3.4"}, "203.0.113.9"},
+		{"trusted peer without headers", "10.0.0.1:5000", nil, "10.0.0.1"},
+		{"x-forwarded-for", "10.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7"},
+		{"walks right to left past trusted hops", "10.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.7, 10.1.2.3, 192.168.1.1"}, "198.51.100.7"},
+		{"all hops trusted gives leftmost", "10.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "10.9.9.9, 10.1.1.1"}, "10.9.9.9"},
+		{"garbage stops at the reporting proxy", "10.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.7, unknown, 10.1.1.1"}, "10.1.1.1"},
+		{"x-real-ip", "192.168.1.1:5000",
+			map[string]string{"X-Real-Ip": "198.51.100.8"}, "198.51.100.8"},
+		{"forwarded with ports and ipv6", "10.0.0.1:5000",
+			map[string]string{"Forwarded": `for="[2001:db9::1]:4711";proto=https, for=10.2.2.2:80;by=10.0.0.1`}, "2001:db9::1"},
+		{"forwarded preferred over x-forwarded-for", "10.0.0.1:5000",
+			map[string]string{"Forwarded": "for=198.51.100.1", "X-Forwarded-For": "198.51.100.2"}, "198.51.100.1"},
+		{"forwarded quoted commas", "10.0.0.1:5000",
+			map[string]string{"Forwarded": `for=198.51.100.3;host="a,b"`}, "198.51.100.3"},
+		{"ipv6 trusted peer", "[2001:db8::5]:443",
+			map[string]string{"X-Forwarded-For": "198.51.100.4"}, "198.51.100.4"},
+	}
+	for _, tt := range tests {
+		headers := tt.headers
+		if headers == nil {
+			headers = map[string]string{}
+		}
+		req := &http.Request{Method: "GET", Path: "/", RemoteAddr: tt.remote, Headers: headers}
+		if got := resolver.Resolve(req); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := http.NewClientIPResolver("not-an-ip"); err == nil {
+		t.Error("Expected an error for an invalid trusted proxy")
+	}
+}
+
+func TestClientIPMiddlewareOverServer(t *testing.T) {
+	resolver, _ := http.NewClientIPResolver("127.0.0.0/8")
+	var seen string
+	addr := startServer(t, resolver.Middleware(func(req *http.Request) *http.Response {
+		seen = req.ClientIP
+		resp := http.NewResponse()
+		resp.StatusCode = 200
+		return resp
+	}))
+
+	rawRequest(t, addr, "GET / HTTP/1.1\r\nHost: test\r\nX-Forwarded-For: 198.51.100.20\r\n\r\n")
+	if seen != "198.51.100.20" {
+		t.Errorf("ClientIP = %q, want the forwarded address", seen)
+	}
+}
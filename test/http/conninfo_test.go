@@ -0,0 +1,65 @@
+package http_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+)
+
+func connInfoHandler(req *http.Request) *http.Response {
+	resp := http.NewResponse()
+	resp.StatusCode = 200
+	resp.SetBody([]byte(fmt.Sprintf("%s %s %d %d", req.RemoteAddr, req.LocalAddr, req.ConnID, req.ConnSeq)))
+	return resp
+}
+
+func readConnInfo(t *testing.T, resp *http.Response) (remote, local string, id, seq uint64) {
+	t.Helper()
+	body, _ := resp.ReadBody()
+	if _, err := fmt.Sscanf(string(body), "%s %s %d %d", &remote, &local, &id, &seq); err != nil {
+		t.Fatalf("Bad body %q: %v", body, err)
+	}
+	return remote, local, id, seq
+}
+
+func TestRequestConnectionInfo(t *testing.T) {
+	addr := startServer(t, connInfoHandler)
+
+	resp, conn, reader := rawRequest(t, addr, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	remote, local, firstID, seq := readConnInfo(t, resp)
+	if remote != conn.LocalAddr().String() || local != addr {
+		t.Errorf("Got remote %s local %s, want %s and %s", remote, local, conn.LocalAddr(), addr)
+	}
+	if firstID == 0 || seq != 1 {
+		t.Errorf("First request got conn %d seq %d", firstID, seq)
+	}
+
+	// The next request on the same connection keeps the ID.
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	resp, err := http.ReadResponse(reader, "GET")
+	if err != nil {
+		t.Fatalf("Could not read second response: %v", err)
+	}
+	if _, _, id, seq := readConnInfo(t, resp); id != firstID || seq != 2 {
+		t.Errorf("Second request got conn %d seq %d, want conn %d seq 2", id, seq, firstID)
+	}
+
+	// A new connection gets a new ID.
+	resp, _, _ = rawRequest(t, addr, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	if _, _, id, seq := readConnInfo(t, resp); id == firstID || seq != 1 {
+		t.Errorf("New connection got conn %d seq %d", id, seq)
+	}
+}
+
+func TestRequestAttrsIncludeConnection(t *testing.T) {
+	req := &http.Request{Method: "GET", Path: "/", RemoteAddr: "10.0.0.1:1234", ConnID: 7, ConnSeq: 3}
+	var parts []string
+	for _, attr := range http.RequestAttrs(req) {
+		parts = append(parts, fmt.Sprint(attr))
+	}
+	if got := strings.Join(parts, " "); !strings.Contains(got, "conn=7") || !strings.Contains(got, "seq=3") {
+		t.Errorf("RequestAttrs = %s", got)
+	}
+}
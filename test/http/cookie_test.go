@@ -0,0 +1,121 @@
+package http_test
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+)
+
+func TestRequestCookies(t *testing.T) {
+	req := http.NewRequest()
+	req.Headers["Cookie"] = `session=abc123; theme="dark"; bad name=x; lang=en`
+
+	cookies := req.Cookies()
+	if len(cookies) != 3 {
+		t.Fatalf("Expected 3 cookies, got %d", len(cookies))
+	}
+
+	theme, err := req.Cookie("theme")
+	if err != nil {
+		t.Fatalf("Expected theme cookie, got error: %v", err)
+	}
+	if theme.Value != "dark" {
+		t.Errorf("Expected theme value 'dark', got '%s'", theme.Value)
+	}
+
+	if _, err := req.Cookie("missing"); err != http.ErrNoCookie {
+		t.Errorf("Expected ErrNoCookie, got %v", err)
+	}
+}
+
+func TestSetCookieSerialization(t *testing.T) {
+	cookie := &http.Cookie{
+		Name:        "session",
+		Value:       "abc123",
+		Domain:      ".example.com",
+		Path:        "/app",
+		Expires:     time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    http.SameSiteStrictMode,
+		Partitioned: true,
+	}
+
+	expected := "session=abc123; Path=/app; Domain=example.com; Expires=Wed, 02 Jan 2030 03:04:05 GMT; " +
+		"Max-Age=3600; HttpOnly; Secure; SameSite=Strict; Partitioned"
+	if cookie.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, cookie.String())
+	}
+
+	parsed, err := http.ParseSetCookie(expected)
+	if err != nil {
+		t.Fatalf("Failed to parse Set-Cookie: %v", err)
+	}
+	if parsed.Domain != "example.com" || parsed.Path != "/app" || parsed.MaxAge != 3600 {
+		t.Errorf("Unexpected parsed cookie: %+v", parsed)
+	}
+	if !parsed.Secure || !parsed.HttpOnly || !parsed.Partitioned || parsed.SameSite != http.SameSiteStrictMode {
+		t.Errorf("Expected all flags to round-trip, got %+v", parsed)
+	}
+	if !parsed.Expires.Equal(cookie.Expires) {
+		t.Errorf("Expected expiry %v, got %v", cookie.Expires, parsed.Expires)
+	}
+}
+
+func TestResponseMultipleSetCookies(t *testing.T) {
+	response := http.NewResponse()
+	response.SetStatus(200)
+	response.SetCookie(&http.Cookie{Name: "a", Value: "1"})
+	response.SetCookie(&http.Cookie{Name: "b", Value: "2", HttpOnly: true})
+
+	raw := string(response.Write())
+	if !strings.Contains(raw, "Set-Cookie: a=1\r\n") || !strings.Contains(raw, "Set-Cookie: b=2; HttpOnly\r\n") {
+		t.Errorf("Expected two Set-Cookie headers, got %q", raw)
+	}
+}
+
+func TestCookieJar(t *testing.T) {
+	jar := http.NewCookieJar()
+	origin, _ := url.Parse("https://www.example.com/app/login")
+
+	jar.SetCookies(origin, []*http.Cookie{
+		{Name: "host", Value: "1"},
+		{Name: "wide", Value: "2", Domain: "example.com", Path: "/"},
+		{Name: "secure", Value: "3", Secure: true},
+		{Name: "tld", Value: "4", Domain: "com"},
+		{Name: "other", Value: "5", Domain: "other.org"},
+	})
+
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"https://www.example.com/app/page", "host=1; secure=3; wide=2"},
+		{"http://www.example.com/app/page", "host=1; wide=2"},
+		{"https://api.example.com/app/page", "wide=2"},
+		{"https://www.example.com/other", "wide=2"},
+		{"https://example.org/", ""},
+	}
+
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		var pairs []string
+		for _, c := range jar.Cookies(u) {
+			pairs = append(pairs, c.Name+"="+c.Value)
+		}
+		if got := strings.Join(pairs, "; "); got != tt.expected {
+			t.Errorf("Cookies(%s): expected %q, got %q", tt.url, tt.expected, got)
+		}
+	}
+
+	// A Max-Age of zero deletes the stored cookie.
+	jar.SetCookies(origin, []*http.Cookie{{Name: "wide", Domain: "example.com", Path: "/", MaxAge: -1}})
+	u, _ := url.Parse("https://api.example.com/")
+	if cookies := jar.Cookies(u); len(cookies) != 0 {
+		t.Errorf("Expected deleted cookie to be gone, got %d cookies", len(cookies))
+	}
+}
@@ -0,0 +1,113 @@
+package http_test
+
+import (
+	"html/template"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+)
+
+func newPageRequest(host, accept string) *http.Request {
+	return &http.Request{
+		Method:  "GET",
+		Path:    "/static/missing",
+		Version: "HTTP/1.1",
+		Headers: map[string]string{"Host": host, "Accept": accept},
+	}
+}
+
+func TestErrorPagesFromDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "404.html"), []byte("<h1>lost</h1>"), 0o644)
+	os.WriteFile(filepath.Join(dir, "500.tmpl"), []byte("<h1>{{.Status}} {{.StatusText}} at {{.Path}}</h1>"), 0o644)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("not an error page"), 0o644)
+
+	pages := http.NewErrorPages()
+	if err := pages.LoadDir("", dir); err != nil {
+		t.Fatalf("LoadDir failed: %v", err)
+	}
+
+	resp := pages.Render(newPageRequest("example.com", ""), http.NewHTTPError(404, ""))
+	if resp.StatusCode != 404 || string(resp.Body) != "<h1>lost</h1>" {
+		t.Errorf("Got %d %q, want the 404 page", resp.StatusCode, resp.Body)
+	}
+
+	resp = pages.Render(newPageRequest("example.com", "text/html"), http.NewHTTPError(500, ""))
+	if string(resp.Body) != "<h1>500 Internal Server Error at /static/missing</h1>" {
+		t.Errorf("Template rendered %q", resp.Body)
+	}
+
+	// No page for 403, and API clients still get problem details.
+	resp = pages.Render(newPageRequest("example.com", ""), http.NewHTTPError(403, "nope"))
+	if string(resp.Body) != "nope" {
+		t.Errorf("403 without a page rendered %q, want the built-in text", resp.Body)
+	}
+	resp = pages.Render(newPageRequest("example.com", "application/json"), http.NewHTTPError(404, ""))
+	if resp.Headers["Content-Type"] != "application/problem+json" {
+		t.Errorf("JSON client got Content-Type %q", resp.Headers["Content-Type"])
+	}
+}
+
+func TestErrorPagesPerHost(t *testing.T) {
+	pages := http.NewErrorPages()
+	pages.SetTemplate("", 404, template.Must(template.New("404").Parse("default {{.Host}}")))
+	pages.SetTemplate("shop.example.com", 404, template.Must(template.New("404").Parse("shop {{.Detail}}")))
+
+	resp := pages.Render(newPageRequest("Shop.Example.com:8080", "text/html"), http.NewHTTPError(404, "<gone>"))
+	if string(resp.Body) != "shop &lt;gone&gt;" {
+		t.Errorf("Virtual host page rendered %q", resp.Body)
+	}
+	resp = pages.Render(newPageRequest("blog.example.com", "text/html"), http.NewHTTPError(404, ""))
+	if string(resp.Body) != "default blog.example.com" {
+		t.Errorf("Default page rendered %q", resp.Body)
+	}
+}
+
+func TestErrorPagesLogTemplateFailuresToRequestLogger(t *testing.T) {
+	pages := http.NewErrorPages()
+	pages.SetTemplate("", 500, template.Must(template.New("500").Parse("{{.Missing}}")))
+	logger, logs := newTestLogger(slog.LevelInfo)
+	req := newPageRequest("example.com", "text/html")
+	req.SetLogger(logger)
+
+	resp := pages.Render(req, http.NewHTTPError(500, ""))
+	if resp.StatusCode != 500 {
+		t.Errorf("Got %d, want the built-in 500", resp.StatusCode)
+	}
+	if record := logs.find(t, "Error page template failed"); record["status"] != float64(500) {
+		t.Errorf("Logged status %v, want 500", record["status"])
+	}
+}
+
+func TestErrorPagesUsedByRouter(t *testing.T) {
+	pages := http.NewErrorPages()
+	pages.SetTemplate("", 404, template.Must(template.New("404").Parse("custom {{.Status}}")))
+
+	router := http.NewRouter()
+	router.AllowInsecure = true
+	router.ErrorPages = pages
+	router.AddRoute("GET", "/missing", func(req *http.Request) *http.Response {
+		return http.NotFoundResponseFor(req)
+	})
+	resp := router.HandleRequest(newPageRequest("example.com", "text/html"))
+	if resp.StatusCode != 404 || string(resp.Body) != "custom 404" {
+		t.Errorf("Router gave %d %q, want the custom page", resp.StatusCode, resp.Body)
+	}
+	req := newPageRequest("example.com", "text/html")
+	req.Path = "/missing"
+	if resp := router.HandleRequest(req); string(resp.Body) != "custom 404" {
+		t.Errorf("Handler gave %q, want the router's custom page", resp.Body)
+	}
+	// Another router's errors are unaffected.
+	if resp := http.NewRouter().HandleRequest(newPageRequest("example.com", "text/html")); string(resp.Body) == "custom 404" {
+		t.Errorf("Router without pages used the custom page")
+	}
+	resp = router.HandleRequest(newPageRequest("example.com", "text/plain"))
+	if !strings.HasPrefix(resp.Headers["Content-Type"], "text/plain") {
+		t.Errorf("Plain text client got Content-Type %q", resp.Headers["Content-Type"])
+	}
+}
@@ -0,0 +1,191 @@
+package http_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+)
+
+var errDatabase = errors.New("connection refused")
+
+func findUser(req *http.Request) (*http.Response, error) {
+	switch req.Path {
+	case "/users/missing":
+		return nil, http.NewHTTPError(404, "no such user")
+	case "/users/wrapped":
+		return nil, fmt.Errorf("loading user: %w", http.NewHTTPError(403, "forbidden"))
+	case "/users/broken":
+		return nil, errDatabase
+	}
+	resp := http.NewResponse()
+	resp.StatusCode = 200
+	resp.SetBody([]byte("alice"))
+	return resp, nil
+}
+
+func serveRouter(router *http.Router, path string) *http.Response {
+	return router.HandleRequest(&http.Request{
+		Method:  "GET",
+		Path:    path,
+		Version: "HTTP/1.1",
+		Headers: map[string]string{},
+	})
+}
+
+func TestRouteErrorsUseDefaultHandler(t *testing.T) {
+	router := http.NewRouter()
+	router.AddRouteE("GET", "/static/users/*", func(req *http.Request) (*http.Response, error) {
+		req.Path = req.Path[len("/static"):]
+		return findUser(req)
+	})
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/static/users/alice", 200, "alice"},
+		{"/static/users/missing", 404, "no such user"},
+		{"/static/users/wrapped", 403, "forbidden"},
+		{"/static/users/broken", 500, "500 Internal Server Error"},
+		{"/static/nothing", 404, "404 Not Found"},
+	}
+	for _, tt := range tests {
+		resp := serveRouter(router, tt.path)
+		if resp.StatusCode != tt.code || string(resp.Body) != tt.body {
+			t.Errorf("%s: got %d %q, want %d %q", tt.path, resp.StatusCode, resp.Body, tt.code, tt.body)
+		}
+	}
+}
+
+func TestCustomErrorHandler(t *testing.T) {
+	router := http.NewRouter()
+	var seen error
+	router.ErrorHandler = func(req *http.Request, err error) *http.Response {
+		seen = err
+		resp := http.NewResponse()
+		resp.StatusCode = 503
+		resp.SetBody([]byte("custom"))
+		return resp
+	}
+	router.AddRouteE("GET", "/static/users/*", func(req *http.Request) (*http.Response, error) {
+		return nil, errDatabase
+	})
+
+	resp := serveRouter(router, "/static/users/bob")
+	if resp.StatusCode != 503 || string(resp.Body) != "custom" {
+		t.Errorf("Got %d %q, want the custom error response", resp.StatusCode, resp.Body)
+	}
+	if !errors.Is(seen, errDatabase) {
+		t.Errorf("Error handler saw %v, want %v", seen, errDatabase)
+	}
+
+	// The router's own 404 goes through the same handler.
+	serveRouter(router, "/static/missing")
+	var httpErr *http.HTTPError
+	if !errors.As(seen, &httpErr) || httpErr.Code != 404 {
+		t.Errorf("Error handler saw %v for an unknown route, want a 404 HTTPError", seen)
+	}
+}
+
+func TestHandleErrorsAdapter(t *testing.T) {
+	handler := http.HandleErrors(findUser, nil)
+	resp := handler(&http.Request{Method: "GET", Path: "/users/missing", Headers: map[string]string{}})
+	if resp.StatusCode != 404 {
+		t.Errorf("Got status %d, want 404", resp.StatusCode)
+	}
+
+	wrapped := http.WrapHTTPError(502, "upstream failed", errDatabase)
+	if !errors.Is(wrapped, errDatabase) {
+		t.Error("WrapHTTPError does not unwrap to its cause")
+	}
+}
+
+func TestRenderErrorNegotiatesFormat(t *testing.T) {
+	httpErr := &http.HTTPError{
+		Code:       403,
+		Message:    "Your balance is 30, but that costs 50.",
+		Type:       "https://example.com/probs/out-of-credit",
+		Title:      "You do not have enough credit.",
+		Instance:   "/account/12345/msgs/abc",
+		Extensions: map[string]any{"balance": 30},
+	}
+
+	tests := []struct {
+		accept      string
+		contentType string
+	}{
+		{"", "text/plain; charset=utf-8"},
+		{"*/*", "text/plain; charset=utf-8"},
+		{"application/json", "application/problem+json"},
+		{"application/problem+json, text/html;q=0.9", "application/problem+json"},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", "text/html; charset=utf-8"},
+		{"text/plain;q=0.1, application/*;q=0.5", "application/problem+json"},
+	}
+	for _, tt := range tests {
+		req := &http.Request{Method: "GET", Path: "/", Headers: map[string]string{"Accept": tt.accept}}
+		resp := http.RenderError(req, httpErr)
+		if resp.StatusCode != 403 {
+			t.Errorf("Accept %q: got status %d, want 403", tt.accept, resp.StatusCode)
+		}
+		if got := resp.Headers["Content-Type"]; got != tt.contentType {
+			t.Errorf("Accept %q: got Content-Type %q, want %q", tt.accept, got, tt.contentType)
+		}
+	}
+}
+
+func TestProblemJSONMembers(t *testing.T) {
+	req := &http.Request{Method: "GET", Path: "/", Headers: map[string]string{"Accept": "application/problem+json"}}
+	resp := http.RenderError(req, &http.HTTPError{
+		Code:       403,
+		Message:    "Your balance is 30, but that costs 50.",
+		Type:       "https://example.com/probs/out-of-credit",
+		Instance:   "/account/12345/msgs/abc",
+		Extensions: map[string]any{"balance": 30, "status": "ignored"},
+	})
+
+	var problem map[string]any
+	if err := json.Unmarshal(resp.Body, &problem); err != nil {
+		t.Fatalf("Body is not JSON: %v\n%s", err, resp.Body)
+	}
+	want := map[string]any{
+		"type":     "https://example.com/probs/out-of-credit",
+		"title":    "Forbidden",
+		"status":   float64(403),
+		"detail":   "Your balance is 30, but that costs 50.",
+		"instance": "/account/12345/msgs/abc",
+		"balance":  float64(30),
+	}
+	for name, value := range want {
+		if problem[name] != value {
+			t.Errorf("Member %q = %v, want %v", name, problem[name], value)
+		}
+	}
+
+	// Without a type the problem is "about:blank" and has no detail.
+	resp = http.NotFoundResponseFor(req)
+	json.Unmarshal(resp.Body, &problem)
+	if resp.StatusCode != 404 || problem["type"] != "about:blank" || problem["title"] != "Not Found" {
+		t.Errorf("NotFoundResponseFor gave %d %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestHTMLErrorIsEscaped(t *testing.T) {
+	req := &http.Request{Method: "GET", Path: "/", Headers: map[string]string{"Accept": "text/html"}}
+	resp := http.RenderError(req, http.NewHTTPError(400, "<script>alert(1)</script>"))
+	if strings.Contains(string(resp.Body), "<script>") {
+		t.Errorf("Detail was not escaped: %s", resp.Body)
+	}
+}
+
+func TestParseErrorIsPlainText(t *testing.T) {
+	addr := startServer(t, func(req *http.Request) *http.Response { return http.NewResponse() })
+	resp, _, _ := rawRequest(t, addr, "NONSENSE\r\n\r\n")
+	if resp.StatusCode != 400 || !strings.HasPrefix(resp.Headers["Content-Type"], "text/plain") {
+		t.Errorf("Got %d with Content-Type %q, want a plain text 400", resp.StatusCode, resp.Headers["Content-Type"])
+	}
+}
@@ -0,0 +1,84 @@
+package http_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+)
+
+// uploadHandler rejects requests without a token before touching the body,
+// the way auth middleware would, and echoes the body otherwise.
+func uploadHandler(req *http.Request) *http.Response {
+	resp := http.NewResponse()
+	if req.Headers["X-Token"] != "secret" {
+		resp.StatusCode = 401
+		resp.SetBody([]byte("no token"))
+		return resp
+	}
+	body, _ := req.ReadBody()
+	resp.StatusCode = 200
+	resp.SetBody(body)
+	return resp
+}
+
+func sendExpectHeaders(t *testing.T, addr, extra string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "PUT /upload HTTP/1.1\r\nHost: %s\r\nContent-Length: 5\r\n%s\r\n", addr, extra)
+	return conn, bufio.NewReader(conn)
+}
+
+func TestExpectContinueSentOnRead(t *testing.T) {
+	addr := startServer(t, uploadHandler)
+	conn, reader := sendExpectHeaders(t, addr, "Expect: 100-continue\r\nX-Token: secret\r\n")
+
+	interim, err := http.ReadResponse(reader, "PUT")
+	if err != nil || interim.StatusCode != 100 {
+		t.Fatalf("Expected 100 Continue before sending the body, got %v (%v)", interim, err)
+	}
+	conn.Write([]byte("hello"))
+
+	resp, err := http.ReadResponse(reader, "PUT")
+	if err != nil {
+		t.Fatalf("Could not read final response: %v", err)
+	}
+	if body, _ := resp.ReadBody(); resp.StatusCode != 200 || string(body) != "hello" {
+		t.Errorf("Expected 200 'hello', got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestExpectContinueRejectedBeforeBody(t *testing.T) {
+	addr := startServer(t, uploadHandler)
+	_, reader := sendExpectHeaders(t, addr, "Expect: 100-continue\r\n")
+
+	resp, err := http.ReadResponse(reader, "PUT")
+	if err != nil {
+		t.Fatalf("Could not read response: %v", err)
+	}
+	if resp.StatusCode != 401 {
+		t.Errorf("Expected a final 401 without 100 Continue, got %d", resp.StatusCode)
+	}
+	if resp.Headers["Connection"] != "close" {
+		t.Errorf("Expected the connection to be closed since the body was never requested")
+	}
+}
+
+func TestExpectUnknownExpectation(t *testing.T) {
+	addr := startServer(t, uploadHandler)
+	_, reader := sendExpectHeaders(t, addr, "Expect: teapot\r\nX-Token: secret\r\n")
+
+	resp, err := http.ReadResponse(reader, "PUT")
+	if err != nil || resp.StatusCode != 417 {
+		t.Errorf("Expected 417 Expectation Failed, got %v (%v)", resp, err)
+	}
+}
@@ -0,0 +1,228 @@
+package http_test
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+)
+
+// rawRequest sends a raw request to addr and reads the response head on the
+// same connection, which is returned for further use.
+func rawRequest(t *testing.T, addr, request string) (*http.Response, net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Could not connect to %s: %v", addr, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatalf("Could not send request: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	method, _, _ := strings.Cut(request, " ")
+	resp, err := http.ReadResponse(reader, method)
+	if err != nil {
+		t.Fatalf("Could not read response: %v", err)
+	}
+	return resp, conn, reader
+}
+
+func TestForwardProxyAbsoluteForm(t *testing.T) {
+	backend := startServer(t, func(req *http.Request) *http.Response {
+		resp := http.NewResponse()
+		resp.SetStatus(200)
+		resp.SetBody([]byte(req.Path + " via " + req.Headers["Via"]))
+		return resp
+	})
+	proxy := startServer(t, http.NewForwardProxy().HandleRequest)
+
+	resp, _, _ := rawRequest(t, proxy, "GET http://"+backend+"/page?x=1 HTTP/1.1\r\n"+
+		"Host: "+backend+"\r\nProxy-Connection: keep-alive\r\n\r\n")
+	body, _ := resp.ReadBody()
+	if resp.StatusCode != 200 || string(body) != "/page?x=1 via 1.1 netrunner" {
+		t.Errorf("Expected proxied response, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestForwardProxyConnectTunnel(t *testing.T) {
+	backend := startBackend(t, "tunnelled", nil)
+	proxy := startServer(t, http.NewForwardProxy().HandleRequest)
+
+	resp, conn, reader := rawRequest(t, proxy, "CONNECT "+backend+" HTTP/1.1\r\nHost: "+backend+"\r\n\r\n")
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected 200 for CONNECT, got %d", resp.StatusCode)
+	}
+
+	// Speak HTTP to the backend through the tunnel.
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", backend)
+	tunnelled, err := http.ReadResponse(reader, "GET")
+	if err != nil {
+		t.Fatalf("Could not read tunnelled response: %v", err)
+	}
+	body, _ := tunnelled.ReadBody()
+	if string(body) != "tunnelled" {
+		t.Errorf("Expected body 'tunnelled', got %q", body)
+	}
+}
+
+func TestForwardProxyConnectDialsOnlyWhenHijacked(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	// A response that is never written, as when the client has gone or the
+	// request came over HTTP/2, must not leave an upstream connection open.
+	req := http.NewRequest()
+	req.Method = "CONNECT"
+	req.Path = listener.Addr().String()
+	if resp := http.NewForwardProxy().HandleRequest(req); resp.StatusCode != 200 || resp.Hijack == nil {
+		t.Fatalf("Expected a 200 tunnel response, got %d", resp.StatusCode)
+	}
+	select {
+	case conn := <-accepted:
+		conn.Close()
+		t.Fatal("Upstream dialed before the connection was handed over")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestForwardProxyTunnelIdleTimeout(t *testing.T) {
+	// The upstream accepts and then says nothing.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	proxy := http.NewForwardProxy()
+	proxy.TunnelIdleTimeout = 100 * time.Millisecond
+	addr := startServer(t, proxy.HandleRequest)
+
+	target := listener.Addr().String()
+	resp, _, reader := rawRequest(t, addr, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected 200 for CONNECT, got %d", resp.StatusCode)
+	}
+	start := time.Now()
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the idle tunnel to be closed, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Idle tunnel closed after %v", elapsed)
+	}
+}
+
+func TestForwardProxyAccessLists(t *testing.T) {
+	backend := startBackend(t, "ok", nil)
+	_, port, _ := net.SplitHostPort(backend)
+	backendPort, _ := strconv.Atoi(port)
+
+	proxy := http.NewForwardProxy()
+	proxy.AllowHosts = []string{"127.0.0.0/8", "*.example.com"}
+	proxy.AllowPorts = []int{443, backendPort}
+	proxy.DenyHosts = []string{"blocked.example.com"}
+	addr := startServer(t, proxy.HandleRequest)
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{backend, 200},
+		{"127.0.0.1:25", 403},
+		{"blocked.example.com:443", 403},
+		{"10.0.0.1:443", 403},
+	}
+	for _, tt := range tests {
+		resp, _, _ := rawRequest(t, addr, "CONNECT "+tt.target+" HTTP/1.1\r\nHost: "+tt.target+"\r\n\r\n")
+		if resp.StatusCode != tt.status {
+			t.Errorf("CONNECT %s: expected %d, got %d", tt.target, tt.status, resp.StatusCode)
+		}
+	}
+}
+
+func TestForwardProxyAuthentication(t *testing.T) {
+	backend := startBackend(t, "secret", nil)
+	proxy := http.NewForwardProxy()
+	proxy.Credentials = map[string]string{"ci": "hunter2"}
+	addr := startServer(t, proxy.HandleRequest)
+
+	request := "GET http://" + backend + "/ HTTP/1.1\r\nHost: " + backend + "\r\n"
+	resp, _, _ := rawRequest(t, addr, request+"\r\n")
+	if resp.StatusCode != 407 {
+		t.Fatalf("Expected 407 without credentials, got %d", resp.StatusCode)
+	}
+	if resp.Headers["Proxy-Authenticate"] != `Basic realm="Netrunner"` {
+		t.Errorf("Expected Basic challenge, got %q", resp.Headers["Proxy-Authenticate"])
+	}
+
+	wrong := base64.StdEncoding.EncodeToString([]byte("ci:wrong"))
+	resp, _, _ = rawRequest(t, addr, request+"Proxy-Authorization: Basic "+wrong+"\r\n\r\n")
+	if resp.StatusCode != 407 {
+		t.Errorf("Expected 407 with wrong password, got %d", resp.StatusCode)
+	}
+
+	right := base64.StdEncoding.EncodeToString([]byte("ci:hunter2"))
+	resp, _, _ = rawRequest(t, addr, request+"Proxy-Authorization: Basic "+right+"\r\n\r\n")
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected 200 with valid credentials, got %d", resp.StatusCode)
+	}
+}
+
+func TestForwardProxyChecksResolvedAddresses(t *testing.T) {
+	backend := startBackend(t, "internal", nil)
+	_, port, _ := net.SplitHostPort(backend)
+	target := "localhost:" + port
+
+	// A name that resolves into a denied range is denied, whether it is
+	// tunnelled or requested in absolute form.
+	proxy := http.NewForwardProxy()
+	proxy.DenyHosts = []string{"127.0.0.0/8", "::1"}
+	addr := startServer(t, proxy.HandleRequest)
+
+	resp, _, _ := rawRequest(t, addr, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+	if resp.StatusCode != 403 {
+		t.Errorf("CONNECT %s: expected 403, got %d", target, resp.StatusCode)
+	}
+	resp, _, _ = rawRequest(t, addr, "GET http://"+target+"/ HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+	if resp.StatusCode != 403 {
+		t.Errorf("GET http://%s/: expected 403, got %d", target, resp.StatusCode)
+	}
+
+	// An allowed range admits names that resolve into it.
+	proxy = http.NewForwardProxy()
+	proxy.AllowHosts = []string{"127.0.0.0/8"}
+	addr = startServer(t, proxy.HandleRequest)
+
+	resp, _, _ = rawRequest(t, addr, "GET http://"+target+"/ HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+	body, _ := resp.ReadBody()
+	if resp.StatusCode != 200 || string(body) != "internal" {
+		t.Errorf("GET http://%s/ with 127.0.0.0/8 allowed: got %d %q", target, resp.StatusCode, body)
+	}
+}
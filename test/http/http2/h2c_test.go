@@ -0,0 +1,147 @@
+package http2_test
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+	"github.com/appyzdl/Netrunner/pkg/http/http2"
+	"github.com/appyzdl/Netrunner/pkg/http/http2/hpack"
+)
+
+// startH2CServer runs a plaintext server with h2c enabled in front of a
+// Router, as cmd/server does.
+func startH2CServer(t *testing.T) string {
+	t.Helper()
+
+	router := http.NewRouter()
+	router.AddRoute("POST", "/echo", echoHandler)
+	router.AddRoute("GET", "/hello", echoHandler)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	server := http.NewServer(listener.Addr().String(), router.HandleRequest)
+	http2.ConfigureH2C(server, nil)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().String()
+}
+
+// readStream collects the response status and body sent on a stream.
+func readStream(t *testing.T, reader *bufio.Reader, streamID uint32) (string, string) {
+	t.Helper()
+
+	decoder := hpack.NewDecoder(hpack.DefaultTableSize)
+	var status, body string
+	for {
+		f, err := http2.ReadFrame(reader, 1<<20)
+		if err != nil {
+			t.Fatalf("Could not read frame: %v", err)
+		}
+		if f.StreamID != streamID {
+			continue
+		}
+		switch f.Type {
+		case http2.FrameHeaders:
+			fields, err := decoder.Decode(f.Payload)
+			if err != nil {
+				t.Fatalf("Could not decode headers: %v", err)
+			}
+			status = fields[0].Value
+		case http2.FrameData:
+			body += string(f.Payload)
+		}
+		if f.Has(http2.FlagEndStream) {
+			return status, body
+		}
+	}
+}
+
+func TestH2CPriorKnowledge(t *testing.T) {
+	addr := startH2CServer(t)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	block := hpack.NewEncoder().AppendFields(nil, []hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/hello"},
+		{Name: ":authority", Value: addr},
+	})
+	out := []byte(http2.Preface)
+	out = http2.AppendFrame(out, http2.FrameSettings, 0, 0, nil)
+	out = http2.AppendFrame(out, http2.FrameHeaders, http2.FlagEndHeaders|http2.FlagEndStream, 1, block)
+	conn.Write(out)
+
+	// The router must serve it rather than redirect to HTTPS.
+	status, _ := readStream(t, bufio.NewReader(conn), 1)
+	if status != "200" {
+		t.Errorf("Expected 200 from the router, got %s", status)
+	}
+}
+
+func TestH2CUpgrade(t *testing.T) {
+	addr := startH2CServer(t)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	settings := []byte{0, byte(http2.SettingEnablePush), 0, 0, 0, 0}
+	fmt.Fprintf(conn, "POST /echo HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade, HTTP2-Settings\r\n"+
+		"Upgrade: h2c\r\nHTTP2-Settings: %s\r\nContent-Length: 5\r\n\r\nhello",
+		addr, base64.RawURLEncoding.EncodeToString(settings))
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, "POST")
+	if err != nil || resp.StatusCode != 101 || resp.Headers["Upgrade"] != "h2c" {
+		t.Fatalf("Expected 101 Switching Protocols, got %v (%v)", resp, err)
+	}
+	conn.Write(append([]byte(http2.Preface), http2.AppendFrame(nil, http2.FrameSettings, 0, 0, nil)...))
+
+	// The upgraded request is answered on stream 1.
+	status, body := readStream(t, reader, 1)
+	if status != "200" || body != "hello" {
+		t.Errorf("Expected 200 'hello' on stream 1, got %s %q", status, body)
+	}
+}
+
+func TestH2CServerStillServesHTTP1(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	server := http.NewServer(listener.Addr().String(), echoHandler)
+	http2.ConfigureH2C(server, nil)
+	go server.Serve(listener)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Shorter than the HTTP/2 preface, so detection must not wait for more.
+	io.WriteString(conn, "PUT / HTTP/1.0\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), "PUT")
+	if err != nil || resp.StatusCode != 200 || resp.Headers["X-Method"] != "PUT" {
+		t.Errorf("Expected HTTP/1.0 PUT to be served, got %v (%v)", resp, err)
+	}
+}
@@ -0,0 +1,574 @@
+package http2_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	nethttp "net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+	"github.com/appyzdl/Netrunner/pkg/http/http2"
+	"github.com/appyzdl/Netrunner/pkg/http/http2/hpack"
+	"github.com/appyzdl/Netrunner/pkg/metrics"
+)
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Could not create certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// startServer runs a TLS Netrunner server with HTTP/2 enabled.
+func startServer(t *testing.T, handler http.HandlerFunc) (*http.Server, string) {
+	t.Helper()
+
+	server := http.NewServer("127.0.0.1:0", handler)
+	server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}}
+	http2.ConfigureServer(server, nil)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", server.TLSConfig)
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return server, listener.Addr().String()
+}
+
+func newClient() *nethttp.Client {
+	return &nethttp.Client{
+		Timeout: 10 * time.Second,
+		Transport: &nethttp.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		},
+	}
+}
+
+func echoHandler(req *http.Request) *http.Response {
+	body, err := req.ReadBody()
+	if err != nil {
+		return http.InternalServerErrorResponseFor(req)
+	}
+	resp := http.NewResponse()
+	resp.StatusCode = 200
+	resp.SetHeader("Content-Type", "text/plain")
+	resp.SetHeader("X-Method", req.Method)
+	resp.SetHeader("X-Path", req.Path)
+	resp.SetHeader("X-Host", req.Headers["Host"])
+	resp.SetCookie(&http.Cookie{Name: "session", Value: "abc"})
+	resp.SetCookie(&http.Cookie{Name: "theme", Value: "dark"})
+	resp.SetBody(body)
+	return resp
+}
+
+func TestRequestsOverHTTP2(t *testing.T) {
+	_, addr := startServer(t, echoHandler)
+	client := newClient()
+
+	resp, err := client.Get("https://" + addr + "/hello?x=1")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("Expected HTTP/2, got %s", resp.Proto)
+	}
+	if resp.Header.Get("X-Path") != "/hello?x=1" || resp.Header.Get("X-Host") != addr {
+		t.Errorf("Unexpected request mapping: %v", resp.Header)
+	}
+	if len(resp.Cookies()) != 2 {
+		t.Errorf("Expected 2 cookies, got %v", resp.Cookies())
+	}
+
+	// Larger than every flow-control window, in both directions.
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 300000)
+	resp, err = client.Post("https://"+addr+"/echo", "text/plain", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Equal(body, payload) {
+		t.Errorf("Echoed body differs: got %d bytes, want %d", len(body), len(payload))
+	}
+
+	req, _ := nethttp.NewRequest("HEAD", "https://"+addr+"/", nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("HEAD failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("X-Method") != "HEAD" {
+		t.Errorf("Expected HEAD to reach the handler")
+	}
+}
+
+func TestConnectionInfoOverHTTP2(t *testing.T) {
+	_, addr := startServer(t, func(req *http.Request) *http.Response {
+		resp := http.NewResponse()
+		resp.StatusCode = 200
+		resp.SetHeader("X-Local", req.LocalAddr)
+		resp.SetHeader("X-Conn", fmt.Sprintf("%d/%d", req.ConnID, req.ConnSeq))
+		return resp
+	})
+	client := newClient()
+
+	var conns []string
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get("https://" + addr + "/")
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.Header.Get("X-Local") != addr {
+			t.Errorf("LocalAddr = %q, want %q", resp.Header.Get("X-Local"), addr)
+		}
+		conns = append(conns, resp.Header.Get("X-Conn"))
+	}
+	id, _, _ := strings.Cut(conns[0], "/")
+	if conns[0] != id+"/1" || conns[1] != id+"/2" {
+		t.Errorf("Streams on one connection got %v, want the same ID with sequence 1 and 2", conns)
+	}
+}
+
+func TestTLSHandshakeTimingOverHTTP2(t *testing.T) {
+	_, addr := startServer(t, http.NewServerTimingMiddleware(http.ServerTimingOptions{Enabled: true})(func(req *http.Request) *http.Response {
+		resp := http.NewResponse()
+		resp.StatusCode = 200
+		return resp
+	}))
+	client := newClient()
+
+	var timings []string
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get("https://" + addr + "/")
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.ProtoMajor != 2 {
+			t.Fatalf("Got %s, want HTTP/2", resp.Proto)
+		}
+		timings = append(timings, resp.Header.Get("Server-Timing"))
+	}
+	// Only the first stream waited for the handshake.
+	if !strings.Contains(timings[0], `tls;dur=`) || strings.Contains(timings[1], "tls;") {
+		t.Errorf("Server-Timing on two streams = %q, want tls only on the first", timings)
+	}
+}
+
+func TestEarlyHintsOverHTTP2(t *testing.T) {
+	_, addr := startServer(t, func(req *http.Request) *http.Response {
+		req.WriteEarlyHints("</style.css>; rel=preload; as=style")
+		return echoHandler(req)
+	})
+
+	var hints []string
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			hints = append(hints, fmt.Sprintf("%d %s", code, header.Get("Link")))
+			return nil
+		},
+	}
+	req, _ := nethttp.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), "GET", "https://"+addr+"/", nil)
+	resp, err := newClient().Do(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if len(hints) != 1 || hints[0] != "103 </style.css>; rel=preload; as=style" || resp.StatusCode != 200 {
+		t.Errorf("Expected one 103 before the 200, got %v then %d", hints, resp.StatusCode)
+	}
+}
+
+func TestMultiplexedStreams(t *testing.T) {
+	var mu sync.Mutex
+	remotes := make(map[string]bool)
+	release := make(chan struct{})
+	_, addr := startServer(t, func(req *http.Request) *http.Response {
+		mu.Lock()
+		remotes[req.RemoteAddr] = true
+		mu.Unlock()
+		<-release
+		return echoHandler(req)
+	})
+	client := newClient()
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := client.Post("https://"+addr+"/", "text/plain", strings.NewReader(fmt.Sprint(i)))
+			if err != nil {
+				errs <- err
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != fmt.Sprint(i) {
+				errs <- fmt.Errorf("stream %d got %q", i, body)
+			}
+		}(i)
+	}
+	// All requests must be in flight at once for the handlers to finish.
+	time.Sleep(200 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if len(remotes) != 1 {
+		t.Errorf("Expected all streams on one connection, got %d", len(remotes))
+	}
+}
+
+func TestStreamingResponse(t *testing.T) {
+	_, addr := startServer(t, func(req *http.Request) *http.Response {
+		pr, pw := io.Pipe()
+		go func() {
+			for i := 0; i < 3; i++ {
+				fmt.Fprintf(pw, "tick %d\n", i)
+				time.Sleep(10 * time.Millisecond)
+			}
+			pw.Close()
+		}()
+		resp := http.NewResponse()
+		resp.StatusCode = 200
+		resp.BodyReader = pr
+		return resp
+	})
+
+	resp, err := newClient().Get("https://" + addr + "/stream")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "tick 0\ntick 1\ntick 2\n" {
+		t.Errorf("Unexpected streamed body %q", body)
+	}
+}
+
+func TestGoAwayOnShutdown(t *testing.T) {
+	server, addr := startServer(t, echoHandler)
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}})
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	if conn.ConnectionState().NegotiatedProtocol != "h2" {
+		t.Fatalf("ALPN did not select h2")
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write(append([]byte(http2.Preface), http2.AppendFrame(nil, http2.FrameSettings, 0, 0, nil)...))
+
+	reader := bufio.NewReader(conn)
+	f, err := http2.ReadFrame(reader, 1<<20)
+	if err != nil || f.Type != http2.FrameSettings {
+		t.Fatalf("Expected server SETTINGS, got %v (%v)", f, err)
+	}
+
+	go server.Shutdown(context.Background())
+
+	for {
+		f, err := http2.ReadFrame(reader, 1<<20)
+		if err != nil {
+			t.Fatalf("Connection ended without GOAWAY: %v", err)
+		}
+		if f.Type == http2.FrameGoAway {
+			if code := http2.ErrCode(binary.BigEndian.Uint32(f.Payload[4:])); code != http2.ErrCodeNo {
+				t.Errorf("Expected NO_ERROR, got %v", code)
+			}
+			return
+		}
+	}
+}
+
+func TestHPACK(t *testing.T) {
+	// RFC 7541 C.4.1: "www.example.com" Huffman-encoded.
+	encoded := []byte{0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff}
+	decoded, err := hpack.HuffmanDecode(encoded)
+	if err != nil || string(decoded) != "www.example.com" {
+		t.Errorf("Huffman decode gave %q (%v)", decoded, err)
+	}
+	if got := hpack.AppendHuffman(nil, "www.example.com"); !bytes.Equal(got, encoded) {
+		t.Errorf("Huffman encode gave %x", got)
+	}
+
+	encoder := hpack.NewEncoder()
+	decoder := hpack.NewDecoder(hpack.DefaultTableSize)
+	fields := []hpack.HeaderField{
+		{Name: ":status", Value: "200"},
+		{Name: "content-type", Value: "text/html; charset=utf-8"},
+		{Name: "x-custom", Value: "value"},
+		{Name: "authorization", Value: "secret", Sensitive: true},
+	}
+	first := encoder.AppendFields(nil, fields)
+	second := encoder.AppendFields(nil, fields)
+	if len(second) >= len(first) {
+		t.Errorf("Expected the dynamic table to shrink the repeated block (%d >= %d)", len(second), len(first))
+	}
+	for _, block := range [][]byte{first, second} {
+		got, err := decoder.Decode(block)
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if len(got) != len(fields) {
+			t.Fatalf("Expected %d fields, got %v", len(fields), got)
+		}
+		for i := range fields {
+			if got[i].Name != fields[i].Name || got[i].Value != fields[i].Value {
+				t.Errorf("Field %d: expected %v, got %v", i, fields[i], got[i])
+			}
+		}
+	}
+
+	if _, err := decoder.Decode([]byte{0xff, 0xff}); err == nil {
+		t.Error("Expected an error for a truncated index")
+	}
+}
+
+func TestMetricsOverHTTP2(t *testing.T) {
+	reg := metrics.NewRegistry()
+	m := http.NewMetrics(reg)
+	handler := m.Middleware(func(req *http.Request) *http.Response {
+		resp := http.NewResponse()
+		resp.SetStatus(200)
+		if req.TLS == nil {
+			resp.SetStatus(500)
+		}
+		return resp
+	})
+
+	server := http.NewServer("127.0.0.1:0", handler)
+	server.Metrics = m
+	server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}}
+	http2.ConfigureServer(server, nil)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", server.TLSConfig)
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	addr := listener.Addr().String()
+
+	// A client that isn't speaking TLS fails the handshake.
+	plain, _ := net.Dial("tcp", addr)
+	plain.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	plain.SetReadDeadline(time.Now().Add(5 * time.Second))
+	io.ReadAll(plain)
+	plain.Close()
+
+	client := newClient()
+	resp, err := client.Get("https://" + addr + "/")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 || resp.StatusCode != 200 {
+		t.Fatalf("Got %s %d, want HTTP/2 with the TLS state intact", resp.Proto, resp.StatusCode)
+	}
+
+	var out bytes.Buffer
+	reg.WriteText(&out)
+	for _, line := range []string{
+		`netrunner_tls_handshakes_total{result="failure"} 1`,
+		`netrunner_tls_handshakes_total{result="success"} 1`,
+		`netrunner_http_requests_total{method="GET",route="none",status="200"} 1`,
+		`netrunner_http_connections{state="upgraded"} 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("Missing %q in:\n%s", line, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "netrunner_http_sent_bytes_total 0\n") {
+		t.Errorf("HTTP/2 bytes weren't counted:\n%s", out.String())
+	}
+}
+
+// h2cConn opens a prior-knowledge h2c connection to a server with conf.
+func h2cConn(t *testing.T, handler http.HandlerFunc, conf *http2.Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	server := http.NewServer(listener.Addr().String(), handler)
+	http2.ConfigureH2C(server, conf)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write(http2.AppendFrame([]byte(http2.Preface), http2.FrameSettings, 0, 0, nil))
+	return conn, bufio.NewReader(conn)
+}
+
+// streamOutcome reads frames until streamID ends and returns its :status,
+// or the error code if it was reset.
+func streamOutcome(t *testing.T, reader *bufio.Reader, decoder *hpack.Decoder, streamID uint32) (string, http2.ErrCode) {
+	t.Helper()
+
+	var status string
+	for {
+		f, err := http2.ReadFrame(reader, 1<<20)
+		if err != nil {
+			t.Fatalf("Could not read frame: %v", err)
+		}
+		if f.Type == http2.FrameHeaders {
+			// Decode every block to keep the dynamic table in step.
+			fields, err := decoder.Decode(f.Payload)
+			if err != nil {
+				t.Fatalf("Could not decode headers: %v", err)
+			}
+			if f.StreamID == streamID && status == "" {
+				status = fields[0].Value
+			}
+		}
+		if f.StreamID != streamID {
+			continue
+		}
+		if f.Type == http2.FrameRSTStream {
+			return "", http2.ErrCode(binary.BigEndian.Uint32(f.Payload))
+		}
+		if f.Has(http2.FlagEndStream) {
+			return status, http2.ErrCodeNo
+		}
+	}
+}
+
+func requestBlock(encoder *hpack.Encoder, path string, extra ...hpack.HeaderField) []byte {
+	return encoder.AppendFields(nil, append([]hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: path},
+		{Name: ":authority", Value: "test"},
+	}, extra...))
+}
+
+func TestHeaderListSizeEnforcedWhileDecoding(t *testing.T) {
+	conf := http2.NewServer()
+	conf.MaxHeaderListSize = 16 << 10
+	conn, reader := h2cConn(t, echoHandler, conf)
+	encoder, decoder := hpack.NewEncoder(), hpack.NewDecoder(hpack.DefaultTableSize)
+
+	// One 1000-byte field added to the dynamic table, then referenced
+	// 2000 times by its one-byte index: 2 MB of headers in a 3 KB block.
+	block := requestBlock(encoder, "/hello", hpack.HeaderField{Name: "x-bomb", Value: strings.Repeat("a", 1000)})
+	for i := 0; i < 2000; i++ {
+		block = append(block, 0x80|62)
+	}
+
+	limited := hpack.NewDecoder(hpack.DefaultTableSize)
+	limited.MaxHeaderListSize = 16 << 10
+	if fields, err := limited.Decode(block); err != hpack.ErrHeaderListTooLarge || fields != nil {
+		t.Errorf("Expected ErrHeaderListTooLarge and no fields, got %d fields (%v)", len(fields), err)
+	}
+
+	conn.Write(http2.AppendFrame(nil, http2.FrameHeaders, http2.FlagEndHeaders|http2.FlagEndStream, 1, block))
+	if status, code := streamOutcome(t, reader, decoder, 1); status != "431" {
+		t.Errorf("Expected 431, got status %q (reset %v)", status, code)
+	}
+
+	// The connection and its HPACK state survive.
+	conn.Write(http2.AppendFrame(nil, http2.FrameHeaders, http2.FlagEndHeaders|http2.FlagEndStream, 3, requestBlock(encoder, "/hello")))
+	if status, code := streamOutcome(t, reader, decoder, 3); status != "200" {
+		t.Errorf("Expected 200 after the rejected request, got status %q (reset %v)", status, code)
+	}
+}
+
+func TestResetStreamsCountUntilHandlersReturn(t *testing.T) {
+	release := make(chan struct{})
+	cancelled := make(chan struct{}, 4)
+	handler := func(req *http.Request) *http.Response {
+		if req.Path == "/slow" {
+			<-req.Context().Done()
+			cancelled <- struct{}{}
+			// Still running after the reset, as a handler that doesn't
+			// check its context would be.
+			<-release
+		}
+		return echoHandler(req)
+	}
+	conf := http2.NewServer()
+	conf.MaxConcurrentStreams = 2
+	conn, reader := h2cConn(t, handler, conf)
+	encoder, decoder := hpack.NewEncoder(), hpack.NewDecoder(hpack.DefaultTableSize)
+
+	rst := binary.BigEndian.AppendUint32(nil, uint32(http2.ErrCodeCancel))
+	var out []byte
+	for _, id := range []uint32{1, 3} {
+		out = http2.AppendFrame(out, http2.FrameHeaders, http2.FlagEndHeaders|http2.FlagEndStream, id, requestBlock(encoder, "/slow"))
+		out = http2.AppendFrame(out, http2.FrameRSTStream, 0, id, rst)
+	}
+	out = http2.AppendFrame(out, http2.FrameHeaders, http2.FlagEndHeaders|http2.FlagEndStream, 5, requestBlock(encoder, "/hello"))
+	conn.Write(out)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-cancelled:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Reset did not cancel the handler's context")
+		}
+	}
+	if _, code := streamOutcome(t, reader, decoder, 5); code != http2.ErrCodeRefusedStream {
+		t.Errorf("Expected REFUSED_STREAM while reset handlers still run, got %v", code)
+	}
+
+	close(release)
+	deadline := time.Now().Add(2 * time.Second)
+	for id := uint32(7); ; id += 2 {
+		conn.Write(http2.AppendFrame(nil, http2.FrameHeaders, http2.FlagEndHeaders|http2.FlagEndStream, id, requestBlock(encoder, "/hello")))
+		status, _ := streamOutcome(t, reader, decoder, id)
+		if status == "200" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Streams were still refused after the handlers returned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
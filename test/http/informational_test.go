@@ -0,0 +1,53 @@
+package http_test
+
+import (
+	"testing"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+)
+
+func earlyHintsHandler(req *http.Request) *http.Response {
+	req.WriteEarlyHints("</style.css>; rel=preload; as=style", "</app.js>; rel=preload; as=script")
+	req.WriteInformational(102, nil)
+
+	resp := http.NewResponse()
+	resp.StatusCode = 200
+	resp.SetBody([]byte("<html></html>"))
+	return resp
+}
+
+func TestEarlyHints(t *testing.T) {
+	addr := startServer(t, earlyHintsHandler)
+	resp, _, reader := rawRequest(t, addr, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+
+	if resp.StatusCode != 103 {
+		t.Fatalf("Expected 103 Early Hints first, got %d", resp.StatusCode)
+	}
+	if resp.Headers["Link"] != "</style.css>; rel=preload; as=style, </app.js>; rel=preload; as=script" {
+		t.Errorf("Unexpected Link header %q", resp.Headers["Link"])
+	}
+	for _, expected := range []int{102, 200} {
+		resp, err := http.ReadResponse(reader, "GET")
+		if err != nil || resp.StatusCode != expected {
+			t.Fatalf("Expected %d, got %v (%v)", expected, resp, err)
+		}
+	}
+}
+
+func TestInformationalSkippedForHTTP10(t *testing.T) {
+	addr := startServer(t, earlyHintsHandler)
+	resp, _, _ := rawRequest(t, addr, "GET / HTTP/1.0\r\n\r\n")
+
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected HTTP/1.0 clients to get only the final response, got %d", resp.StatusCode)
+	}
+}
+
+func TestWriteInformationalRejectsFinalCodes(t *testing.T) {
+	req := http.NewRequest()
+	for _, code := range []int{101, 200, 404} {
+		if err := req.WriteInformational(code, nil); err == nil {
+			t.Errorf("Expected an error for status %d", code)
+		}
+	}
+}
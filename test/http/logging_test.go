@@ -0,0 +1,128 @@
+package http_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+)
+
+// logBuffer collects JSON log records written from server goroutines.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) records(t *testing.T) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Bad log line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func (b *logBuffer) find(t *testing.T, msg string) map[string]any {
+	t.Helper()
+	for _, record := range b.records(t) {
+		if record["msg"] == msg {
+			return record
+		}
+	}
+	t.Fatalf("No %q record in:\n%s", msg, b.buf.String())
+	return nil
+}
+
+func newTestLogger(level slog.Level) (*slog.Logger, *logBuffer) {
+	buf := &logBuffer{}
+	return slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: level})), buf
+}
+
+func TestServerLogsRedactedRequests(t *testing.T) {
+	logger, logs := newTestLogger(slog.LevelDebug)
+	server := startLoggingServer(t, logger, http.LoggingMiddleware(func(req *http.Request) *http.Response {
+		resp := http.NewResponse()
+		resp.StatusCode = 200
+		return resp
+	}))
+
+	rawRequest(t, server, "GET /secret HTTP/1.1\r\nHost: test\r\nAuthorization: Bearer hunter2\r\nCookie: session=abc\r\nX-Trace: visible\r\n\r\n")
+
+	received := logs.find(t, "Received request")
+	headers := received["headers"].(map[string]any)
+	if headers["Authorization"] != "[REDACTED]" || headers["Cookie"] != "[REDACTED]" {
+		t.Errorf("Sensitive headers were logged: %v", headers)
+	}
+	if headers["X-Trace"] != "visible" {
+		t.Errorf("X-Trace = %v, want it logged", headers["X-Trace"])
+	}
+
+	handled := logs.find(t, "Request handled")
+	if handled["method"] != "GET" || handled["path"] != "/secret" || handled["status"] != float64(200) || handled["remote"] == nil {
+		t.Errorf("Request attributes missing: %v", handled)
+	}
+}
+
+func TestServerDebugOutputSilenced(t *testing.T) {
+	logger, logs := newTestLogger(slog.LevelInfo)
+	server := startLoggingServer(t, logger, func(req *http.Request) *http.Response {
+		resp := http.NewResponse()
+		resp.StatusCode = 200
+		return resp
+	})
+	rawRequest(t, server, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	if records := logs.records(t); len(records) != 0 {
+		t.Errorf("Info level logged %v", records)
+	}
+}
+
+func TestRouterLoggerAndLevels(t *testing.T) {
+	logger, logs := newTestLogger(slog.LevelInfo)
+	router := http.NewRouter()
+	router.Logger = logger.With("router", "api")
+	router.Use(http.LoggingMiddleware)
+	router.AddRouteE("GET", "/static/fail", func(req *http.Request) (*http.Response, error) {
+		return nil, http.NewHTTPError(503, "down")
+	})
+
+	router.HandleRequest(&http.Request{Method: "GET", Path: "/static/fail", Version: "HTTP/1.1", Headers: map[string]string{}})
+	record := logs.find(t, "Request handled")
+	if record["level"] != "ERROR" || record["router"] != "api" || record["status"] != float64(503) {
+		t.Errorf("Got %v, want an ERROR record from the router's logger", record)
+	}
+}
+
+func startLoggingServer(t *testing.T, logger *slog.Logger, handler http.HandlerFunc) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	server := http.NewServer(listener.Addr().String(), handler)
+	server.Logger = logger
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return listener.Addr().String()
+}
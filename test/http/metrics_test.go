@@ -0,0 +1,97 @@
+package http_test
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+	"github.com/appyzdl/Netrunner/pkg/metrics"
+)
+
+func TestServerMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	m := http.NewMetrics(reg)
+
+	router := http.NewRouter()
+	router.AllowInsecure = true
+	router.AddRoute("GET", "/users/*", func(req *http.Request) *http.Response {
+		resp := http.NewResponse()
+		resp.SetStatus(200)
+		resp.SetBody([]byte("user"))
+		return resp
+	})
+	router.AddRoute("GET", "/metrics", http.MetricsHandler(reg))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	server := http.NewServer(listener.Addr().String(), m.Middleware(router.HandleRequest))
+	server.Metrics = m
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	addr := listener.Addr().String()
+
+	// Two requests on a kept-alive connection, which then stays idle.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for _, path := range []string{"/users/1", "/users/2"} {
+		conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: test\r\n\r\n"))
+		resp, err := http.ReadResponse(reader, "GET")
+		if err != nil {
+			t.Fatalf("Could not read response: %v", err)
+		}
+		resp.ReadBody()
+	}
+
+	rawRequest(t, addr, "BREW /pot HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	garbage, _ := net.Dial("tcp", addr)
+	garbage.Write([]byte("nonsense\r\n\r\n"))
+	garbage.SetReadDeadline(time.Now().Add(5 * time.Second))
+	bufio.NewReader(garbage).ReadString('\n')
+	garbage.Close()
+
+	resp, _, _ := rawRequest(t, addr, "GET /metrics HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	if resp.Headers["Content-Type"] != metrics.ContentType {
+		t.Errorf("Content-Type = %q", resp.Headers["Content-Type"])
+	}
+	body, _ := resp.ReadBody()
+	text := string(body)
+
+	for _, line := range []string{
+		`netrunner_http_requests_total{method="GET",route="/users/*",status="200"} 2`,
+		`netrunner_http_requests_total{method="OTHER",route="none",status="404"} 1`,
+		`netrunner_http_request_duration_seconds_count{method="GET",route="/users/*",status="200"} 2`,
+		`netrunner_http_parse_errors_total{reason="malformed"} 1`,
+		`netrunner_http_connections{state="idle"} 1`,
+		`netrunner_http_requests_in_flight 1`, // the scrape itself
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("Missing %q in:\n%s", line, text)
+		}
+	}
+	if strings.Contains(text, "netrunner_http_received_bytes_total 0\n") || !strings.Contains(text, "netrunner_http_sent_bytes_total ") {
+		t.Errorf("Expected byte counts in:\n%s", text)
+	}
+}
+
+func TestInFlightAfterPanic(t *testing.T) {
+	reg := metrics.NewRegistry()
+	handler := http.NewMetrics(reg).Middleware(panicHandler)
+	func() {
+		defer func() { recover() }()
+		handler(&http.Request{Method: "GET", Path: "/"})
+	}()
+
+	body := http.MetricsHandler(reg)(&http.Request{Method: "GET", Path: "/metrics"}).Body
+	if !strings.Contains(string(body), "netrunner_http_requests_in_flight 0\n") {
+		t.Errorf("In-flight gauge not lowered after a panic:\n%s", body)
+	}
+}
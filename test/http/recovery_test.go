@@ -0,0 +1,91 @@
+package http_test
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+)
+
+func panicHandler(req *http.Request) *http.Response {
+	panic("boom")
+}
+
+func TestRecoveryReturns500(t *testing.T) {
+	handler := http.RecoveryMiddleware(panicHandler)
+	resp := handler(&http.Request{Method: "GET", Path: "/", Headers: map[string]string{"X-Request-Id": "abc"}})
+	if resp.StatusCode != 500 {
+		t.Fatalf("Got status %d, want 500", resp.StatusCode)
+	}
+	if strings.Contains(string(resp.Body), "boom") {
+		t.Errorf("Production response leaks the panic: %s", resp.Body)
+	}
+}
+
+func TestRecoveryDebugPage(t *testing.T) {
+	handler := http.NewRecoveryMiddleware(true)(panicHandler)
+
+	resp := handler(&http.Request{Method: "GET", Path: "/", Headers: map[string]string{"Accept": "text/html"}})
+	if resp.StatusCode != 500 || !strings.Contains(string(resp.Body), "panic: boom") || !strings.Contains(string(resp.Body), "<pre>") {
+		t.Errorf("Debug page missing panic details: %s", resp.Body)
+	}
+
+	resp = handler(&http.Request{Method: "GET", Path: "/", Headers: map[string]string{"Accept": "application/json"}})
+	var problem map[string]any
+	if err := json.Unmarshal(resp.Body, &problem); err != nil {
+		t.Fatalf("Body is not JSON: %v", err)
+	}
+	if problem["detail"] != "panic: boom" || !strings.Contains(problem["stack"].(string), "panicHandler") {
+		t.Errorf("Debug problem details = %v", problem)
+	}
+}
+
+func TestRecoveryRepanicsAbort(t *testing.T) {
+	handler := http.RecoveryMiddleware(func(req *http.Request) *http.Response {
+		panic(http.ErrAbortHandler)
+	})
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("Recovered %v, want ErrAbortHandler", v)
+		}
+	}()
+	handler(&http.Request{Method: "GET", Path: "/", Headers: map[string]string{}})
+	t.Error("ErrAbortHandler was swallowed")
+}
+
+func TestServerSurvivesHandlerPanic(t *testing.T) {
+	addr := startServer(t, func(req *http.Request) *http.Response {
+		if req.Path == "/panic" {
+			panic("boom")
+		}
+		if req.Path == "/abort" {
+			panic(http.ErrAbortHandler)
+		}
+		resp := http.NewResponse()
+		resp.StatusCode = 200
+		resp.SetBody([]byte("ok"))
+		return resp
+	})
+
+	for _, path := range []string{"/panic", "/abort"} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Could not connect: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: test\r\n\r\n")
+		if data, _ := io.ReadAll(conn); len(data) != 0 {
+			t.Errorf("%s: got %q, want the connection closed without a response", path, data)
+		}
+		conn.Close()
+	}
+
+	resp, _, _ := rawRequest(t, addr, "GET /ok HTTP/1.1\r\nHost: test\r\n\r\n")
+	if resp.StatusCode != 200 {
+		t.Errorf("Server stopped serving after a panic: got %d", resp.StatusCode)
+	}
+}
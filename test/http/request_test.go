@@ -12,7 +12,7 @@ func TestParseRequest(t *testing.T) {
 		"User-Agent: Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36\r\n" +
 		"\r\n"
 
-	request, err := http.ParseRequest([]byte(rawRequest))
+	request, err := http.ParseRequest([]byte(rawRequest), nil)
 	if err != nil {
 		t.Fatalf("Failed to parse request: %v", err)
 	}
@@ -51,7 +51,7 @@ func TestParseRequestWithBody(t *testing.T) {
 		"\r\n" +
 		"username=johndoe&password=123"
 
-	request, err := http.ParseRequest([]byte(rawRequest))
+	request, err := http.ParseRequest([]byte(rawRequest), nil)
 	if err != nil {
 		t.Fatalf("Failed to parse request: %v", err)
 	}
@@ -69,3 +69,30 @@ func TestParseRequestWithBody(t *testing.T) {
 		t.Errorf("Expected body %q, got %q", expectedBody, string(request.Body))
 	}
 }
+
+func TestRouterLimitsRequestBody(t *testing.T) {
+	router := http.NewRouter()
+	router.AllowInsecure = true
+	router.MaxBodyBytes = 16
+	router.AddRoute("POST", "/upload", func(req *http.Request) *http.Response {
+		resp := http.NewResponse()
+		resp.SetStatus(200)
+		resp.SetBody(req.Body)
+		return resp
+	})
+	addr := startServer(t, router.HandleRequest)
+
+	for _, tt := range []struct {
+		name, request string
+		want          int
+	}{
+		{"small", "POST /upload HTTP/1.1\r\nHost: test\r\nContent-Length: 5\r\n\r\nhello", 200},
+		{"declared too large", "POST /upload HTTP/1.1\r\nHost: test\r\nContent-Length: 100\r\n\r\n", 413},
+		{"chunked too large", "POST /upload HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"10\r\n0123456789abcdef\r\n10\r\n0123456789abcdef\r\n0\r\n\r\n", 413},
+	} {
+		if resp, _, _ := rawRequest(t, addr, tt.request); resp.StatusCode != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+}
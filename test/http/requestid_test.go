@@ -0,0 +1,164 @@
+package http_test
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+)
+
+var ulidPattern = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+
+func TestRequestIDAssigner(t *testing.T) {
+	assigner, err := http.NewRequestIDAssigner("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("NewRequestIDAssigner failed: %v", err)
+	}
+
+	var seen string
+	handler := assigner.Middleware(func(req *http.Request) *http.Response {
+		seen = http.RequestIDFromContext(req.Context())
+		if req.RequestID() != seen || req.Headers["X-Request-Id"] != seen {
+			t.Errorf("ID %q not on the request: %q, %q", seen, req.RequestID(), req.Headers["X-Request-Id"])
+		}
+		resp := http.NewResponse()
+		resp.SetStatus(200)
+		return resp
+	})
+
+	tests := []struct {
+		name   string
+		remote string
+		header string
+		keep   bool
+	}{
+		{"none sent", "10.0.0.1:1234", "", false},
+		{"trusted", "10.0.0.1:1234", "lb-7f3a", true},
+		{"untrusted", "192.0.2.1:1234", "spoofed", false},
+		{"trusted but invalid", "10.0.0.1:1234", "bad id", false},
+		{"trusted but too long", "10.0.0.1:1234", strings.Repeat("a", 129), false},
+	}
+	for _, tt := range tests {
+		req := &http.Request{Method: "GET", Path: "/", RemoteAddr: tt.remote, Headers: map[string]string{}}
+		if tt.header != "" {
+			req.Headers["X-Request-Id"] = tt.header
+		}
+		resp := handler(req)
+
+		if tt.keep && seen != tt.header {
+			t.Errorf("%s: got ID %q, want %q", tt.name, seen, tt.header)
+		}
+		if !tt.keep && !ulidPattern.MatchString(seen) {
+			t.Errorf("%s: got ID %q, want a new ULID", tt.name, seen)
+		}
+		if resp.Headers["X-Request-Id"] != seen {
+			t.Errorf("%s: response header %q, want %q", tt.name, resp.Headers["X-Request-Id"], seen)
+		}
+	}
+}
+
+func TestNewULID(t *testing.T) {
+	seen := map[string]bool{}
+	previous := ""
+	for i := 0; i < 1000; i++ {
+		id := http.NewULID()
+		if !ulidPattern.MatchString(id) || seen[id] {
+			t.Fatalf("Bad or repeated ULID %q", id)
+		}
+		// The timestamp prefix never goes backwards.
+		if id[:10] < previous {
+			t.Fatalf("ULID %q sorts before one generated earlier", id)
+		}
+		seen[id], previous = true, id[:10]
+	}
+}
+
+func TestRequestIDInLogs(t *testing.T) {
+	logger, logs := newTestLogger(slog.LevelInfo)
+	assigner := &http.RequestIDAssigner{Generate: func() string { return "req-42" }}
+
+	handler := http.LoggingMiddleware(assigner.Middleware(http.HandleErrors(
+		func(req *http.Request) (*http.Response, error) {
+			return nil, http.NewHTTPError(500, "broken")
+		}, nil)))
+	req := &http.Request{Method: "GET", Path: "/", Headers: map[string]string{}}
+	req.SetLogger(logger)
+	handler(req)
+
+	for _, msg := range []string{"Handler error", "Request handled"} {
+		if got := logs.find(t, msg)["request_id"]; got != "req-42" {
+			t.Errorf("%q logged request_id %v", msg, got)
+		}
+	}
+}
+
+func TestRequestIDKeptByRouterLogger(t *testing.T) {
+	logger, logs := newTestLogger(slog.LevelInfo)
+	router := http.NewRouter()
+	router.AllowInsecure = true
+	router.Logger = logger
+	router.AddRoute("GET", "/", func(req *http.Request) *http.Response {
+		req.Logger().Info("Inside handler")
+		return http.NewResponse()
+	})
+	assigner := &http.RequestIDAssigner{Generate: func() string { return "req-7" }}
+
+	req := &http.Request{Method: "GET", Path: "/", RemoteAddr: "10.0.0.1:4000", ClientIP: "203.0.113.9", Headers: map[string]string{}}
+	assigner.Middleware(router.HandleRequest)(req)
+
+	record := logs.find(t, "Inside handler")
+	if record["request_id"] != "req-7" || record["client_ip"] != "203.0.113.9" {
+		t.Errorf("Router logger dropped request attributes: %v", record)
+	}
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	upstreamIDs := make(chan string, 2)
+	backend := startServer(t, func(req *http.Request) *http.Response {
+		upstreamIDs <- req.Headers["X-Request-Id"]
+		resp := http.NewResponse()
+		resp.SetStatus(200)
+		return resp
+	})
+
+	// The reverse proxy forwards the ID it assigned.
+	assigner := &http.RequestIDAssigner{Generate: func() string { return "proxy-id" }}
+	proxy := startProxy(t, "http://"+backend, nil)
+	front := startServer(t, assigner.Middleware(func(req *http.Request) *http.Response {
+		out := newClientRequest("GET", "http://"+proxy+"/")
+		out.SetContext(req.Context())
+		resp, _ := doRequest(t, out)
+		return resp
+	}))
+
+	resp, _ := doRequest(t, newClientRequest("GET", "http://"+front+"/"))
+	if resp.Headers["X-Request-Id"] != "proxy-id" {
+		t.Errorf("Response ID %q, want proxy-id", resp.Headers["X-Request-Id"])
+	}
+	if got := <-upstreamIDs; got != "proxy-id" {
+		t.Errorf("Upstream saw ID %q, want the one from the client's context", got)
+	}
+
+	// An explicit header wins over the context.
+	req := newClientRequest("GET", "http://"+backend+"/")
+	req.SetContext(http.ContextWithRequestID(context.Background(), "from-context"))
+	req.Headers["X-Request-Id"] = "explicit"
+	doRequest(t, req)
+	if got := <-upstreamIDs; got != "explicit" {
+		t.Errorf("Upstream saw ID %q, want explicit", got)
+	}
+}
+
+func TestRequestIDIgnoresUnassignedHeader(t *testing.T) {
+	req := &http.Request{Method: "GET", Path: "/", Headers: map[string]string{"X-Request-Id": "forged"}}
+	if id := req.RequestID(); id != "" {
+		t.Errorf("RequestID() = %q without an assigner, want empty", id)
+	}
+	req.SetContext(http.ContextWithRequestID(req.Context(), "assigned"))
+	if id := req.RequestID(); id != "assigned" {
+		t.Errorf("RequestID() = %q, want assigned", id)
+	}
+}
@@ -0,0 +1,364 @@
+package http_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+)
+
+// startServer serves handler on a random local port and returns its address.
+func startServer(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	server := http.NewServer(listener.Addr().String(), handler)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return listener.Addr().String()
+}
+
+func startProxy(t *testing.T, target string, configure func(*http.ReverseProxy)) string {
+	t.Helper()
+
+	u, _ := url.Parse(target)
+	proxy := http.NewReverseProxy(u)
+	proxy.Transport = &http.Transport{DialTimeout: time.Second, ResponseHeaderTimeout: 2 * time.Second}
+	if configure != nil {
+		configure(proxy)
+	}
+
+	return startServer(t, proxy.HandleRequest)
+}
+
+func doRequest(t *testing.T, req *http.Request) (*http.Response, string) {
+	t.Helper()
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, err := resp.ReadBody()
+	if err != nil {
+		t.Fatalf("Could not read body: %v", err)
+	}
+	return resp, string(body)
+}
+
+func newClientRequest(method, rawURL string) *http.Request {
+	u, _ := url.Parse(rawURL)
+	req := http.NewRequest()
+	req.Method = method
+	req.URL = u
+	req.Path = u.RequestURI()
+	return req
+}
+
+func TestReverseProxyHeaders(t *testing.T) {
+	backend := startServer(t, func(req *http.Request) *http.Response {
+		var lines []string
+		for key, value := range req.Headers {
+			lines = append(lines, key+": "+value)
+		}
+		sort.Strings(lines)
+		lines = append(lines, "Path: "+req.Path)
+
+		resp := http.NewResponse()
+		resp.SetStatus(200)
+		resp.SetHeader("Keep-Alive", "timeout=5")
+		resp.SetHeader("Connection", "X-Internal")
+		resp.SetHeader("X-Internal", "secret")
+		resp.SetBody([]byte(strings.Join(lines, "\n")))
+		return resp
+	})
+	proxy := startProxy(t, "http://"+backend, nil)
+
+	req := newClientRequest("GET", "http://"+proxy+"/app.js?v=2")
+	req.Headers["X-Forwarded-For"] = "203.0.113.7"
+	req.Headers["Connection"] = "X-Hop"
+	req.Headers["X-Hop"] = "drop me"
+
+	resp, body := doRequest(t, req)
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	expected := []string{
+		"Host: " + backend,
+		"X-Forwarded-For: 203.0.113.7, 127.0.0.1",
+		"X-Forwarded-Proto: http",
+		"X-Forwarded-Host: " + proxy,
+		fmt.Sprintf("Forwarded: for=127.0.0.1;proto=http;host=%q", proxy),
+		"Path: /app.js?v=2",
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line) {
+			t.Errorf("Expected upstream to see %q, got:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, "X-Hop") {
+		t.Errorf("Expected hop-by-hop header X-Hop to be stripped, got:\n%s", body)
+	}
+
+	for _, key := range []string{"Keep-Alive", "X-Internal"} {
+		if _, ok := resp.Headers[key]; ok {
+			t.Errorf("Expected hop-by-hop response header %s to be stripped", key)
+		}
+	}
+}
+
+func TestReverseProxyStreamsBodies(t *testing.T) {
+	backend := startServer(t, func(req *http.Request) *http.Response {
+		reader, writer := io.Pipe()
+		go func() {
+			// Echo the upload back in pieces as it arrives.
+			_, err := io.Copy(writer, req.BodyReader)
+			writer.CloseWithError(err)
+		}()
+
+		resp := http.NewResponse()
+		resp.SetStatus(200)
+		resp.BodyReader = reader
+		return resp
+	})
+	proxy := startProxy(t, "http://"+backend+"/uploads", nil)
+
+	upload := strings.Repeat("netrunner ", 10000)
+	req := newClientRequest("POST", "http://"+proxy+"/big")
+	req.BodyReader = strings.NewReader(upload)
+
+	resp, body := doRequest(t, req)
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if body != upload {
+		t.Errorf("Expected %d echoed bytes, got %d", len(upload), len(body))
+	}
+}
+
+func TestReverseProxyRewritesLocation(t *testing.T) {
+	var backend string
+	backend = startServer(t, func(req *http.Request) *http.Response {
+		resp := http.NewResponse()
+		resp.SetStatus(302)
+		resp.SetHeader("Location", "http://"+backend+"/login?next=%2F")
+		return resp
+	})
+	proxy := startProxy(t, "http://"+backend, nil)
+
+	resp, _ := doRequest(t, newClientRequest("GET", "http://"+proxy+"/account"))
+	expected := "http://" + proxy + "/login?next=%2F"
+	if resp.Headers["Location"] != expected {
+		t.Errorf("Expected Location %q, got %q", expected, resp.Headers["Location"])
+	}
+}
+
+func TestReverseProxyUpstreamErrors(t *testing.T) {
+	// Find a port with nothing listening on it.
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed := listener.Addr().String()
+	listener.Close()
+
+	proxy := startProxy(t, "http://"+closed, nil)
+	resp, _ := doRequest(t, newClientRequest("GET", "http://"+proxy+"/"))
+	if resp.StatusCode != 502 {
+		t.Errorf("Expected 502 for unreachable upstream, got %d", resp.StatusCode)
+	}
+
+	slow := startServer(t, func(req *http.Request) *http.Response {
+		time.Sleep(500 * time.Millisecond)
+		return http.NewResponse()
+	})
+	proxy = startProxy(t, "http://"+slow, func(p *http.ReverseProxy) {
+		p.Transport.ResponseHeaderTimeout = 100 * time.Millisecond
+	})
+	resp, _ = doRequest(t, newClientRequest("GET", "http://"+proxy+"/"))
+	if resp.StatusCode != 504 {
+		t.Errorf("Expected 504 for slow upstream, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerReadTimeoutCoversBody(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	readErr := make(chan error, 1)
+	server := http.NewServer(listener.Addr().String(), func(req *http.Request) *http.Response {
+		_, err := req.ReadBody()
+		readErr <- err
+		return http.NewResponse()
+	})
+	server.ReadTimeout = 200 * time.Millisecond
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	// Promise ten bytes of body, send two and stall.
+	conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: test\r\nContent-Length: 10\r\n\r\nab"))
+
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Error("Expected reading a stalled body to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reading a stalled body did not time out")
+	}
+}
+
+func TestTransportRetriesOnlyIdempotentRequests(t *testing.T) {
+	// Each connection answers one request and then drops the next one
+	// unanswered, like a server closing a kept-alive connection.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	defer listener.Close()
+	received := make(chan string, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for i := 0; i < 2; i++ {
+					req, err := http.ReadRequest(reader)
+					if err != nil {
+						return
+					}
+					req.ReadBody()
+					received <- req.Method
+					if i == 0 {
+						conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+					}
+				}
+			}()
+		}
+	}()
+
+	client := &http.Client{Transport: &http.Transport{DialTimeout: time.Second, ResponseHeaderTimeout: 2 * time.Second}}
+	base := "http://" + listener.Addr().String()
+	send := func(method string) error {
+		req := newClientRequest(method, base+"/")
+		if method == "POST" {
+			req.Body = []byte("order=1")
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.ReadBody()
+		}
+		return err
+	}
+	countMethod := func(method string) int {
+		n := 0
+		for {
+			select {
+			case m := <-received:
+				if m == method {
+					n++
+				}
+			case <-time.After(200 * time.Millisecond):
+				return n
+			}
+		}
+	}
+
+	if err := send("GET"); err != nil {
+		t.Fatalf("First GET failed: %v", err)
+	}
+	if err := send("POST"); err == nil {
+		t.Error("Expected POST on a dropped connection to fail")
+	}
+	if n := countMethod("POST"); n != 1 {
+		t.Errorf("POST was sent %d times, want 1", n)
+	}
+
+	if err := send("GET"); err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	if err := send("GET"); err != nil {
+		t.Errorf("Expected GET on a dropped connection to be retried, got %v", err)
+	}
+}
+
+func TestResponseHeaderTimeoutExcludesUpload(t *testing.T) {
+	backend := startServer(t, func(req *http.Request) *http.Response {
+		body, _ := io.ReadAll(req.BodyReader)
+		resp := http.NewResponse()
+		resp.SetStatus(200)
+		resp.SetBody([]byte(fmt.Sprint(len(body))))
+		return resp
+	})
+
+	// The upload takes longer than ResponseHeaderTimeout.
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < 5; i++ {
+			time.Sleep(60 * time.Millisecond)
+			pw.Write([]byte("chunk"))
+		}
+		pw.Close()
+	}()
+	req := newClientRequest("POST", "http://"+backend+"/upload")
+	req.BodyReader = pr
+	client := &http.Client{Transport: &http.Transport{ResponseHeaderTimeout: 150 * time.Millisecond}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Slow upload failed: %v", err)
+	}
+	if body, _ := resp.ReadBody(); string(body) != "25" {
+		t.Errorf("Backend received %s bytes, want 25", body)
+	}
+}
+
+func TestReverseProxyForwardsSetCookieVerbatim(t *testing.T) {
+	cookies := []string{
+		"id=1; Path=/; Priority=High",
+		"legacy=a b; Path=/",
+		"theme=dark; Expires=Wed, 21 Oct 2026 07:28:00 GMT",
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		http.ReadRequest(bufio.NewReader(conn))
+		head := "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n"
+		for _, c := range cookies {
+			head += "Set-Cookie: " + c + "\r\n"
+		}
+		conn.Write([]byte(head + "\r\n"))
+	}()
+
+	proxy := startProxy(t, "http://"+listener.Addr().String(), nil)
+	resp, _ := doRequest(t, newClientRequest("GET", "http://"+proxy+"/"))
+	if strings.Join(resp.SetCookies, "\n") != strings.Join(cookies, "\n") {
+		t.Errorf("Expected Set-Cookie lines %q, got %q", cookies, resp.SetCookies)
+	}
+}
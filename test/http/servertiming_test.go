@@ -0,0 +1,148 @@
+package http_test
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+)
+
+func TestFormatServerTiming(t *testing.T) {
+	got := http.FormatServerTiming([]http.Timing{
+		{Name: "db", Description: `Load "cart"` + "\n", Duration: 53200 * time.Microsecond},
+		{Name: "c a;che", Duration: 0},
+		{Name: ";;", Duration: time.Second},
+		{Name: "total", Duration: 80 * time.Millisecond},
+	})
+	want := `db;dur=53.2;desc="Load \"cart\"", cache;dur=0, total;dur=80`
+	if got != want {
+		t.Errorf("Got %s, want %s", got, want)
+	}
+}
+
+func timingHandler(opts http.ServerTimingOptions) http.HandlerFunc {
+	return http.NewServerTimingMiddleware(opts)(func(req *http.Request) *http.Response {
+		stop := req.Timings().Start("db", "Query")
+		time.Sleep(2 * time.Millisecond)
+		stop()
+		req.Timings().Record("render", "", time.Millisecond)
+		resp := http.NewResponse()
+		resp.SetStatus(200)
+		return resp
+	})
+}
+
+func TestServerTimingMiddleware(t *testing.T) {
+	handler := timingHandler(http.ServerTimingOptions{Enabled: true})
+	resp := handler(&http.Request{Method: "GET", Path: "/", Headers: map[string]string{}})
+	header := resp.Headers["Server-Timing"]
+	if !regexp.MustCompile(`^db;dur=[0-9.]+;desc="Query", render;dur=1, total;dur=[0-9.]+$`).MatchString(header) {
+		t.Errorf("Server-Timing = %q", header)
+	}
+	if _, ok := resp.Headers["Timing-Allow-Origin"]; ok {
+		t.Error("Timing-Allow-Origin set without an allowed origin")
+	}
+
+	handler = timingHandler(http.ServerTimingOptions{AllowedOrigins: []string{"https://app.example.com"}})
+	for _, tt := range []struct {
+		headers map[string]string
+		origin  string
+	}{
+		{map[string]string{"Origin": "https://app.example.com"}, "https://app.example.com"},
+		{map[string]string{"Referer": "https://app.example.com/cart?id=1"}, "https://app.example.com"},
+		{map[string]string{"Origin": "https://evil.example"}, ""},
+		{map[string]string{}, ""},
+	} {
+		resp := handler(&http.Request{Method: "GET", Path: "/", Headers: tt.headers})
+		_, sent := resp.Headers["Server-Timing"]
+		if sent != (tt.origin != "") || resp.Headers["Timing-Allow-Origin"] != tt.origin {
+			t.Errorf("%v: Server-Timing sent %v, Timing-Allow-Origin %q", tt.headers, sent, resp.Headers["Timing-Allow-Origin"])
+		}
+		if resp.Headers["Vary"] != "Origin" {
+			t.Errorf("%v: Vary = %q", tt.headers, resp.Headers["Vary"])
+		}
+	}
+}
+
+func TestServerTimingThroughProxy(t *testing.T) {
+	backend := startServer(t, func(req *http.Request) *http.Response {
+		resp := http.NewResponse()
+		resp.SetStatus(200)
+		resp.SetHeader("Server-Timing", "cache;desc=hit")
+		return resp
+	})
+	target, _ := url.Parse("http://" + backend)
+	proxy := http.NewReverseProxy(target)
+	front := startServer(t, http.NewServerTimingMiddleware(http.ServerTimingOptions{Enabled: true})(proxy.HandleRequest))
+
+	resp, _ := doRequest(t, newClientRequest("GET", "http://"+front+"/"))
+	header := resp.Headers["Server-Timing"]
+	if !strings.HasPrefix(header, "cache;desc=hit, upstream;dur=") || !strings.Contains(header, `desc="Upstream", total;dur=`) {
+		t.Errorf("Server-Timing = %q", header)
+	}
+	if strings.Contains(header, backend) {
+		t.Errorf("Server-Timing names the backend: %q", header)
+	}
+
+	// Clients that may not see timings don't get the backend's either.
+	restricted := startServer(t, http.NewServerTimingMiddleware(http.ServerTimingOptions{AllowedOrigins: []string{"https://app.example.com"}})(proxy.HandleRequest))
+	resp, _ = doRequest(t, newClientRequest("GET", "http://"+restricted+"/"))
+	if header, ok := resp.Headers["Server-Timing"]; ok {
+		t.Errorf("Disallowed client got Server-Timing %q", header)
+	}
+}
+
+func TestServerTimingTLSHandshake(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	config := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	server := http.NewServer(listener.Addr().String(), timingHandler(http.ServerTimingOptions{Enabled: true}))
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	var headers []string
+	for i := 0; i < 2; i++ {
+		conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n"))
+		resp, err := http.ReadResponse(reader, "GET")
+		if err != nil {
+			t.Fatalf("Could not read response: %v", err)
+		}
+		resp.ReadBody()
+		headers = append(headers, resp.Headers["Server-Timing"])
+	}
+
+	if !strings.Contains(headers[0], `tls;dur=`) {
+		t.Errorf("First request lacks the handshake: %q", headers[0])
+	}
+	if strings.Contains(headers[1], `tls;dur=`) {
+		t.Errorf("Second request on the connection reports the handshake again: %q", headers[1])
+	}
+}
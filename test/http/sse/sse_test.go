@@ -0,0 +1,178 @@
+package sse_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+	"github.com/appyzdl/Netrunner/pkg/http/sse"
+)
+
+func startServer(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	server := http.NewServer(listener.Addr().String(), handler)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().String()
+}
+
+// openStream sends a GET and returns the response with a reader over the
+// de-chunked event stream.
+func openStream(t *testing.T, addr, headers string) (*http.Response, *bufio.Reader, net.Conn) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "GET /events HTTP/1.1\r\nHost: %s\r\n%s\r\n", addr, headers)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), "GET")
+	if err != nil {
+		t.Fatalf("Could not read response: %v", err)
+	}
+	return resp, bufio.NewReader(resp.BodyReader), conn
+}
+
+// nextEvent reads one blank-line-terminated block, skipping comment-only
+// blocks unless keepComments is set.
+func nextEvent(t *testing.T, r *bufio.Reader, keepComments bool) string {
+	t.Helper()
+
+	for {
+		var block strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Stream ended: %v", err)
+			}
+			if line == "\n" {
+				break
+			}
+			block.WriteString(line)
+		}
+		if keepComments || !strings.HasPrefix(block.String(), ":") {
+			return block.String()
+		}
+	}
+}
+
+func TestEventFormat(t *testing.T) {
+	e := sse.Event{ID: "7", Event: "update\n", Data: "line one\r\nline two\rline three", Retry: 2 * time.Second}
+	expected := "id: 7\nevent: update\nretry: 2000\ndata: line one\ndata: line two\ndata: line three\n\n"
+	if e.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, e.String())
+	}
+}
+
+func TestHandlerStreamsAndDetectsDisconnect(t *testing.T) {
+	done := make(chan struct{})
+	handler := sse.NewHandler(func(stream *sse.Stream) {
+		stream.Send(sse.Event{Event: "greeting", Data: "hello"})
+		<-stream.Done()
+		close(done)
+	})
+	handler.Heartbeat = 20 * time.Millisecond
+	addr := startServer(t, handler.HandleRequest)
+
+	resp, events, conn := openStream(t, addr, "")
+	if resp.Headers["Content-Type"] != "text/event-stream; charset=utf-8" || resp.Headers["Cache-Control"] != "no-cache" {
+		t.Errorf("Unexpected headers: %v", resp.Headers)
+	}
+	if got := nextEvent(t, events, false); got != "event: greeting\ndata: hello\n" {
+		t.Errorf("Unexpected event %q", got)
+	}
+	if got := nextEvent(t, events, true); got != ":heartbeat\n" {
+		t.Errorf("Expected a heartbeat, got %q", got)
+	}
+
+	conn.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Handler was not told the client disconnected")
+	}
+}
+
+func TestBrokerFanOutAndReplay(t *testing.T) {
+	broker := sse.NewBroker()
+	addr := startServer(t, broker.Handler("news").HandleRequest)
+
+	_, first, _ := openStream(t, addr, "")
+	_, second, _ := openStream(t, addr, "")
+	for deadline := time.Now().Add(2 * time.Second); broker.Subscribers("news") < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("Subscribers never registered")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for i := 1; i <= 3; i++ {
+		broker.Publish("news", sse.Event{Data: fmt.Sprintf("story %d", i)})
+	}
+	broker.Publish("sport", sse.Event{Data: "other topic"})
+
+	for _, events := range []*bufio.Reader{first, second} {
+		for i := 1; i <= 3; i++ {
+			expected := fmt.Sprintf("id: %d\ndata: story %d\n", i, i)
+			if got := nextEvent(t, events, false); got != expected {
+				t.Errorf("Expected %q, got %q", expected, got)
+			}
+		}
+	}
+
+	// A reconnecting client gets only what it missed.
+	_, resumed, _ := openStream(t, addr, "Last-Event-ID: 1\r\n")
+	for _, expected := range []string{"id: 2\ndata: story 2\n", "id: 3\ndata: story 3\n"} {
+		if got := nextEvent(t, resumed, false); got != expected {
+			t.Errorf("Expected replayed %q, got %q", expected, got)
+		}
+	}
+}
+
+func TestRingBuffer(t *testing.T) {
+	buffer := sse.NewRingBuffer(3)
+	for i := 1; i <= 5; i++ {
+		buffer.Add(sse.Event{ID: fmt.Sprint(i)})
+	}
+
+	missed, ok := buffer.Since("3")
+	if !ok || len(missed) != 2 || missed[0].ID != "4" || missed[1].ID != "5" {
+		t.Errorf("Expected events 4 and 5, got %v (%v)", missed, ok)
+	}
+	if _, ok := buffer.Since("1"); ok {
+		t.Error("Expected an evicted ID to be reported as unknown")
+	}
+}
+
+func TestHandlerPanicEndsOnlyItsStream(t *testing.T) {
+	handler := sse.NewHandler(func(stream *sse.Stream) {
+		stream.Send(sse.Event{Data: "before"})
+		panic("boom")
+	})
+	addr := startServer(t, handler.HandleRequest)
+
+	for i := 0; i < 2; i++ {
+		_, events, _ := openStream(t, addr, "")
+		if got := nextEvent(t, events, false); got != "data: before\n" {
+			t.Errorf("Unexpected event %q", got)
+		}
+		// The stream ends instead of the process.
+		for {
+			if _, err := events.ReadString('\n'); err != nil {
+				break
+			}
+		}
+	}
+}
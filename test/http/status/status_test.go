@@ -0,0 +1,71 @@
+package status_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+	"github.com/appyzdl/Netrunner/pkg/http/status"
+)
+
+func TestText(t *testing.T) {
+	tests := map[int]string{
+		status.PartialContent:              "Partial Content",
+		status.NotModified:                 "Not Modified",
+		status.TooManyRequests:             "Too Many Requests",
+		status.RequestHeaderFieldsTooLarge: "Request Header Fields Too Large",
+		status.NetworkAuthRequired:         "Network Authentication Required",
+		// Unregistered codes fall back to their class.
+		199: "Informational",
+		299: "Success",
+		306: "Redirection",
+		499: "Client Error",
+		599: "Server Error",
+		999: "Unknown Status",
+	}
+	for code, expected := range tests {
+		if got := status.Text(code); got != expected {
+			t.Errorf("Text(%d): expected %q, got %q", code, expected, got)
+		}
+	}
+	if status.IsRegistered(306) || !status.IsRegistered(status.EarlyHints) {
+		t.Error("IsRegistered disagrees with the registry")
+	}
+}
+
+func TestClasses(t *testing.T) {
+	checks := []struct {
+		name string
+		fn   func(int) bool
+		yes  []int
+		no   []int
+	}{
+		{"IsInformational", status.IsInformational, []int{100, 103, 199}, []int{99, 200}},
+		{"IsSuccess", status.IsSuccess, []int{200, 226, 299}, []int{199, 300}},
+		{"IsRedirect", status.IsRedirect, []int{300, 308}, []int{299, 400}},
+		{"IsClientError", status.IsClientError, []int{400, 451, 499}, []int{399, 500}},
+		{"IsServerError", status.IsServerError, []int{500, 511, 599}, []int{499, 600}},
+		{"IsError", status.IsError, []int{400, 503}, []int{304, 600}},
+	}
+	for _, c := range checks {
+		for _, code := range c.yes {
+			if !c.fn(code) {
+				t.Errorf("%s(%d) should be true", c.name, code)
+			}
+		}
+		for _, code := range c.no {
+			if c.fn(code) {
+				t.Errorf("%s(%d) should be false", c.name, code)
+			}
+		}
+	}
+}
+
+func TestStatusLineAlwaysHasReason(t *testing.T) {
+	resp := http.NewResponse()
+	resp.StatusCode = status.TooManyRequests
+	line, _, _ := strings.Cut(string(resp.Write()), "\r\n")
+	if line != "HTTP/1.1 429 Too Many Requests" {
+		t.Errorf("Unexpected status line %q", line)
+	}
+}
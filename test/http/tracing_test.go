@@ -0,0 +1,145 @@
+package http_test
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+	"github.com/appyzdl/Netrunner/pkg/trace"
+)
+
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (r *spanRecorder) ExportSpans(ctx context.Context, spans []*trace.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *spanRecorder) find(t *testing.T, name string) *trace.SpanData {
+	t.Helper()
+	for _, s := range r.spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	var names []string
+	for _, s := range r.spans {
+		names = append(names, s.Name)
+	}
+	t.Fatalf("No span %q in %q", name, names)
+	return nil
+}
+
+func spanAttr(s *trace.SpanData, key string) any {
+	for _, attr := range s.Attributes {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return nil
+}
+
+func TestTracingThroughRouterAndProxy(t *testing.T) {
+	upstreamParents := make(chan string, 1)
+	backend := startServer(t, func(req *http.Request) *http.Response {
+		upstreamParents <- req.Headers["Traceparent"]
+		resp := http.NewResponse()
+		resp.SetStatus(200)
+		return resp
+	})
+
+	rec := &spanRecorder{}
+	tracer := trace.NewTracer(rec)
+	defer tracer.Shutdown(context.Background())
+
+	target, _ := url.Parse("http://" + backend)
+	router := http.NewRouter()
+	router.AllowInsecure = true
+	router.Tracer = tracer
+	router.Use(http.LoggingMiddleware)
+	router.AddStreamRoute("GET", "/api/*", http.NewReverseProxy(target).HandleRequest)
+	front := startServer(t, router.HandleRequest)
+
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := newClientRequest("GET", "http://"+front+"/api/items?page=2")
+	req.Headers["Traceparent"] = incoming
+	req.Headers["Tracestate"] = "vendor=abc"
+	resp, _ := doRequest(t, req)
+	if resp.StatusCode != 200 {
+		t.Fatalf("Got status %d", resp.StatusCode)
+	}
+	upstream := <-upstreamParents
+	tracer.Flush()
+
+	remote, _ := trace.ParseTraceparent(incoming)
+	server := rec.find(t, "GET /api/*")
+	middleware := rec.find(t, "middleware http.LoggingMiddleware")
+	client := rec.find(t, "GET")
+
+	if server.Kind != trace.KindServer || server.Parent != remote.SpanID || server.SpanContext.TraceID != remote.TraceID {
+		t.Errorf("Server span doesn't continue the incoming trace: %+v", server)
+	}
+	if server.SpanContext.TraceState != "vendor=abc" {
+		t.Errorf("Tracestate %q not carried over", server.SpanContext.TraceState)
+	}
+	for key, want := range map[string]any{
+		"http.request.method":       "GET",
+		"http.route":                "/api/*",
+		"url.path":                  "/api/items",
+		"url.query":                 "page=2",
+		"http.response.status_code": int64(200),
+		"network.protocol.version":  "1.1",
+	} {
+		if got := spanAttr(server, key); got != want {
+			t.Errorf("Server span %s = %v, want %v", key, got, want)
+		}
+	}
+	if middleware.Parent != server.SpanContext.SpanID {
+		t.Error("Middleware span isn't a child of the server span")
+	}
+	if client.Kind != trace.KindClient || client.Parent != middleware.SpanContext.SpanID {
+		t.Errorf("Proxy span %+v isn't a client span under the middleware", client)
+	}
+	if want := client.SpanContext.Traceparent(); upstream != want {
+		t.Errorf("Upstream got traceparent %q, want %q", upstream, want)
+	}
+}
+
+func TestTracingUnsampledStillPropagates(t *testing.T) {
+	rec := &spanRecorder{}
+	tracer := trace.NewTracer(rec)
+	defer tracer.Shutdown(context.Background())
+
+	var outgoing *http.Request
+	router := http.NewRouter()
+	router.AllowInsecure = true
+	router.Tracer = tracer
+	router.AddRoute("GET", "/", func(req *http.Request) *http.Response {
+		// Build an outgoing request as a handler would, without sending it.
+		outgoing = http.NewRequest()
+		outgoing.Method = "GET"
+		outgoing.SetContext(req.Context())
+		return http.NewResponse()
+	})
+
+	req := &http.Request{Method: "GET", Path: "/", Version: "HTTP/1.1", Headers: map[string]string{
+		"Traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+	}}
+	router.HandleRequest(req)
+	tracer.Flush()
+
+	if len(rec.spans) != 0 {
+		t.Errorf("Exported %d spans for an unsampled trace", len(rec.spans))
+	}
+	sc := trace.SpanContextFromContext(outgoing.Context())
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.Sampled() {
+		t.Errorf("Handler's context has %+v, want the unsampled incoming trace", sc)
+	}
+}
@@ -0,0 +1,284 @@
+package http_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+)
+
+// startBackend runs a Netrunner server that answers with its name and
+// reports its health from the healthy flag.
+func startBackend(t *testing.T, name string, healthy *atomic.Bool) string {
+	t.Helper()
+
+	return startServer(t, func(req *http.Request) *http.Response {
+		resp := http.NewResponse()
+		resp.SetStatus(200)
+		if req.Path == "/healthz" && healthy != nil && !healthy.Load() {
+			resp.SetStatus(503)
+		}
+		resp.SetBody([]byte(name))
+		return resp
+	})
+}
+
+func newPool(t *testing.T, strategy http.BalanceStrategy, backends map[string]int) *http.UpstreamPool {
+	t.Helper()
+
+	pool := http.NewUpstreamPool(strategy)
+	pool.Transport = &http.Transport{DialTimeout: time.Second, ResponseHeaderTimeout: time.Second}
+	for addr, weight := range backends {
+		if _, err := pool.AddUpstream("http://"+addr, weight); err != nil {
+			t.Fatalf("Could not add upstream: %v", err)
+		}
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+// distribute sends n requests through the pool and counts which backend
+// answered each one.
+func distribute(t *testing.T, pool *http.UpstreamPool, n int, prepare func(*http.Request, int)) map[string]int {
+	t.Helper()
+
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		req := newPoolRequest()
+		if prepare != nil {
+			prepare(req, i)
+		}
+		resp := pool.HandleRequest(req)
+		body, _ := resp.ReadBody()
+		if resp.StatusCode != 200 {
+			t.Fatalf("Request %d: expected status 200, got %d (%s)", i, resp.StatusCode, body)
+		}
+		counts[string(body)]++
+	}
+	return counts
+}
+
+func newPoolRequest() *http.Request {
+	req := http.NewRequest()
+	req.Method = "GET"
+	req.Path = "/"
+	req.Version = "HTTP/1.1"
+	req.RemoteAddr = "192.0.2.1:5000"
+	return req
+}
+
+func TestUpstreamPoolRoundRobin(t *testing.T) {
+	a, b, c := startBackend(t, "a", nil), startBackend(t, "b", nil), startBackend(t, "c", nil)
+	pool := newPool(t, http.RoundRobin, map[string]int{a: 1, b: 5, c: 1})
+
+	// Round-robin ignores weights.
+	counts := distribute(t, pool, 30, nil)
+	for _, name := range []string{"a", "b", "c"} {
+		if counts[name] != 10 {
+			t.Errorf("Expected backend %s to get 10 requests, got %v", name, counts)
+		}
+	}
+}
+
+func TestUpstreamPoolWeightedRoundRobin(t *testing.T) {
+	a, b := startBackend(t, "a", nil), startBackend(t, "b", nil)
+	pool := newPool(t, http.WeightedRoundRobin, map[string]int{a: 3, b: 1})
+
+	counts := distribute(t, pool, 40, nil)
+	if counts["a"] != 30 || counts["b"] != 10 {
+		t.Errorf("Expected a 3:1 split, got %v", counts)
+	}
+}
+
+func TestUpstreamPoolLeastConnections(t *testing.T) {
+	release := make(chan struct{})
+	slow := startServer(t, func(req *http.Request) *http.Response {
+		<-release
+		resp := http.NewResponse()
+		resp.SetStatus(200)
+		resp.SetBody([]byte("slow"))
+		return resp
+	})
+	fast := startBackend(t, "fast", nil)
+	pool := newPool(t, http.LeastConnections, map[string]int{slow: 1, fast: 1})
+	pool.Transport.ResponseHeaderTimeout = 5 * time.Second
+
+	// Keep sending requests until one is parked on the slow backend, then
+	// check that new traffic avoids it.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			body, _ := pool.HandleRequest(newPoolRequest()).ReadBody()
+			if string(body) == "slow" {
+				return
+			}
+		}
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	counts := distribute(t, pool, 10, nil)
+	close(release)
+	<-done
+	if counts["fast"] != 10 {
+		t.Errorf("Expected all new requests to go to the idle backend, got %v", counts)
+	}
+}
+
+func TestUpstreamPoolConsistentHash(t *testing.T) {
+	a, b, c := startBackend(t, "a", nil), startBackend(t, "b", nil), startBackend(t, "c", nil)
+	pool := newPool(t, http.ConsistentHash, map[string]int{a: 1, b: 1, c: 1})
+	pool.HashKey = http.HashByHeader("X-User")
+
+	assigned := make(map[string]string)
+	counts := distribute(t, pool, 60, func(req *http.Request, i int) {
+		req.Headers["X-User"] = fmt.Sprintf("user-%d", i%20)
+	})
+	if len(counts) < 2 {
+		t.Errorf("Expected keys to spread over several backends, got %v", counts)
+	}
+
+	for i := 0; i < 20; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		for j := 0; j < 3; j++ {
+			got := distribute(t, pool, 1, func(req *http.Request, _ int) { req.Headers["X-User"] = user })
+			for name := range got {
+				if prev, ok := assigned[user]; ok && prev != name {
+					t.Errorf("Expected %s to stick to %s, got %s", user, prev, name)
+				}
+				assigned[user] = name
+			}
+		}
+	}
+}
+
+func TestUpstreamPoolPassiveFailover(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	dead := listener.Addr().String()
+	listener.Close()
+	alive := startBackend(t, "alive", nil)
+
+	pool := newPool(t, http.RoundRobin, map[string]int{dead: 1, alive: 1})
+	pool.MaxFails = 2
+	pool.EjectDuration = time.Hour
+
+	// Every request succeeds because failures are retried on the other
+	// upstream, and the dead one is ejected after MaxFails.
+	counts := distribute(t, pool, 10, nil)
+	if counts["alive"] != 10 {
+		t.Errorf("Expected every request to fail over to the live backend, got %v", counts)
+	}
+	for _, u := range pool.Upstreams() {
+		if u.URL.Host == dead && u.Healthy() {
+			t.Errorf("Expected %s to be ejected", dead)
+		}
+	}
+}
+
+// startHangingBackend accepts connections and reads requests without ever
+// answering, counting each request it receives.
+func startHangingBackend(t *testing.T, received *atomic.Int32) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			go func() {
+				if _, err := http.ReadRequest(bufio.NewReader(conn)); err == nil {
+					received.Add(1)
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestUpstreamPoolDoesNotReplayNonIdempotentRequests(t *testing.T) {
+	var received atomic.Int32
+	a, b := startHangingBackend(t, &received), startHangingBackend(t, &received)
+	pool := newPool(t, http.RoundRobin, map[string]int{a: 1, b: 1})
+	pool.Transport = &http.Transport{DialTimeout: time.Second, ResponseHeaderTimeout: 200 * time.Millisecond}
+
+	req := newPoolRequest()
+	req.Method = "POST"
+	req.Body = []byte("order=1")
+	req.Headers["Content-Length"] = "7"
+	if resp := pool.HandleRequest(req); resp.StatusCode != 504 {
+		t.Errorf("Expected 504, got %d", resp.StatusCode)
+	}
+	if n := received.Load(); n != 1 {
+		t.Errorf("POST reached %d backends, want 1", n)
+	}
+
+	// A GET may be sent again.
+	received.Store(0)
+	pool.HandleRequest(newPoolRequest())
+	if n := received.Load(); n != 2 {
+		t.Errorf("GET reached %d backends, want 2", n)
+	}
+
+	// A POST that never reached a backend still fails over.
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	dead := listener.Addr().String()
+	listener.Close()
+	alive := startBackend(t, "alive", nil)
+	pool = newPool(t, http.RoundRobin, map[string]int{dead: 1, alive: 1})
+	for i := 0; i < 2; i++ {
+		req := newPoolRequest()
+		req.Method = "POST"
+		resp := pool.HandleRequest(req)
+		if body, _ := resp.ReadBody(); string(body) != "alive" {
+			t.Errorf("POST %d: got %d %q, want it failed over", i, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestUpstreamPoolActiveHealthChecks(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	a, b := startBackend(t, "a", &healthy), startBackend(t, "b", nil)
+
+	pool := newPool(t, http.RoundRobin, map[string]int{a: 1, b: 1})
+	pool.HealthCheck = &http.HealthCheck{Path: "/healthz", Interval: 20 * time.Millisecond}
+	pool.SlowStart = time.Hour
+	pool.StartHealthChecks()
+
+	healthy.Store(false)
+	time.Sleep(100 * time.Millisecond)
+	if counts := distribute(t, pool, 10, nil); counts["b"] != 10 {
+		t.Errorf("Expected unhealthy backend to be skipped, got %v", counts)
+	}
+
+	// Once healthy again, slow-start keeps the recovered backend at a small
+	// share of the traffic.
+	healthy.Store(true)
+	time.Sleep(100 * time.Millisecond)
+	counts := distribute(t, pool, 40, nil)
+	if counts["a"] == 0 || counts["a"] > 10 {
+		t.Errorf("Expected recovered backend to receive a small share, got %v", counts)
+	}
+}
+
+func TestHashByClientIP(t *testing.T) {
+	req := newPoolRequest()
+	if key := http.HashByClientIP(req); key != "192.0.2.1" {
+		t.Errorf("Expected the peer address without ClientIP, got %q", key)
+	}
+	// Behind a load balancer every request comes from the balancer.
+	req.ClientIP = "203.0.113.7"
+	if key := http.HashByClientIP(req); key != "203.0.113.7" {
+		t.Errorf("Expected the resolved client IP, got %q", key)
+	}
+}
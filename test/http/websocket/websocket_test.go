@@ -0,0 +1,290 @@
+package websocket_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+	"github.com/appyzdl/Netrunner/pkg/http/websocket"
+)
+
+// startEchoServer serves a WebSocket endpoint that echoes every message and
+// reports the error that ended the connection on closed.
+func startEchoServer(t *testing.T, upgrader *websocket.Upgrader, closed chan<- error) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	server := http.NewServer(listener.Addr().String(), func(req *http.Request) *http.Response {
+		return upgrader.Upgrade(req, func(conn *websocket.Conn) {
+			for {
+				messageType, data, err := conn.ReadMessage()
+				if err != nil {
+					if closed != nil {
+						closed <- err
+					}
+					return
+				}
+				if err := conn.WriteMessage(messageType, data); err != nil {
+					return
+				}
+			}
+		})
+	})
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return "ws://" + listener.Addr().String() + "/ws"
+}
+
+func dial(t *testing.T, dialer *websocket.Dialer, url string) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func TestEchoAndSubprotocol(t *testing.T) {
+	url := startEchoServer(t, &websocket.Upgrader{Subprotocols: []string{"chat.v2", "chat.v1"}}, nil)
+	conn := dial(t, &websocket.Dialer{Subprotocols: []string{"chat.v1", "chat.v2"}}, url)
+
+	if conn.Subprotocol() != "chat.v1" {
+		t.Errorf("Expected subprotocol chat.v1, got %q", conn.Subprotocol())
+	}
+
+	messages := []struct {
+		messageType websocket.MessageType
+		data        string
+	}{
+		{websocket.TextMessage, "Hello, Netrunner!"},
+		{websocket.BinaryMessage, "\x00\x01\x02"},
+		{websocket.TextMessage, strings.Repeat("x", 70000)}, // 64-bit length
+	}
+	for _, m := range messages {
+		if err := conn.WriteMessage(m.messageType, []byte(m.data)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if messageType != m.messageType || string(data) != m.data {
+			t.Errorf("Expected echo of %d bytes (type %d), got %d bytes (type %d)", len(m.data), m.messageType, len(data), messageType)
+		}
+	}
+}
+
+func TestFragmentedMessage(t *testing.T) {
+	conn := dial(t, websocket.DefaultDialer, startEchoServer(t, &websocket.Upgrader{}, nil))
+
+	w, err := conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		t.Fatalf("NextWriter failed: %v", err)
+	}
+	for _, part := range []string{"frag", "men", "ted"} {
+		fmt.Fprint(w, part)
+	}
+	// A ping between fragments is allowed and must not break reassembly.
+	conn.Ping([]byte("mid-message"))
+	w.Close()
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(data) != "fragmented" {
+		t.Errorf("Expected 'fragmented', got %q", data)
+	}
+}
+
+func TestPingPong(t *testing.T) {
+	conn := dial(t, websocket.DefaultDialer, startEchoServer(t, &websocket.Upgrader{}, nil))
+
+	pongs := make(chan string, 1)
+	conn.SetPongHandler(func(data []byte) error {
+		pongs <- string(data)
+		return nil
+	})
+	conn.Ping([]byte("are you there"))
+	conn.WriteMessage(websocket.TextMessage, []byte("after ping"))
+
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	select {
+	case data := <-pongs:
+		if data != "are you there" {
+			t.Errorf("Expected pong payload to match ping, got %q", data)
+		}
+	default:
+		t.Error("Expected a pong before the echoed message")
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	closed := make(chan error, 1)
+	conn := dial(t, websocket.DefaultDialer, startEchoServer(t, &websocket.Upgrader{}, closed))
+
+	if err := conn.CloseWithCode(websocket.CloseGoingAway, "bye"); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	var closeErr *websocket.CloseError
+	select {
+	case err := <-closed:
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway || closeErr.Text != "bye" {
+			t.Errorf("Expected server to see close 1001 'bye', got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Server never saw the close frame")
+	}
+}
+
+func TestCloseWhileReading(t *testing.T) {
+	conn := dial(t, websocket.DefaultDialer, startEchoServer(t, &websocket.Upgrader{}, nil))
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, _, err := conn.ReadMessage()
+		readErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond) // let the reader block
+
+	start := time.Now()
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close took %v waiting for the peer's close frame", elapsed)
+	}
+	var closeErr *websocket.CloseError
+	if err := <-readErr; !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseNormalClosure {
+		t.Errorf("Reader got %v, want the peer's close frame", err)
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	conn := dial(t, websocket.DefaultDialer, startEchoServer(t, &websocket.Upgrader{MaxMessageSize: 16}, nil))
+
+	conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("a", 32)))
+	_, _, err := conn.ReadMessage()
+
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseMessageTooBig {
+		t.Errorf("Expected close 1009, got %v", err)
+	}
+}
+
+func TestPermessageDeflate(t *testing.T) {
+	url := startEchoServer(t, &websocket.Upgrader{EnableCompression: true, MaxMessageSize: 1 << 20}, nil)
+	conn, resp, err := (&websocket.Dialer{EnableCompression: true}).Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	if !strings.HasPrefix(resp.Headers["Sec-Websocket-Extensions"], "permessage-deflate") {
+		t.Fatalf("Expected permessage-deflate to be negotiated, got %q", resp.Headers["Sec-Websocket-Extensions"])
+	}
+
+	for _, message := range []string{strings.Repeat("compress me ", 5000), "short", ""} {
+		conn.WriteMessage(websocket.TextMessage, []byte(message))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if string(data) != message {
+			t.Errorf("Expected %d bytes back, got %d", len(message), len(data))
+		}
+	}
+}
+
+func TestHandshakeValidation(t *testing.T) {
+	url := startEchoServer(t, &websocket.Upgrader{}, nil)
+	addr := strings.TrimSuffix(strings.TrimPrefix(url, "ws://"), "/ws")
+
+	tests := []struct {
+		name    string
+		headers string
+		status  int
+	}{
+		{"missing upgrade", "Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n", 426},
+		{"bad version", "Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 8\r\n", 426},
+		{"bad key", "Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: short\r\nSec-WebSocket-Version: 13\r\n", 400},
+		{"cross origin", "Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\nOrigin: http://evil.example\r\n", 403},
+		{"valid", "Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n", 101},
+	}
+
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Could not connect: %v", err)
+		}
+		fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: %s\r\n%s\r\n", addr, tt.headers)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), "GET")
+		conn.Close()
+		if err != nil {
+			t.Fatalf("%s: could not read response: %v", tt.name, err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.status, resp.StatusCode)
+		}
+		// The RFC 6455 section 1.3 example key.
+		if tt.status == 101 && resp.Headers["Sec-Websocket-Accept"] != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+			t.Errorf("Unexpected Sec-WebSocket-Accept %q", resp.Headers["Sec-Websocket-Accept"])
+		}
+	}
+}
+
+func TestDefaultMessageSizeLimit(t *testing.T) {
+	url := startEchoServer(t, &websocket.Upgrader{}, nil)
+	conn, err := net.Dial("tcp", strings.TrimSuffix(strings.TrimPrefix(url, "ws://"), "/ws"))
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n", conn.RemoteAddr())
+	reader := bufio.NewReader(conn)
+	if resp, err := http.ReadResponse(reader, "GET"); err != nil || resp.StatusCode != 101 {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+
+	// A masked binary frame whose header claims 2^62 bytes of payload.
+	conn.Write([]byte{0x82, 0xff, 0x40, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4})
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(reader, head); err != nil || head[0] != 0x88 || head[2] != 0x03 || head[3] != 0xf1 {
+		t.Errorf("Expected a close frame with code 1009, got % x (%v)", head, err)
+	}
+}
+
+func TestDeflateBombIsLimited(t *testing.T) {
+	url := startEchoServer(t, &websocket.Upgrader{EnableCompression: true}, nil)
+	conn := dial(t, &websocket.Dialer{EnableCompression: true}, url)
+
+	// Zeros compress to a tiny fraction of their size. Compressing is slow
+	// under the race detector, so the read deadline starts afterwards.
+	conn.WriteMessage(websocket.BinaryMessage, make([]byte, websocket.DefaultMaxMessageSize+1))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadMessage()
+
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseMessageTooBig {
+		t.Errorf("Expected close 1009, got %v", err)
+	}
+}
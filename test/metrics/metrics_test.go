@@ -0,0 +1,125 @@
+package metrics_test
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/appyzdl/Netrunner/pkg/metrics"
+)
+
+func scrape(t *testing.T, reg *metrics.Registry) string {
+	t.Helper()
+	var out strings.Builder
+	if err := reg.WriteText(&out); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	return out.String()
+}
+
+func TestTextFormat(t *testing.T) {
+	reg := metrics.NewRegistry()
+	requests := reg.NewCounterVec("requests_total", "Requests by path.\nSecond line.", "path", "code")
+	requests.With("/b", "200").Add(2)
+	requests.With(`/a"\`+"\n", "404").Inc()
+	requests.With("/b", "200").Add(-5) // ignored
+
+	temp := reg.NewGauge("temperature", "")
+	temp.Set(21.5)
+	temp.Dec()
+
+	reg.NewGaugeFunc("answer", "Computed on scrape.", func() float64 { return 42 })
+	reg.NewGaugeFunc("unbounded", "", func() float64 { return math.Inf(1) })
+	reg.NewCounterVec("never_used", "Has no series.", "x")
+
+	want := `# HELP answer Computed on scrape.
+# TYPE answer gauge
+answer 42
+# HELP requests_total Requests by path.\nSecond line.
+# TYPE requests_total counter
+requests_total{path="/a\"\\\n",code="404"} 1
+requests_total{path="/b",code="200"} 2
+# TYPE temperature gauge
+temperature 20.5
+# TYPE unbounded gauge
+unbounded +Inf
+`
+	if got := scrape(t, reg); got != want {
+		t.Errorf("Got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	reg := metrics.NewRegistry()
+	h := reg.NewHistogramVec("latency_seconds", "Latency.", []float64{1, 0.1, math.Inf(1)}, "route")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		h.With("/x").Observe(v)
+	}
+
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/x",le="0.1"} 2
+latency_seconds_bucket{route="/x",le="1"} 3
+latency_seconds_bucket{route="/x",le="+Inf"} 4
+latency_seconds_sum{route="/x"} 3.65
+latency_seconds_count{route="/x"} 4
+`
+	if got := scrape(t, reg); got != want {
+		t.Errorf("Got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	reg := metrics.NewRegistry()
+	a := reg.NewCounterVec("shared_total", "", "x")
+	b := reg.NewCounterVec("shared_total", "", "x")
+	a.With("1").Inc()
+	if got := b.With("1").Value(); got != 1 {
+		t.Errorf("Registering again should share the counter, got %v", got)
+	}
+
+	for name, register := range map[string]func(){
+		"different type":   func() { reg.NewGaugeVec("shared_total", "", "x") },
+		"different labels": func() { reg.NewCounterVec("shared_total", "", "y") },
+		"invalid name":     func() { reg.NewCounter("bad-name", "") },
+		"reserved label":   func() { reg.NewHistogramVec("h", "", nil, "le") },
+		"wrong arity":      func() { a.With("1", "2") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", name)
+				}
+			}()
+			register()
+		}()
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	reg := metrics.NewRegistry()
+	counter := reg.NewCounterVec("hits_total", "", "worker")
+	hist := reg.NewHistogram("sizes", "", nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				counter.With("w").Inc()
+				hist.Observe(0.5)
+			}
+			scrape(t, reg)
+		}()
+	}
+	wg.Wait()
+
+	if got := counter.With("w").Value(); got != 8000 {
+		t.Errorf("Counter = %v, want 8000", got)
+	}
+	if !strings.Contains(scrape(t, reg), "sizes_sum 4000\n") {
+		t.Errorf("Histogram sum wrong:\n%s", scrape(t, reg))
+	}
+}
@@ -0,0 +1,238 @@
+package proxyproto_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+	"github.com/appyzdl/Netrunner/pkg/proxyproto"
+	"github.com/appyzdl/Netrunner/pkg/tcp"
+)
+
+func tlv(t byte, value []byte) []byte {
+	return append([]byte{t, byte(len(value) >> 8), byte(len(value))}, value...)
+}
+
+// v2Header builds a v2 PROXY header for TCP over IPv4 with the given TLVs,
+// adding a valid CRC32C TLV if withCRC is set.
+func v2Header(src, dst string, srcPort, dstPort uint16, tlvs []byte, withCRC bool) []byte {
+	addrs := append(net.ParseIP(src).To4(), net.ParseIP(dst).To4()...)
+	addrs = binary.BigEndian.AppendUint16(addrs, srcPort)
+	addrs = binary.BigEndian.AppendUint16(addrs, dstPort)
+	payload := append(addrs, tlvs...)
+	if withCRC {
+		payload = append(payload, tlv(proxyproto.TypeCRC32C, make([]byte, 4))...)
+	}
+
+	header := []byte("\r\n\r\n\x00\r\nQUIT\n")
+	header = append(header, 0x21, 0x11)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	header = append(header, payload...)
+	if withCRC {
+		sum := crc32.Checksum(header, crc32.MakeTable(crc32.Castagnoli))
+		binary.BigEndian.PutUint32(header[len(header)-4:], sum)
+	}
+	return header
+}
+
+func TestReadHeaderV1(t *testing.T) {
+	tests := []struct {
+		line   string
+		source string
+		dest   string
+	}{
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", "192.0.2.1:56324", "198.51.100.1:443"},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 4711 80\r\n", "[2001:db8::1]:4711", "[2001:db8::2]:80"},
+		{"PROXY UNKNOWN\r\n", "", ""},
+	}
+	for _, tt := range tests {
+		h, err := proxyproto.ReadHeader(bufio.NewReader(strings.NewReader(tt.line + "GET /")))
+		if err != nil {
+			t.Errorf("%q: %v", tt.line, err)
+			continue
+		}
+		if h.Version != 1 || fmt.Sprint(h.Source) != orNil(tt.source) || fmt.Sprint(h.Destination) != orNil(tt.dest) {
+			t.Errorf("%q parsed as %+v", tt.line, h)
+		}
+	}
+
+	for _, bad := range []string{
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n",
+		"PROXY TCP4 2001:db8::1 198.51.100.1 1 2\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 056324 443\r\n",
+		"PROXY UDP4 192.0.2.1 198.51.100.1 1 2\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 1 2\n",
+		"PROXY " + strings.Repeat("x", 120) + "\r\n",
+	} {
+		if _, err := proxyproto.ReadHeader(bufio.NewReader(strings.NewReader(bad))); !errors.Is(err, proxyproto.ErrInvalidHeader) {
+			t.Errorf("%q: got %v, want ErrInvalidHeader", bad, err)
+		}
+	}
+}
+
+func orNil(s string) string {
+	if s == "" {
+		return "<nil>"
+	}
+	return s
+}
+
+func TestReadHeaderV2WithTLVs(t *testing.T) {
+	ssl := []byte{proxyproto.ClientSSL | proxyproto.ClientCertConn, 0, 0, 0, 0}
+	ssl = append(ssl, tlv(proxyproto.SubtypeSSLVersion, []byte("TLSv1.3"))...)
+	ssl = append(ssl, tlv(proxyproto.SubtypeSSLCN, []byte("client.example.com"))...)
+	tlvs := append(tlv(proxyproto.TypeALPN, []byte("h2")), tlv(proxyproto.TypeAuthority, []byte("example.com"))...)
+	tlvs = append(tlvs, tlv(proxyproto.TypeSSL, ssl)...)
+
+	raw := v2Header("192.0.2.1", "198.51.100.1", 56324, 443, tlvs, true)
+	h, err := proxyproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		t.Fatalf("ReadHeader failed: %v", err)
+	}
+	if h.Version != 2 || h.Command != proxyproto.Proxy || h.Source.String() != "192.0.2.1:56324" || h.Destination.String() != "198.51.100.1:443" {
+		t.Errorf("Parsed %+v", h)
+	}
+	if h.ALPN() != "h2" || h.Authority() != "example.com" {
+		t.Errorf("ALPN %q, authority %q", h.ALPN(), h.Authority())
+	}
+	info := h.TLS()
+	if info == nil || !info.Verified || info.Version != "TLSv1.3" || info.CommonName != "client.example.com" {
+		t.Errorf("TLS info = %+v", info)
+	}
+
+	// A corrupted header fails the checksum.
+	raw[20]++
+	if _, err := proxyproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw))); !errors.Is(err, proxyproto.ErrInvalidHeader) {
+		t.Errorf("Corrupted header: got %v, want a CRC error", err)
+	}
+
+	// LOCAL connections carry no addresses.
+	local := v2Header("192.0.2.1", "198.51.100.1", 1, 2, nil, false)
+	local[12] = 0x20
+	h, err = proxyproto.ReadHeader(bufio.NewReader(bytes.NewReader(local)))
+	if err != nil || h.Command != proxyproto.Local || h.Source != nil {
+		t.Errorf("LOCAL header parsed as %+v, %v", h, err)
+	}
+}
+
+// startListener serves HTTP on a proxyproto listener and returns its
+// address and the client addresses the handler saw.
+func startListener(t *testing.T, configure func(*proxyproto.Listener), trusted ...string) (string, <-chan string) {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	listener, err := proxyproto.NewListener(inner, trusted...)
+	if err != nil {
+		t.Fatalf("NewListener failed: %v", err)
+	}
+	if configure != nil {
+		configure(listener)
+	}
+
+	seen := make(chan string, 1)
+	server := http.NewServer(inner.Addr().String(), func(req *http.Request) *http.Response {
+		seen <- req.RemoteAddr + " " + req.LocalAddr
+		resp := http.NewResponse()
+		resp.StatusCode = 200
+		return resp
+	})
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return inner.Addr().String(), seen
+}
+
+func send(t *testing.T, addr string, data []byte) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write(data)
+	reply, _ := bufio.NewReader(conn).ReadString('\n')
+	return reply
+}
+
+const request = "GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"
+
+func TestHTTPServerBehindProxy(t *testing.T) {
+	addr, seen := startListener(t, nil, "127.0.0.0/8")
+
+	reply := send(t, addr, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"+request))
+	if !strings.HasPrefix(reply, "HTTP/1.1 200") {
+		t.Fatalf("Got %q", reply)
+	}
+	if got := <-seen; got != "192.0.2.1:56324 198.51.100.1:443" {
+		t.Errorf("Handler saw %q, want the addresses from the header", got)
+	}
+
+	header := v2Header("203.0.113.5", "198.51.100.1", 1234, 80, nil, false)
+	send(t, addr, append(header, request...))
+	if got := <-seen; got != "203.0.113.5:1234 198.51.100.1:80" {
+		t.Errorf("Handler saw %q for a v2 header", got)
+	}
+
+	// Without a header the peer's own address is used.
+	send(t, addr, []byte(request))
+	if got := <-seen; !strings.HasPrefix(got, "127.0.0.1:") {
+		t.Errorf("Handler saw %q without a header", got)
+	}
+}
+
+func TestUntrustedSourceRejected(t *testing.T) {
+	addr, seen := startListener(t, nil, "10.0.0.0/8")
+
+	if reply := send(t, addr, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"+request)); strings.HasPrefix(reply, "HTTP/1.1 200") {
+		t.Errorf("Untrusted header was served: %q", reply)
+	}
+	send(t, addr, []byte(request))
+	if got := <-seen; !strings.HasPrefix(got, "127.0.0.1:") {
+		t.Errorf("Plain request from an untrusted peer saw %q", got)
+	}
+}
+
+func TestRequiredHeader(t *testing.T) {
+	addr, seen := startListener(t, func(l *proxyproto.Listener) { l.Required = true }, "127.0.0.1")
+	if reply := send(t, addr, []byte(request)); strings.HasPrefix(reply, "HTTP/1.1 200") {
+		t.Errorf("Request without a required header was served: %q", reply)
+	}
+	select {
+	case got := <-seen:
+		t.Errorf("Handler ran for %q", got)
+	default:
+	}
+}
+
+func TestTCPServerBehindProxy(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	listener, _ := proxyproto.NewListener(inner, "127.0.0.1")
+	go tcp.Serve(listener)
+	t.Cleanup(func() { listener.Close() })
+
+	conn, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 7\r\nhello\n")
+	reply, _ := bufio.NewReader(conn).ReadString('\n')
+	if reply != "hello\n" {
+		t.Errorf("Echo got %q, want the data after the header", reply)
+	}
+}
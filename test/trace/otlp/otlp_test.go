@@ -0,0 +1,111 @@
+package otlp_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/appyzdl/Netrunner/pkg/http"
+	"github.com/appyzdl/Netrunner/pkg/trace"
+	"github.com/appyzdl/Netrunner/pkg/trace/otlp"
+)
+
+// startCollector stands in for an OpenTelemetry collector, passing each
+// export request to the channel and answering with status.
+func startCollector(t *testing.T, status int) (string, <-chan *http.Request) {
+	t.Helper()
+	received := make(chan *http.Request, 4)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	server := http.NewServer(listener.Addr().String(), func(req *http.Request) *http.Response {
+		req.ReadBody()
+		received <- req
+		resp := http.NewResponse()
+		resp.SetStatus(status)
+		resp.SetBody([]byte("{}"))
+		return resp
+	})
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return "http://" + listener.Addr().String() + "/v1/traces", received
+}
+
+func TestExportJSON(t *testing.T) {
+	endpoint, received := startCollector(t, 200)
+	exporter := otlp.NewExporter(endpoint, "checkout")
+	exporter.Headers = map[string]string{"Authorization": "Bearer token"}
+	tracer := trace.NewTracer(exporter)
+
+	parent, _ := trace.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	parent.TraceState = "vendor=1"
+	_, span := tracer.Start(trace.ContextWithRemoteSpanContext(context.Background(), parent), "GET /cart",
+		trace.WithKind(trace.KindServer),
+		trace.WithAttributes(trace.String("url.path", "/cart"), trace.Int("http.response.status_code", 200),
+			trace.Float64("ratio", 0.5), trace.Bool("cached", true)))
+	span.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	req := <-received
+	if req.Method != "POST" || req.Path != "/v1/traces" || req.Headers["Content-Type"] != "application/json" || req.Headers["Authorization"] != "Bearer token" {
+		t.Errorf("Export request %s %s with headers %v", req.Method, req.Path, req.Headers)
+	}
+
+	var body struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []map[string]any `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []map[string]any `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(req.Body, &body); err != nil {
+		t.Fatalf("Bad JSON %s: %v", req.Body, err)
+	}
+	rs := body.ResourceSpans[0]
+	if got := rs.Resource.Attributes[0]; got["key"] != "service.name" || got["value"].(map[string]any)["stringValue"] != "checkout" {
+		t.Errorf("Resource attributes %v", rs.Resource.Attributes)
+	}
+	s := rs.ScopeSpans[0].Spans[0]
+	for key, want := range map[string]any{
+		"traceId":      "4bf92f3577b34da6a3ce929d0e0e4736",
+		"parentSpanId": "00f067aa0ba902b7",
+		"traceState":   "vendor=1",
+		"name":         "GET /cart",
+		"kind":         float64(2),
+	} {
+		if s[key] != want {
+			t.Errorf("%s = %v, want %v", key, s[key], want)
+		}
+	}
+	if _, ok := s["startTimeUnixNano"].(string); !ok {
+		t.Errorf("Timestamps must be strings, got %T", s["startTimeUnixNano"])
+	}
+	attrs, _ := json.Marshal(s["attributes"])
+	for _, want := range []string{
+		`{"key":"url.path","value":{"stringValue":"/cart"}}`,
+		`{"key":"http.response.status_code","value":{"intValue":"200"}}`,
+		`{"key":"ratio","value":{"doubleValue":0.5}}`,
+		`{"key":"cached","value":{"boolValue":true}}`,
+	} {
+		if !strings.Contains(string(attrs), want) {
+			t.Errorf("Attributes %s lack %s", attrs, want)
+		}
+	}
+}
+
+func TestExportError(t *testing.T) {
+	endpoint, _ := startCollector(t, 503)
+	exporter := otlp.NewExporter(endpoint, "checkout")
+	err := exporter.ExportSpans(context.Background(), []*trace.SpanData{{Name: "x"}})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Got %v, want the collector's status", err)
+	}
+}
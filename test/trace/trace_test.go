@@ -0,0 +1,169 @@
+package trace_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appyzdl/Netrunner/pkg/trace"
+)
+
+// recorder is an Exporter that keeps what it is given.
+type recorder struct {
+	mu    sync.Mutex
+	spans []*trace.SpanData
+	calls int
+}
+
+func (r *recorder) ExportSpans(ctx context.Context, spans []*trace.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	r.calls++
+	return nil
+}
+
+func (r *recorder) byName(t *testing.T, name string) *trace.SpanData {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	t.Fatalf("No span named %q", name)
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := trace.ParseTraceparent(valid)
+	if err != nil {
+		t.Fatalf("ParseTraceparent failed: %v", err)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || !sc.Sampled() || !sc.Remote {
+		t.Errorf("Parsed %+v", sc)
+	}
+	if sc.Traceparent() != valid {
+		t.Errorf("Formatted as %q", sc.Traceparent())
+	}
+
+	// Future versions may append fields after version 00's.
+	if _, err := trace.ParseTraceparent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what"); err != nil {
+		t.Errorf("Future version rejected: %v", err)
+	}
+
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, err := trace.ParseTraceparent(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestParseTraceState(t *testing.T) {
+	for value, want := range map[string]string{
+		"rojo=00f067aa0ba902b7,congo=t61rcWkgMzE": "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE",
+		"rojo=1, ,congo=2":                        "rojo=1, ,congo=2",
+		"novalue":                                 "",
+		"a=b c":                                   "",
+	} {
+		if got := trace.ParseTraceState(value); got != want {
+			t.Errorf("ParseTraceState(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestSamplers(t *testing.T) {
+	var sampled int
+	tracer := trace.NewTracer(&recorder{})
+	defer tracer.Shutdown(context.Background())
+	tracer.Sampler = trace.TraceIDRatio(0.25)
+	for i := 0; i < 4000; i++ {
+		_, span := tracer.Start(context.Background(), "root")
+		if span.IsRecording() {
+			sampled++
+		}
+	}
+	if sampled < 800 || sampled > 1200 {
+		t.Errorf("Sampled %d of 4000 at ratio 0.25", sampled)
+	}
+
+	// Children follow a remote parent's decision under ParentBased.
+	tracer.Sampler = trace.ParentBased(trace.NeverSample())
+	parent, _ := trace.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, span := tracer.Start(trace.ContextWithRemoteSpanContext(context.Background(), parent), "child")
+	if !span.IsRecording() || span.SpanContext().TraceID != parent.TraceID {
+		t.Errorf("Child of a sampled parent: %+v", span.SpanContext())
+	}
+	_, root := tracer.Start(context.Background(), "root")
+	if root.IsRecording() {
+		t.Error("Root span sampled by parentbased_always_off")
+	}
+	if !root.SpanContext().IsValid() {
+		t.Error("Unsampled spans still need IDs to propagate")
+	}
+
+	for _, tt := range []struct{ name, arg string }{{"nonsense", ""}, {"traceidratio", "2"}} {
+		if _, err := trace.ParseSampler(tt.name, tt.arg); err == nil {
+			t.Errorf("ParseSampler(%q, %q) accepted", tt.name, tt.arg)
+		}
+	}
+}
+
+func TestSpansAndExport(t *testing.T) {
+	rec := &recorder{}
+	tracer := trace.NewTracer(rec)
+
+	ctx, root := tracer.Start(context.Background(), "root", trace.WithKind(trace.KindServer))
+	_, child := tracer.Start(ctx, "child", trace.WithAttributes(trace.String("k", "v")))
+	child.RecordError(errors.New("boom"))
+	child.End()
+	child.End() // ignored
+	root.End()
+
+	var nilSpan *trace.Span
+	nilSpan.SetAttributes(trace.Int("ignored", 1))
+	nilSpan.End()
+
+	tracer.Flush()
+	if len(rec.spans) != 2 {
+		t.Fatalf("Exported %d spans, want 2", len(rec.spans))
+	}
+	rootData, childData := rec.byName(t, "root"), rec.byName(t, "child")
+	if childData.Parent != rootData.SpanContext.SpanID || childData.SpanContext.TraceID != rootData.SpanContext.TraceID {
+		t.Errorf("Child %+v isn't a child of %+v", childData.SpanContext, rootData.SpanContext)
+	}
+	if rootData.Kind != trace.KindServer || childData.StatusCode != trace.StatusError || len(childData.Events) != 1 {
+		t.Errorf("Root kind %d, child status %d events %v", rootData.Kind, childData.StatusCode, childData.Events)
+	}
+	if rootData.End.Before(rootData.Start) {
+		t.Error("Span ended before it started")
+	}
+
+	// Shutdown exports what's left and drops later spans.
+	_, late := tracer.Start(context.Background(), "late")
+	late.End()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	rec.byName(t, "late")
+	_, after := tracer.Start(context.Background(), "after")
+	after.End()
+	if tracer.Dropped() != 1 {
+		t.Errorf("Dropped = %d, want 1", tracer.Dropped())
+	}
+}